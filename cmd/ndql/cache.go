@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/berquerant/ndql/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the persistent result cache",
+	Long: `Manage the persistent result cache.
+
+The results of sh with the cache option, like sh(script, 'cache'), are stored under --cache_dir.
+The cache is keyed by the script and the path, size and modification time of the file.`,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return cmd.Help()
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove cached results and print the number of removed entries",
+	Long: `Remove cached results and print the number of removed entries.
+Only the entries written by the cache are removed, the other files under --cache_dir are kept.
+
+Remove all entries:
+
+    ndql cache prune
+
+Remove the entries not used for a day:
+
+    ndql cache prune --older_than 24h`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMain(cmd, args, config.ModeCachePrune)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+	initFlags(cachePruneCmd)
+	cachePruneCmd.Flags().String("older_than", "", "remove only the entries not used for this duration like 24h, all entries if empty")
+}
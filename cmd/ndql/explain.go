@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/berquerant/ndql"
 	"github.com/berquerant/ndql/pkg/gopkg"
 	"github.com/spf13/cobra"
 )
@@ -38,7 +39,7 @@ ndql explain syntax.functions.expr`,
 			fmt.Println(explainShowKeys())
 			return nil
 		}
-		d, ok := documentSet.Get(args[0])
+		d, ok := documents().Get(args[0])
 		if !ok {
 			fmt.Println(explainShowKeys())
 			return fmt.Errorf("%s is not found", args[0])
@@ -49,10 +50,16 @@ ndql explain syntax.functions.expr`,
 }
 
 func explainShowKeys() string {
-	keys := documentSet.Keys()
+	keys := documents().Keys()
 	xs := make([]string, len(keys))
 	for i, k := range keys {
 		xs[i] = "- " + k
 	}
 	return fmt.Sprintf("Available keys:\n%s", strings.Join(xs, "\n"))
 }
+
+// documents returns the documents of ndql and the functions registered in functionRegistry.
+func documents() *gopkg.DocumentSet {
+	documentSet.Add(ndql.FunctionDocuments(functionRegistry)...)
+	return &documentSet
+}
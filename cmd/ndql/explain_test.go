@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/berquerant/ndql/pkg/node"
+	"github.com/berquerant/ndql/pkg/tree"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocuments(t *testing.T) {
+	if !assert.Nil(t, functionRegistry.RegisterScalar(tree.ScalarFunction{
+		Name:    "is_text",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Doc:     "is_text(path: String) -> Bool",
+		Call: func(xs ...node.Data) (node.Data, error) {
+			return node.Bool(true), nil
+		},
+	})) {
+		return
+	}
+
+	for _, tc := range []struct {
+		title string
+		key   string
+		want  string
+	}{
+		{
+			title: "registered",
+			key:   "syntax.functions.is_text",
+			want:  "is_text(path: String) -> Bool",
+		},
+		{
+			title: "builtin",
+			key:   "syntax.functions.expr",
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			got, ok := documents().Get(tc.key)
+			if !assert.True(t, ok) {
+				return
+			}
+			assert.Contains(t, got, tc.want)
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/berquerant/ndql/pkg/config"
@@ -10,10 +11,16 @@ import (
 	"github.com/berquerant/ndql/pkg/util"
 	"github.com/berquerant/structconfig"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 func initFlags(cmd *cobra.Command) {
+	// accept --no-cache as well as --no_cache
+	cmd.Flags().SetNormalizeFunc(func(_ *pflag.FlagSet, name string) pflag.NormalizedName {
+		return pflag.NormalizedName(strings.ReplaceAll(name, "-", "_"))
+	})
 	util.FailOnError(structconfig.New[config.Config]().SetFlags(cmd.Flags()))
+	cmd.Flags().StringArray("param", nil, "name=value to bind to the placeholder :name, or to the n-th ? if the name is n; repeatable")
 }
 
 func newConfig(cmd *cobra.Command, args []string) (*config.Config, error) {
@@ -22,12 +29,31 @@ func newConfig(cmd *cobra.Command, args []string) (*config.Config, error) {
 		return nil, err
 	}
 
+	params, err := cmd.Flags().GetStringArray("param")
+	if err != nil {
+		return nil, err
+	}
+
+	c.Params = params
+	c.OlderThan = commandFlag(cmd, "older_than")
+	c.Format = commandFlag(cmd, "format")
+	c.History = commandFlag(cmd, "history")
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
+	c.Functions = functionRegistry
+	c.Documents = documents()
 	c.SetupLogger()
 	return &c, nil
 }
 
+// commandFlag returns the value of the flag registered only on some commands, empty if not registered.
+func commandFlag(cmd *cobra.Command, name string) string {
+	if f := cmd.Flags().Lookup(name); f != nil {
+		return f.Value.String()
+	}
+	return ""
+}
+
 func runMain(cmd *cobra.Command, args []string, mode config.Mode) error {
 	c, err := newConfig(cmd, args)
 	if err != nil {
@@ -36,7 +62,12 @@ func runMain(cmd *cobra.Command, args []string, mode config.Mode) error {
 	}
 	c.Args = args
 	c.Mode = mode
-	ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGPIPE)
+	signals := []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGPIPE}
+	if mode == config.ModeRepl {
+		// repl cancels only the running query on SIGINT
+		signals = signals[1:]
+	}
+	ctx, stop := signal.NotifyContext(cmd.Context(), signals...)
 	defer stop()
 	return run.Main(ctx, c)
 }
@@ -8,6 +8,7 @@ import (
 	_ "github.com/pingcap/tidb/pkg/types/parser_driver"
 
 	"github.com/berquerant/ndql/pkg/errorx"
+	"github.com/berquerant/ndql/pkg/tree"
 	"github.com/spf13/cobra"
 )
 
@@ -19,6 +20,9 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// functionRegistry holds the functions callable in queries.
+var functionRegistry = tree.NewFunctionRegistry()
+
 func init() {
 	initFlags(rootCmd)
 }
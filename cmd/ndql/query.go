@@ -29,18 +29,42 @@ Add a "count" column for the number of times 'keyword' appears in the file:
 
     ndql query 'select sh("echo count=$(grep -c keyword $(get path))") where not is_dir'
 
-Roughly list the Go func in the file except tests:
+Roughly list the Go func in the file except tests with the line numbers:
 
-    ndql query 'select path, func from (select grep("func (?P<name>[^(]+)", "func=$name") where not is_dir and path not like "%%_test.go")' dir
+    ndql query 'select path, func, line from (select grep("func (?P<name>[^(]+)", "func=$name,line=$line") where not is_dir and path not like "%%_test.go")' dir
 
-Add the line number where each func is defined as 'line':
+List the lines where each func is defined with the line numbers:
 
-    ndql query 'select path, func from (select grep("func (?P<name>[^(]+)", "func=$name") where not is_dir and path not like "%%_test.go")' dir | ndql query 'select sh("echo line=$(grep -n \"func $(get func)\" $(get path) | cut -d: -f1)")' -i@-
+    ndql query 'select path, line_no, line from (select read_lines(path) where not is_dir and path not like "%%_test.go") where line like "func %%"' dir
 
 Roughly lists the names and values of variables exported in bash format. The search is restricted only to files tracked by git:
 
     git ls-files | ndql query 'select grep("export (?P<name>[^=]+)=(?P<value>.+)", "name=$name,value=$value") where not is_dir' @-
 
+List Go files except tests by a macro, which can also be defined in the rc file (--rc):
+
+    ndql query 'define go_file(p) as extension(p) = ".go" and p not like "%%_test.go"; select path where go_file(path)' dir
+
+List the files with the extension given by a parameter, which is safe from quoting:
+
+    ndql query --param ext=.go --param 1=1024 'select path where extension(path) = :ext and size > ?' dir
+
+Fail on the first row that cannot be evaluated, like a typo in the column or a failing sh(), instead of dropping it:
+
+    ndql query --strict 'select path, sizee where not is_dir' dir
+
+Write the rows that fail to be evaluated with the failing expression, the error class and the stderr of sh() to a file:
+
+    ndql query --errors-to errors.json 'select sh("ffprobe -v error -of json -show_format \"$(get path)\"")' dir
+
+Run sh() concurrently but write the results in the same order as without -c, for stable output:
+
+    ndql query -c 8 --ordered 'select sh("echo lines=$(wc -l < \"$(get path)\")") where not is_dir' dir
+
+Show the query rewritten by the optimizer, or disable it by --no_optimize:
+
+    ndql query --debug 'select sh("echo x") from (select *) where extension(path) = ".go"' dir
+
 Extracts metadata from mp3 and m4a files using ffprobe:
 
     ndql query 'select sh("ffprobe -v error -hide_banner -show_entries format -of json=c=1 \"$(get path)\" | jq .format.tags -c") where not is_dir and extension(path) in (".mp3", ".m4a")' dir
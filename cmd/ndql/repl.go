@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/berquerant/ndql/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var replCmd = &cobra.Command{
+	Use:   "repl [PATH]",
+	Short: "Run queries interactively",
+	Long: fmt.Sprintf(`Run queries interactively.
+
+Read the rows from PATH or the index once, then run the queries terminated by ; against them.
+The macros defined by define and the variables set by SET are available in the following queries.
+Tab completes function names, macro names, keywords and column names.
+Ctrl-C cancels the running query, Ctrl-D exits.
+
+Meta commands:
+
+    .help           show help
+    .explain [KEY]  describe ndql resources like ndql explain
+    .schema [QUERY] describe the columns of the loaded rows, or of the result of the query like ndql schema
+    .quit, .exit    exit
+
+The history is saved to --history.
+
+## PATH
+%s
+
+stdin is not available because the queries are read from it.
+
+## Examples
+
+    ndql repl dir
+    ndql repl -i @index.json
+`, config.DescribeSourceUsage()),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMain(cmd, args, config.ModeRepl)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replCmd)
+	initFlags(replCmd)
+	replCmd.Flags().String("history", "", "history file, default is ndql/repl_history under the user cache directory")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/berquerant/ndql/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema [QUERY [PATH]]",
+	Short: "Describe the columns of the index or the result of the query",
+	Long: fmt.Sprintf(`Describe the columns of the index or the result of the query.
+
+Report every key with the observed types, the rates of the rows where the value is null or the key is missing,
+the min and max values, and some sample values.
+The output format is table or json (--format), json is a column per line.
+
+## QUERY, PATH
+%s
+
+## Examples
+
+Describe the index:
+
+    ndql schema -i @index.json
+
+Describe the result of the query:
+
+    ndql schema 'select grep("(?P<key>[A-Z_]+)=(?P<value>.*)", "key=$key,value=$value") where not is_dir' dir
+
+Describe the result of the query as JSON:
+
+    ndql query 'select *' dir | ndql schema -i @- --format json
+`, config.DescribeSourceUsage()),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMain(cmd, args, config.ModeSchema)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+	initFlags(schemaCmd)
+	schemaCmd.Flags().String("format", "table", "output format, table or json")
+}
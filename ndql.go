@@ -0,0 +1,444 @@
+// Package ndql runs ndql queries in-process.
+//
+//	for n, err := range ndql.Query(ctx, "select path where size > 1024", ndql.FromPath("dir")) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(n)
+//	}
+//
+// The package does not set up loggers or write to stdout;
+// logs go to the default slog logger.
+package ndql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"log/slog"
+
+	"github.com/berquerant/ndql/pkg/cachex"
+	"github.com/berquerant/ndql/pkg/config"
+	"github.com/berquerant/ndql/pkg/gopkg"
+	"github.com/berquerant/ndql/pkg/iox"
+	"github.com/berquerant/ndql/pkg/iterx"
+	"github.com/berquerant/ndql/pkg/node"
+	"github.com/berquerant/ndql/pkg/parse"
+	"github.com/berquerant/ndql/pkg/plan"
+	"github.com/berquerant/ndql/pkg/tree"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+)
+
+var (
+	ErrParse  = parse.ErrParse
+	ErrSource = errors.New("Source")
+)
+
+// Source is the input of a query.
+type Source struct {
+	nodes  func() (iter.Seq[*node.Node], error)
+	walker iox.Walker // nil unless FromWalker, for the filter of the optimizer
+	fsys   fs.FS      // nil means the host filesystem
+}
+
+// FromWalker returns a source that reads the entries of w.
+// If w implements iox.FSProvider, the contents of the files are read from its filesystem.
+func FromWalker(w iox.Walker) Source {
+	s := Source{
+		nodes: func() (iter.Seq[*node.Node], error) {
+			return config.ReadInputFromWalker(w), nil
+		},
+		walker: w,
+	}
+	if p, ok := w.(iox.FSProvider); ok {
+		s.fsys = p.FS()
+	}
+	return s
+}
+
+// FromPath returns a source that walks the file tree from root.
+func FromPath(root string) Source {
+	return FromWalker(iox.NewPathWalker(root))
+}
+
+// FromFS returns a source that walks the file tree of fsys from root.
+func FromFS(fsys fs.FS, root string) Source {
+	return FromWalker(iox.NewFSWalker(fsys, root))
+}
+
+// FromIndex returns a source that reads nodes from r, a JSON per line like the index of the CLI.
+func FromIndex(r io.Reader) Source {
+	return Source{
+		nodes: func() (iter.Seq[*node.Node], error) {
+			return config.ReadInputFromSource(iox.NewReaderSourceFromReader(r), true), nil
+		},
+	}
+}
+
+// FromNodes returns a source that yields the nodes.
+func FromNodes(it iter.Seq[*node.Node]) Source {
+	return Source{
+		nodes: func() (iter.Seq[*node.Node], error) {
+			return it, nil
+		},
+	}
+}
+
+const (
+	DefaultContentCacheBytes = 256 << 20
+	DefaultStreamThreshold   = 8 << 20
+)
+
+type options struct {
+	concurrency       int
+	ordered           bool
+	rawKeys           bool
+	contentCacheBytes int64
+	streamThreshold   int64
+	resultCacheDir    string
+	functions         *tree.FunctionRegistry
+	macros            *parse.MacroSet
+	defineMacros      bool
+	params            *tree.Variables
+	variables         *tree.Variables
+	maxErrors         int
+	rowErrors         *tree.RowErrors
+	rowErrorHandler   func(*tree.RowError)
+	noOptimize        bool
+}
+
+// Option configures Query.
+type Option func(*options)
+
+// WithConcurrency sets the number of goroutines to process each statement, 1 by default.
+// The order of the results is not preserved if n > 1, unless WithOrdered.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// WithOrdered makes Query yield the results in the same order as with concurrency 1,
+// while the rows are still processed concurrently.
+func WithOrdered(v bool) Option {
+	return func(o *options) {
+		o.ordered = v
+	}
+}
+
+// WithRawKeys makes Query yield the internal keys of the attributes of tables as they are,
+// instead of the names like table.column.
+func WithRawKeys(v bool) Option {
+	return func(o *options) {
+		o.rawKeys = v
+	}
+}
+
+// WithContentCache sets the maximum total bytes of file contents cached in memory,
+// and the size of files to be read by streaming instead of being cached.
+func WithContentCache(maxBytes, streamThreshold int64) Option {
+	return func(o *options) {
+		o.contentCacheBytes = maxBytes
+		o.streamThreshold = streamThreshold
+	}
+}
+
+// WithResultCache enables the persistent result cache in dir, disabled by default.
+// The cache stores the results of sh with the cache option.
+func WithResultCache(dir string) Option {
+	return func(o *options) {
+		o.resultCacheDir = dir
+	}
+}
+
+// WithFunctionRegistry makes the functions in r callable in the query,
+// only the builtin functions by default.
+func WithFunctionRegistry(r *tree.FunctionRegistry) Option {
+	return func(o *options) {
+		o.functions = r
+	}
+}
+
+// FunctionDocuments returns the documents of the functions in r that have them,
+// in the same form as the documents of ndql explain, like syntax.functions.NAME.
+func FunctionDocuments(r *tree.FunctionRegistry) []*gopkg.Document {
+	xs := r.Documents()
+	docs := make([]*gopkg.Document, len(xs))
+	for i, x := range xs {
+		docs[i] = &gopkg.Document{
+			Path:  "syntax.functions." + x.Name,
+			Title: x.Name,
+			Text:  x.Doc,
+		}
+	}
+	return docs
+}
+
+// WithMacros makes the macros in s callable in the query,
+// in addition to the macros defined in the query.
+func WithMacros(s *parse.MacroSet) Option {
+	return func(o *options) {
+		o.macros = s
+	}
+}
+
+// WithDefineMacros makes the macros defined in the query added to the set of WithMacros,
+// so that they are callable in the following queries with the same set.
+func WithDefineMacros(v bool) Option {
+	return func(o *options) {
+		o.defineMacros = v
+	}
+}
+
+// WithVariables makes Query resolve and set the variables in v instead of the new ones,
+// so that the values of the SET statements are available in the following queries with v.
+// The parameters of WithParam are also set in v.
+func WithVariables(v *tree.Variables) Option {
+	return func(o *options) {
+		o.variables = v
+	}
+}
+
+// WithParam binds the value to the placeholder :name, or to the n-th ? if the name is n.
+// The SET statements in the query cannot change the values given by this, they fail on the names of the parameters.
+func WithParam(name string, value node.Data) Option {
+	return func(o *options) {
+		o.params.SetParam(name, value)
+	}
+}
+
+// WithMaxErrors makes Query fail when the number of the rows that fail to be evaluated exceeds n,
+// 0 means the first error fails. Negative n, the default, drops all such rows.
+//
+// Where clauses evaluated to neither Bool nor Null are also errors if n is not negative.
+func WithMaxErrors(n int) Option {
+	return func(o *options) {
+		o.maxErrors = n
+	}
+}
+
+// WithRowErrorHandler makes Query call f with every row that fails to be evaluated, not concurrently.
+func WithRowErrorHandler(f func(*tree.RowError)) Option {
+	return func(o *options) {
+		o.rowErrorHandler = f
+	}
+}
+
+// WithOptimize enables the optimizer of the statements, enabled by default.
+// The optimizer evaluates the cheap conditions early and pushes them into the subqueries and the walker of FromWalker.
+func WithOptimize(v bool) Option {
+	return func(o *options) {
+		o.noOptimize = !v
+	}
+}
+
+// Query runs the sql against the source and yields the resulting nodes.
+//
+// If the sql contains multiple statements, they are run in order against the same input.
+// Rows that fail to be evaluated are dropped unless WithMaxErrors.
+// SET statements assign the variables available in the following statements.
+// The error is yielded at most once, as the last element, when the query cannot be run or ctx is done.
+func Query(ctx context.Context, sql string, src Source, opts ...Option) iter.Seq2[*node.Node, error] {
+	o := &options{
+		concurrency:       1,
+		contentCacheBytes: DefaultContentCacheBytes,
+		streamThreshold:   DefaultStreamThreshold,
+		params:            tree.NewVariables(),
+		maxErrors:         -1,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(yield func(*node.Node, error) bool) {
+		p, err := parse.NewSQLParserWithMacros(o.macros).Parse(sql)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		if o.defineMacros && o.macros != nil {
+			o.macros.Merge(p.Macros)
+		}
+		if src.nodes == nil {
+			yield(nil, fmt.Errorf("%w: no source", ErrSource))
+			return
+		}
+		var filter iox.WalkFilter
+		if !o.noOptimize {
+			filter = optimize(p.Nodes)
+		}
+		it, err := src.read(filter)
+		if err != nil {
+			yield(nil, errors.Join(ErrSource, err))
+			return
+		}
+		cit := iterx.NewClonableIter(it)
+		defer cit.Close()
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		ctx, closeContext := o.setupContext(ctx, src)
+		defer closeContext()
+
+		for i, n := range p.Nodes {
+			if x, ok := n.(*ast.SetStmt); ok {
+				if err := tree.SetVariables(ctx, x); err != nil {
+					yield(nil, fmt.Errorf("%w: statement[%d]", err, i))
+					return
+				}
+				continue
+			}
+			for r, err := range o.run(ctx, cit.Clone().Values(), n) {
+				if err != nil {
+					yield(nil, fmt.Errorf("%w: statement[%d]", err, i))
+					return
+				}
+				if !o.rawKeys {
+					r = fixNodeKeys(r)
+				}
+				if !yield(r, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// optimize rewrites the SELECT statements,
+// returns the filter of the walker if the query has only one SELECT statement.
+func optimize(nodes []parse.Node) iox.WalkFilter {
+	var (
+		selects int
+		filter  *plan.Filter
+	)
+	for i, n := range nodes {
+		x, ok := n.(*ast.SelectStmt)
+		if !ok {
+			continue
+		}
+		selects++
+		p := plan.New(x)
+		p.Optimize()
+		if y := p.Stmt(); y != x {
+			slog.Debug("Optimized", slog.Int("statement", i), slog.String("query", plan.Restore(y)))
+			nodes[i] = y
+		}
+		filter = p.Filter()
+	}
+	if selects != 1 || filter == nil {
+		return nil
+	}
+	slog.Debug("Filter walker", slog.String("filter", filter.String()))
+	return filter
+}
+
+// read returns the nodes of the source, filtered by the walker if possible.
+func (s Source) read(filter iox.WalkFilter) (iter.Seq[*node.Node], error) {
+	if s.walker != nil && filter != nil {
+		return config.ReadInputFromWalker(iox.NewFilterWalker(s.walker, filter)), nil
+	}
+	return s.nodes()
+}
+
+// setupContext sets the functions, the variables, the row errors, the caches and the pools of the generators.
+func (o *options) setupContext(ctx context.Context, src Source) (context.Context, func()) {
+	fsys := src.fsys
+	if fsys == nil {
+		fsys = iox.OSFS{}
+	}
+	ctx = iox.WithContentCache(ctx, iox.NewSizedContentCache(fsys, o.contentCacheBytes, o.streamThreshold))
+	luaPool := tree.NewLuaStatePool(o.concurrency)
+	ctx = tree.WithLuaStatePool(ctx, luaPool)
+	procPool := tree.NewProcPool(o.concurrency)
+	ctx = tree.WithProcPool(ctx, procPool)
+	if o.resultCacheDir != "" {
+		ctx = tree.WithResultCache(ctx, cachex.NewDiskCache(o.resultCacheDir))
+	}
+	if o.functions != nil {
+		ctx = tree.WithFunctionRegistry(ctx, o.functions)
+	}
+	if o.variables != nil {
+		o.variables.Merge(o.params)
+		ctx = tree.WithVariables(ctx, o.variables)
+	} else {
+		ctx = tree.WithVariables(ctx, o.params.Clone())
+	}
+	o.rowErrors = tree.NewRowErrors(o.maxErrors)
+	if o.rowErrorHandler != nil {
+		o.rowErrors.SetHandler(o.rowErrorHandler)
+	}
+	ctx = tree.WithRowErrors(ctx, o.rowErrors)
+	return ctx, func() {
+		luaPool.Close()
+		procPool.Close()
+	}
+}
+
+// run runs a statement.
+func (o *options) run(ctx context.Context, it iter.Seq[*node.Node], n parse.Node) iter.Seq2[*node.Node, error] {
+	return func(yield func(*node.Node, error) bool) {
+		if o.concurrency <= 1 {
+			rs, err := tree.AsIter(ctx, it, n)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for r := range rs {
+				if err := ctx.Err(); err != nil {
+					yield(nil, err)
+					return
+				}
+				if !yield(r, nil) {
+					return
+				}
+			}
+			if err := o.rowErrors.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+			}
+			return
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		var (
+			recvC = make(chan *node.Node, 100)
+			errC  = make(chan error, 1)
+		)
+		go func() {
+			if o.ordered {
+				errC <- tree.AsOrderedChan(ctx, it, n, o.concurrency, tree.DefaultOrderWindow, recvC)
+				return
+			}
+			errC <- tree.AsChan(ctx, it, n, o.concurrency, recvC)
+		}()
+		for r := range recvC {
+			if !yield(r, nil) {
+				cancel()
+				for range recvC {
+					// wait for the workers
+				}
+				<-errC
+				return
+			}
+		}
+		if err := <-errC; err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// fixNodeKeys replaces the internal keys with the names like table.column.
+func fixNodeKeys(n *node.Node) *node.Node {
+	r := node.New()
+	for k, v := range n.Unwrap() {
+		r.Set(tree.KeyFromString(k).Name(), v)
+	}
+	return r
+}
@@ -0,0 +1,284 @@
+package ndql_test
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/berquerant/ndql"
+	"github.com/berquerant/ndql/pkg/node"
+	"github.com/berquerant/ndql/pkg/parse"
+	"github.com/berquerant/ndql/pkg/tree"
+	"github.com/stretchr/testify/assert"
+)
+
+func collectPaths(t *testing.T, sql string, src ndql.Source, opts ...ndql.Option) ([]string, error) {
+	t.Helper()
+	var r []string
+	for n, err := range ndql.Query(context.TODO(), sql, src, opts...) {
+		if err != nil {
+			return nil, err
+		}
+		d, ok := n.Get(node.KeyPath)
+		if !assert.True(t, ok) {
+			continue
+		}
+		s, _ := d.AsOp().String()
+		r = append(r, s.Raw())
+	}
+	slices.Sort(r)
+	return r, nil
+}
+
+func TestQuery(t *testing.T) {
+	fsys := fstest.MapFS{
+		"root/a.txt":     {Data: []byte("a")},
+		"root/b.txt":     {Data: []byte("bb")},
+		"root/sub/c.log": {Data: []byte("ccc")},
+	}
+
+	t.Run("fs", func(t *testing.T) {
+		got, err := collectPaths(t, `select path where not is_dir and size > 1`, ndql.FromFS(fsys, "root"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"root/b.txt", "root/sub/c.log"}, got)
+	})
+
+	t.Run("read contents from the fs", func(t *testing.T) {
+		got, err := collectPaths(t, `select path from (select read_lines(path) where not is_dir) where line = "bb"`, ndql.FromFS(fsys, "root"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"root/b.txt"}, got)
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		got, err := collectPaths(t, `select path where not is_dir`, ndql.FromFS(fsys, "root"), ndql.WithConcurrency(4))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"root/a.txt", "root/b.txt", "root/sub/c.log"}, got)
+	})
+
+	t.Run("ordered", func(t *testing.T) {
+		var want []string
+		for n, err := range ndql.Query(context.TODO(), `select path`, ndql.FromFS(fsys, "root")) {
+			assert.Nil(t, err)
+			d, _ := n.Get(node.KeyPath)
+			want = append(want, d.Display())
+		}
+		for range 10 {
+			var got []string
+			for n, err := range ndql.Query(context.TODO(), `select path`, ndql.FromFS(fsys, "root"), ndql.WithConcurrency(4), ndql.WithOrdered(true)) {
+				assert.Nil(t, err)
+				d, _ := n.Get(node.KeyPath)
+				got = append(got, d.Display())
+			}
+			assert.Equal(t, want, got)
+		}
+	})
+
+	t.Run("optimize", func(t *testing.T) {
+		for _, tc := range []struct {
+			title string
+			sql   string
+			want  []string
+		}{
+			{
+				title: "push down before generator",
+				sql:   `select path from (select read_lines(path) where not is_dir) where extension(path) = ".txt" and line = "bb"`,
+				want:  []string{"root/b.txt"},
+			},
+			{
+				title: "filter walker",
+				sql:   `select path where path in ("root/sub/c.log", "root/x.txt") and size > 1`,
+				want:  []string{"root/sub/c.log"},
+			},
+			{
+				title: "cheap first",
+				sql:   `select path where read_file(path) = "a" and not is_dir`,
+				want:  []string{"root/a.txt"},
+			},
+		} {
+			t.Run(tc.title, func(t *testing.T) {
+				got, err := collectPaths(t, tc.sql, ndql.FromFS(fsys, "root"))
+				assert.Nil(t, err)
+				assert.Equal(t, tc.want, got)
+				got, err = collectPaths(t, tc.sql, ndql.FromFS(fsys, "root"), ndql.WithOptimize(false))
+				assert.Nil(t, err)
+				assert.Equal(t, tc.want, got)
+			})
+		}
+	})
+
+	t.Run("multiple statements", func(t *testing.T) {
+		got, err := collectPaths(t, `select path where path like "%.txt"; select path where path like "%.log"`, ndql.FromFS(fsys, "root"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"root/a.txt", "root/b.txt", "root/sub/c.log"}, got)
+	})
+
+	t.Run("index", func(t *testing.T) {
+		index := strings.Join([]string{
+			`{"path":"x","size":1,"is_dir":false,"mod_time":"2024-01-02 03:04:05","mode":"-rw-r--r--"}`,
+			`{"path":"y","size":2,"is_dir":false,"mod_time":"2024-01-02 03:04:05","mode":"-rw-r--r--"}`,
+		}, "\n")
+		got, err := collectPaths(t, `select path where size = 2`, ndql.FromIndex(strings.NewReader(index)))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"y"}, got)
+	})
+
+	t.Run("break", func(t *testing.T) {
+		for _, c := range []int{1, 4} {
+			var count int
+			for _, err := range ndql.Query(context.TODO(), `select path`, ndql.FromFS(fsys, "root"), ndql.WithConcurrency(c)) {
+				assert.Nil(t, err)
+				count++
+				break
+			}
+			assert.Equal(t, 1, count)
+		}
+	})
+
+	t.Run("function registry", func(t *testing.T) {
+		r := tree.NewFunctionRegistry()
+		if !assert.Nil(t, r.RegisterScalar(tree.ScalarFunction{
+			Name:    "is_text",
+			MinArgs: 1,
+			MaxArgs: 1,
+			Call: func(xs ...node.Data) (node.Data, error) {
+				s, _ := xs[0].AsOp().String()
+				return node.Bool(strings.HasSuffix(s.Raw(), ".txt")), nil
+			},
+		})) {
+			return
+		}
+		got, err := collectPaths(t, `select path where is_text(path)`, ndql.FromFS(fsys, "root"), ndql.WithFunctionRegistry(r))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"root/a.txt", "root/b.txt"}, got)
+	})
+
+	t.Run("function documents", func(t *testing.T) {
+		r := tree.NewFunctionRegistry()
+		if !assert.Nil(t, r.RegisterScalar(tree.ScalarFunction{
+			Name:    "is_text",
+			MinArgs: 1,
+			MaxArgs: 1,
+			Doc:     "is_text(path: String) -> Bool",
+			Call: func(xs ...node.Data) (node.Data, error) {
+				return node.Bool(true), nil
+			},
+		})) {
+			return
+		}
+		docs := ndql.FunctionDocuments(r)
+		if !assert.Len(t, docs, 1) {
+			return
+		}
+		assert.Equal(t, "syntax.functions.is_text", docs[0].Path)
+		assert.Equal(t, "is_text", docs[0].Title)
+		assert.Equal(t, "is_text(path: String) -> Bool", docs[0].Text)
+	})
+
+	t.Run("macros", func(t *testing.T) {
+		macros := parse.NewMacroSet()
+		if !assert.Nil(t, macros.Load("rc.sql", `define is_text(p) as p like "%.txt"`)) {
+			return
+		}
+		got, err := collectPaths(t, `define larger(x, n) as size > x + n;
+select path where is_text(path) and larger(0, 1)`, ndql.FromFS(fsys, "root"), ndql.WithMacros(macros))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"root/b.txt"}, got)
+	})
+
+	t.Run("session", func(t *testing.T) {
+		var (
+			macros    = parse.NewMacroSet()
+			variables = tree.NewVariables()
+			opts      = []ndql.Option{
+				ndql.WithMacros(macros),
+				ndql.WithDefineMacros(true),
+				ndql.WithVariables(variables),
+			}
+		)
+		_, err := collectPaths(t, `define is_text(p) as p like "%.txt"; set @size = 1`, ndql.FromFS(fsys, "root"), opts...)
+		assert.Nil(t, err)
+		got, err := collectPaths(t, `select path where is_text(path) and size > @size`, ndql.FromFS(fsys, "root"), opts...)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"root/b.txt"}, got)
+
+		// without the options, the definitions are available only in the query
+		_, err = collectPaths(t, `define is_log(p) as p like "%.log"; set @x = 1`, ndql.FromFS(fsys, "root"), ndql.WithMacros(macros))
+		assert.Nil(t, err)
+		_, ok := macros.Get("is_log")
+		assert.False(t, ok)
+		_, err = collectPaths(t, `select path where size > @x`, ndql.FromFS(fsys, "root"), ndql.WithVariables(variables))
+		assert.ErrorIs(t, err, tree.ErrInvalidKey)
+	})
+
+	t.Run("params", func(t *testing.T) {
+		got, err := collectPaths(t, `set @ext = ".log"; select path where size > ? and path like :pattern or extension(path) in (@ext)`, ndql.FromFS(fsys, "root"),
+			ndql.WithParam("1", node.Int(1)), ndql.WithParam("pattern", node.String("%.txt")))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"root/b.txt", "root/sub/c.log"}, got)
+	})
+
+	t.Run("set param", func(t *testing.T) {
+		_, err := collectPaths(t, `set @ext = ".txt"; select path where extension(path) = :ext`, ndql.FromFS(fsys, "root"),
+			ndql.WithParam("ext", node.String(".log")))
+		assert.ErrorIs(t, err, tree.ErrInvalidValue)
+	})
+
+	t.Run("max errors", func(t *testing.T) {
+		const sql = `select path, nosuch where not is_dir`
+		got, err := collectPaths(t, sql, ndql.FromFS(fsys, "root"))
+		assert.Nil(t, err, "drop rows by default")
+		assert.Empty(t, got)
+
+		got, err = collectPaths(t, sql, ndql.FromFS(fsys, "root"), ndql.WithMaxErrors(3))
+		assert.Nil(t, err, "tolerated")
+		assert.Empty(t, got)
+
+		for _, concurrency := range []int{1, 4} {
+			_, err = collectPaths(t, sql, ndql.FromFS(fsys, "root"), ndql.WithMaxErrors(2), ndql.WithConcurrency(concurrency))
+			assert.ErrorIs(t, err, tree.ErrTooManyRowErrors)
+			assert.ErrorIs(t, err, tree.ErrInvalidKey)
+			var rowErr *tree.RowError
+			assert.ErrorAs(t, err, &rowErr)
+		}
+
+		_, err = collectPaths(t, `select path where mod_time`, ndql.FromFS(fsys, "root"), ndql.WithMaxErrors(0))
+		assert.ErrorIs(t, err, tree.ErrInvalidValue, "not Bool in where")
+	})
+
+	t.Run("row error handler", func(t *testing.T) {
+		var classes []string
+		_, err := collectPaths(t, `select path, nosuch where not is_dir`, ndql.FromFS(fsys, "root"),
+			ndql.WithMaxErrors(3),
+			ndql.WithRowErrorHandler(func(e *tree.RowError) {
+				classes = append(classes, e.Class())
+			}),
+		)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, classes)
+		for _, c := range classes {
+			assert.Equal(t, "InvalidKey", c)
+		}
+	})
+
+	t.Run("parse error", func(t *testing.T) {
+		_, err := collectPaths(t, `select from where`, ndql.FromFS(fsys, "root"))
+		assert.ErrorIs(t, err, ndql.ErrParse)
+	})
+
+	t.Run("no source", func(t *testing.T) {
+		_, err := collectPaths(t, `select path`, ndql.Source{})
+		assert.ErrorIs(t, err, ndql.ErrSource)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+		var gotErr error
+		for _, err := range ndql.Query(ctx, `select path`, ndql.FromFS(fsys, "root")) {
+			gotErr = err
+		}
+		assert.ErrorIs(t, gotErr, context.Canceled)
+	})
+}
@@ -0,0 +1,144 @@
+package cachex
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/berquerant/ndql/pkg/logx"
+)
+
+// DiskCache is a persistent cache on the filesystem.
+// Keys should be hex digests like util.Hash.
+type DiskCache struct {
+	root string
+}
+
+func NewDiskCache(root string) *DiskCache {
+	return &DiskCache{
+		root: root,
+	}
+}
+
+// Root returns the directory of the cache.
+func (c *DiskCache) Root() string { return c.root }
+
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.root, key[:min(2, len(key))], key)
+}
+
+// Get returns the value of the key.
+// It also updates the modification time of the entry to mark it as used.
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	p := c.path(key)
+	b, err := os.ReadFile(p)
+	if err != nil {
+		logx.Trace("DiskCache miss", slog.String("key", key))
+		return nil, false
+	}
+	logx.Trace("DiskCache hit", slog.String("key", key))
+	now := time.Now()
+	_ = os.Chtimes(p, now, now)
+	return b, true
+}
+
+// Set stores the value of the key.
+func (c *DiskCache) Set(key string, value []byte) error {
+	p := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// write to a temporary file and rename it not to expose a partially written entry
+	f, err := os.CreateTemp(filepath.Dir(p), key+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(value); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// Prune removes the entries not used for olderThan, all entries if olderThan is 0.
+// Returns the number of the removed entries.
+//
+// Only the files written by Set are removed, the other files and directories under the root are left as they are.
+func (c *DiskCache) Prune(olderThan time.Duration) (int, error) {
+	dirs, err := os.ReadDir(c.root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		count int
+		now   = time.Now()
+	)
+	for _, d := range dirs {
+		if !d.IsDir() || !isShard(d.Name()) {
+			continue
+		}
+		dir := filepath.Join(c.root, d.Name())
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return count, err
+		}
+		for _, e := range entries {
+			if !e.Type().IsRegular() || !isEntry(d.Name(), e.Name()) {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				return count, err
+			}
+			if olderThan > 0 && now.Sub(info.ModTime()) <= olderThan {
+				continue
+			}
+			p := filepath.Join(dir, e.Name())
+			if err := os.Remove(p); err != nil {
+				return count, err
+			}
+			logx.Trace("DiskCache prune", slog.String("path", p))
+			count++
+		}
+	}
+	return count, nil
+}
+
+// isShard returns true if the name is of the directories of the entries, the first 2 characters of the keys.
+func isShard(name string) bool {
+	return len(name) == 2 && isHex(name)
+}
+
+// isEntry returns true if the name is of the entries in the shard or the temporary files of them.
+func isEntry(shard, name string) bool {
+	if key, suffix, ok := strings.Cut(name, ".tmp"); ok {
+		// os.CreateTemp replaces * with digits
+		if suffix == "" || strings.Trim(suffix, "0123456789") != "" {
+			return false
+		}
+		name = key
+	}
+	return strings.HasPrefix(name, shard) && isHex(name)
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f') {
+			return false
+		}
+	}
+	return s != ""
+}
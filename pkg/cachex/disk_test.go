@@ -0,0 +1,83 @@
+package cachex_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/berquerant/ndql/pkg/cachex"
+	"github.com/berquerant/ndql/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskCache(t *testing.T) {
+	var (
+		c    = cachex.NewDiskCache(filepath.Join(t.TempDir(), "cache"))
+		key1 = util.Hash("key1")
+		key2 = util.Hash("key2")
+	)
+
+	n, err := c.Prune(0)
+	assert.Nil(t, err, "prune before creation")
+	assert.Equal(t, 0, n)
+
+	_, ok := c.Get(key1)
+	assert.False(t, ok)
+
+	assert.Nil(t, c.Set(key1, []byte("value1")))
+	assert.Nil(t, c.Set(key2, []byte("value2")))
+	got, ok := c.Get(key1)
+	assert.True(t, ok)
+	assert.Equal(t, "value1", string(got))
+
+	// key2 is not used for a while
+	old := time.Now().Add(-time.Hour)
+	assert.Nil(t, os.Chtimes(filepath.Join(c.Root(), key2[:2], key2), old, old))
+	n, err = c.Prune(time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	_, ok = c.Get(key2)
+	assert.False(t, ok)
+
+	n, err = c.Prune(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	_, ok = c.Get(key1)
+	assert.False(t, ok)
+}
+
+func TestDiskCachePruneForeignFiles(t *testing.T) {
+	var (
+		root = t.TempDir()
+		c    = cachex.NewDiskCache(root)
+		key  = util.Hash("key")
+	)
+	assert.Nil(t, c.Set(key, []byte("value")))
+	foreign := []string{
+		filepath.Join(root, "important", "thesis.txt"),
+		filepath.Join(root, "notes.txt"),
+		filepath.Join(root, key[:2], "notes.txt"),
+		filepath.Join(root, key[:2], key+".bak"),
+		filepath.Join(root, "ab", "cdef"),
+	}
+	for _, p := range foreign {
+		assert.Nil(t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.Nil(t, os.WriteFile(p, []byte("keep"), 0644))
+	}
+	// a temporary file left by Set
+	tmp := filepath.Join(root, key[:2], key+".tmp123")
+	assert.Nil(t, os.WriteFile(tmp, []byte("partial"), 0644))
+
+	n, err := c.Prune(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	_, ok := c.Get(key)
+	assert.False(t, ok)
+	_, err = os.Stat(tmp)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	for _, p := range foreign {
+		_, err := os.Stat(p)
+		assert.Nil(t, err, p)
+	}
+}
@@ -2,10 +2,10 @@ package cachex
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
-	"reflect"
 	"text/template"
 
 	"github.com/yuin/gopher-lua/parse"
@@ -14,7 +14,10 @@ import (
 	"github.com/berquerant/ndql/pkg/util"
 	"github.com/expr-lang/expr"
 	"github.com/expr-lang/expr/vm"
+	"github.com/tetratelabs/wazero"
 	lua "github.com/yuin/gopher-lua"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
 )
 
 // A LRU in-memory cache.
@@ -64,9 +67,9 @@ func NewTmpFileCache(root string) (*Cache[string], error) {
 	})
 }
 
-func NewExprCache() (*Cache[*vm.Program], error) {
+func NewExprCache(opts ...expr.Option) (*Cache[*vm.Program], error) {
 	return NewCache(func(text string) (*vm.Program, error) {
-		return expr.Compile(text, expr.AsKind(reflect.String))
+		return expr.Compile(text, opts...)
 	})
 }
 
@@ -80,3 +83,28 @@ func NewLuaCache() (*Cache[*lua.FunctionProto], error) {
 		return lua.Compile(chunk, "script.lua")
 	})
 }
+
+// NewStarlarkCache returns a cache of compiled starlark programs.
+// isPredeclared reports whether a name is predeclared.
+func NewStarlarkCache(isPredeclared func(string) bool) (*Cache[*starlark.Program], error) {
+	opts := &syntax.FileOptions{
+		Set:       true,
+		While:     true,
+		Recursion: true,
+	}
+	return NewCache(func(text string) (*starlark.Program, error) {
+		_, prog, err := starlark.SourceProgramOptions(opts, "script.star", text, isPredeclared)
+		return prog, err
+	})
+}
+
+// NewWasmCache returns a cache of wasm modules compiled by r, keyed by the module path.
+func NewWasmCache(r wazero.Runtime) (*Cache[wazero.CompiledModule], error) {
+	return NewCache(func(path string) (wazero.CompiledModule, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return r.CompileModule(context.Background(), b)
+	})
+}
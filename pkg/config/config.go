@@ -1,11 +1,18 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
+	"os"
 
+	"github.com/berquerant/ndql/pkg/gopkg"
 	"github.com/berquerant/ndql/pkg/logx"
+	"github.com/berquerant/ndql/pkg/parse"
+	"github.com/berquerant/ndql/pkg/tree"
+	"github.com/berquerant/ndql/pkg/util"
 )
 
 type Config struct {
@@ -14,18 +21,48 @@ type Config struct {
 	Verbose     bool `name:"verbose" short:"v" usage:"enable verbose output"`
 	Quiet       bool `name:"quiet" short:"q" usage:"quiet logs except errors"`
 	Concurrency uint `name:"concurrency" short:"c" usage:"maximum number of goroutines to process query, 0 means 1"`
+	Ordered     bool `name:"ordered" usage:"keep the order of the results with concurrency, as concurrency 1"`
+	NoOptimize  bool `name:"no_optimize" usage:"disable the rewrite of queries like the predicate pushdown"`
+
+	Strict    bool   `name:"strict" usage:"fail on the first row that fails to be evaluated, same as --max_errors 0"`
+	MaxErrors int    `name:"max_errors" default:"-1" usage:"fail when the number of the rows that fail to be evaluated exceeds this, negative means the rows are dropped"`
+	ErrorsTo  string `name:"errors_to" usage:"file to write the rows that fail to be evaluated as NDJSON"`
 
 	Index     string `name:"index" short:"i" usage:"index source; exclusive with paths"`
 	RawOutput bool   `name:"raw" usage:"enable raw output"`
 
+	ContentCacheBytes int64 `name:"content_cache_bytes" default:"268435456" usage:"maximum total bytes of file contents cached in memory"`
+	StreamThreshold   int64 `name:"stream_threshold" default:"8388608" usage:"files larger than this (bytes) are read by streaming instead of being cached"`
+
+	CacheDir string `name:"cache_dir" usage:"directory of the persistent result cache, default is ndql/results under the user cache directory"`
+	NoCache  bool   `name:"no_cache" usage:"disable the persistent result cache"`
+
+	Rc string `name:"rc" usage:"file of macro definitions loaded before the query, default is ndql/rc.sql under the user config directory"`
+
+	// OlderThan is --older_than of cache prune.
+	OlderThan string `name:"-"`
+	// Format is --format of schema.
+	Format string `name:"-"`
+	// History is --history of repl.
+	History string `name:"-"`
+
 	Mode  Mode     `name:"-"`
 	Query string   `name:"-"`
 	Path  string   `name:"-"`
 	Args  []string `name:"-"`
+	// Params are name=value of --param.
+	Params []string `name:"-"`
 
 	Stdout  io.Writer `name:"-"`
 	Stderr  io.Writer `name:"-"`
 	Sources *Sources  `name:"-"`
+
+	// Functions callable in the query, only the builtin functions if nil.
+	Functions *tree.FunctionRegistry `name:"-"`
+	// Macros loaded from the rc file.
+	Macros *parse.MacroSet `name:"-"`
+	// Documents shown by explain.
+	Documents *gopkg.DocumentSet `name:"-"`
 }
 
 func (c *Config) Close() error {
@@ -35,6 +72,22 @@ func (c *Config) Close() error {
 	return nil
 }
 
+// MaxRowErrors returns the number of the rows tolerated to fail to be evaluated, negative means unlimited.
+func (c Config) MaxRowErrors() int {
+	if c.Strict {
+		return 0
+	}
+	return c.MaxErrors
+}
+
+// ResultCacheDir returns the directory of the persistent result cache.
+func (c Config) ResultCacheDir() string {
+	if c.CacheDir != "" {
+		return c.CacheDir
+	}
+	return util.CacheDir("results")
+}
+
 func (c Config) SetupLogger() {
 	logx.Setup(c.Stderr, c.Debug, c.Trace, c.Quiet)
 }
@@ -51,5 +104,42 @@ func (c *Config) SetupQuery() error {
 		}
 	}
 	slog.Debug("Query", slog.String("query", c.Query))
+	return c.setupMacros()
+}
+
+// HistoryFile returns the path of the history file of repl.
+func (c Config) HistoryFile() string {
+	if c.History != "" {
+		return c.History
+	}
+	return util.CacheDir("repl_history")
+}
+
+// RcFile returns the path of the rc file.
+func (c Config) RcFile() string {
+	if c.Rc != "" {
+		return c.Rc
+	}
+	return util.ConfigDir("rc.sql")
+}
+
+func (c *Config) setupMacros() error {
+	c.Macros = parse.NewMacroSet()
+	path := c.RcFile()
+	if path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && c.Rc == "" {
+		// the default rc file is optional
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: failed to read rc file", err)
+	}
+	if err := c.Macros.Load(path, string(b)); err != nil {
+		return err
+	}
+	slog.Debug("Macros", slog.String("rc", path), slog.Int("len", c.Macros.Len()))
 	return nil
 }
@@ -12,19 +12,25 @@ var ErrUnknownMode = errors.New("UnknownMode")
 type Mode string
 
 const (
-	StringModeUnknown = "unknown"
-	StringModeVersion = "version"
-	StringModeQuery   = "query"
-	StringModeDryrun  = "dryrun"
-	StringModeList    = "list"
+	StringModeUnknown    = "unknown"
+	StringModeVersion    = "version"
+	StringModeQuery      = "query"
+	StringModeDryrun     = "dryrun"
+	StringModeList       = "list"
+	StringModeCachePrune = "cache_prune"
+	StringModeRepl       = "repl"
+	StringModeSchema     = "schema"
 )
 
 const (
-	ModeUnknown = Mode(StringModeUnknown)
-	ModeVersion = Mode(StringModeVersion)
-	ModeQuery   = Mode(StringModeQuery)
-	ModeDryrun  = Mode(StringModeDryrun)
-	ModeList    = Mode(StringModeList)
+	ModeUnknown    = Mode(StringModeUnknown)
+	ModeVersion    = Mode(StringModeVersion)
+	ModeQuery      = Mode(StringModeQuery)
+	ModeDryrun     = Mode(StringModeDryrun)
+	ModeList       = Mode(StringModeList)
+	ModeCachePrune = Mode(StringModeCachePrune)
+	ModeRepl       = Mode(StringModeRepl)
+	ModeSchema     = Mode(StringModeSchema)
 )
 
 func NewMode(v string) Mode {
@@ -37,6 +43,12 @@ func NewMode(v string) Mode {
 		return ModeDryrun
 	case StringModeList:
 		return ModeList
+	case StringModeCachePrune:
+		return ModeCachePrune
+	case StringModeRepl:
+		return ModeRepl
+	case StringModeSchema:
+		return ModeSchema
 	default:
 		return ModeUnknown
 	}
@@ -62,7 +74,7 @@ func (c *Config) SetupSources() error {
 
 func (c Config) newSources(args []string) (*Sources, error) {
 	switch c.Mode {
-	case ModeVersion:
+	case ModeVersion, ModeCachePrune:
 		return nil, ErrNoSources
 	case ModeDryrun:
 		return c.newDryrunSources(args)
@@ -70,6 +82,10 @@ func (c Config) newSources(args []string) (*Sources, error) {
 		return c.newListSources(args)
 	case ModeQuery:
 		return c.newQuerySources(args)
+	case ModeRepl:
+		return c.newReplSources(args)
+	case ModeSchema:
+		return c.newSchemaSources(args)
 	default:
 		return nil, ErrUnknownMode
 	}
@@ -153,3 +169,53 @@ func (c Config) newQuerySources(args []string) (*Sources, error) {
 		return nil, fmt.Errorf("%w: invalid query, path, index", ErrInvalidSource)
 	}
 }
+
+// newReplSources returns the sources of the repl, which reads queries from stdin.
+func (c Config) newReplSources(args []string) (*Sources, error) {
+	var s Sources
+	switch len(args) {
+	case 0:
+		if c.Index == "" {
+			return nil, fmt.Errorf("%w: no path no index", ErrInvalidSource)
+		}
+		slog.Debug("Use index", slog.String("source", c.Index))
+		index, err := NewSource(c.Index)
+		if err != nil {
+			return nil, err
+		}
+		s.Index = index
+	case 1:
+		if c.Index != "" {
+			return nil, fmt.Errorf("%w: index is exclusive with path", ErrInvalidSource)
+		}
+		walker, path, err := NewPathSource(args[0])
+		if err != nil {
+			return nil, err
+		}
+		s.Path = walker
+		s.path = path
+	default:
+		return nil, fmt.Errorf("%w: invalid path, index", ErrInvalidSource)
+	}
+	if IsStdinSource(s.Index) || IsStdinSource(s.path) {
+		return nil, fmt.Errorf("%w: stdin is used by repl", ErrStdinConflict)
+	}
+	return &s, nil
+}
+
+// newSchemaSources returns the sources of schema, the index without query, or the same as query.
+func (c Config) newSchemaSources(args []string) (*Sources, error) {
+	if len(args) > 0 {
+		return c.newQuerySources(args)
+	}
+	if c.Index == "" {
+		return nil, fmt.Errorf("%w: no query no index", ErrInvalidSource)
+	}
+	index, err := NewIndexSource(c.Index)
+	if err != nil {
+		return nil, err
+	}
+	return &Sources{
+		Index: index,
+	}, nil
+}
@@ -25,6 +25,13 @@ func NewDocumentSet(doc ...*Document) *DocumentSet {
 	}
 }
 
+// Add adds the documents, replacing the ones with the same paths.
+func (s *DocumentSet) Add(doc ...*Document) {
+	for _, x := range doc {
+		s.m.Set(x.Path, x)
+	}
+}
+
 func (s *DocumentSet) MarshalJSON() ([]byte, error) { return json.Marshal(s.m) }
 func (s *DocumentSet) UnmarshalJSON(b []byte) error {
 	var m DocumentMap
@@ -43,4 +43,23 @@ func TestDocumentSet(t *testing.T) {
 		}
 		assert.Equal(t, s, &v)
 	})
+
+	t.Run("Add", func(t *testing.T) {
+		s := gopkg.NewDocumentSet(&gopkg.Document{
+			Path:  "k1",
+			Text:  "text",
+			Title: "t1",
+		})
+		s.Add(&gopkg.Document{
+			Path:  "k1.k11",
+			Text:  "child",
+			Title: "t11",
+		})
+		got, ok := s.Get("k1")
+		assert.True(t, ok)
+		assert.Equal(t, "# t1\n\ntext\n\n# Children\n\n- k11\n", got)
+		got, ok = s.Get("k1.k11")
+		assert.True(t, ok)
+		assert.Equal(t, "# t11\n\nchild\n", got)
+	})
 }
@@ -1,41 +1,155 @@
 package iox
 
 import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/fs"
 	"log/slog"
-	"os"
+	"sync"
 
-	"github.com/berquerant/cache"
 	"github.com/berquerant/ndql/pkg/logx"
-	"github.com/berquerant/ndql/pkg/util"
 )
 
-const contentCacheSize = 100
+const (
+	// Default maximum total bytes of the cached contents.
+	DefaultContentCacheBytes = 256 << 20
+	// Default size of files that are streamed instead of cached.
+	DefaultStreamThreshold = 8 << 20
+)
 
 // Cache of contents of files.
 type ContentCache interface {
 	Get(filename string) ([]byte, error)
 }
 
+// NewFileContentCache returns a cache of the contents of files on the host filesystem.
 func NewFileContentCache() *FileContentCache {
+	return NewFSContentCache(OSFS{})
+}
+
+// NewFSContentCache returns a cache of the contents of files in fsys.
+func NewFSContentCache(fsys fs.FS) *FileContentCache {
+	return NewSizedContentCache(fsys, DefaultContentCacheBytes, DefaultStreamThreshold)
+}
+
+// NewSizedContentCache returns a cache of the contents of files in fsys.
+//
+// The cache keeps at most maxBytes bytes in total, evicting the least recently used contents.
+// Files larger than streamThreshold should be streamed by Open instead of being read at once.
+func NewSizedContentCache(fsys fs.FS, maxBytes, streamThreshold int64) *FileContentCache {
 	return &FileContentCache{
-		c: util.Must(cache.NewLRU(contentCacheSize, func(filename string) ([]byte, error) {
-			x, err := os.ReadFile(filename)
-			if err != nil {
-				logx.Trace("FileContentCache", slog.String("filename", filename), logx.Err(err))
-			} else {
-				logx.Trace("FileContentCache", slog.String("filename", filename), slog.Int("size", len(x)))
-			}
-			return x, err
-		})),
+		fsys:            fsys,
+		maxBytes:        maxBytes,
+		streamThreshold: streamThreshold,
+		list:            list.New(),
+		items:           map[string]*list.Element{},
 	}
 }
 
 type FileContentCache struct {
-	c *cache.LRU[string, []byte]
+	fsys            fs.FS
+	maxBytes        int64
+	streamThreshold int64
+
+	mux   sync.Mutex
+	size  int64
+	list  *list.List // front is the most recently used
+	items map[string]*list.Element
+}
+
+type contentCacheItem struct {
+	filename string
+	content  []byte
 }
 
 var _ ContentCache = &FileContentCache{}
 
+// Get returns the contents of the file.
+// Contents larger than the capacity of the cache are returned without being cached.
 func (c *FileContentCache) Get(filename string) ([]byte, error) {
-	return c.c.Get(filename)
+	if x, ok := c.get(filename); ok {
+		return x, nil
+	}
+	x, err := fs.ReadFile(c.fsys, filename)
+	if err != nil {
+		logx.Trace("FileContentCache", slog.String("filename", filename), logx.Err(err))
+		return nil, err
+	}
+	logx.Trace("FileContentCache", slog.String("filename", filename), slog.Int("size", len(x)))
+	c.put(filename, x)
+	return x, nil
+}
+
+func (c *FileContentCache) get(filename string) ([]byte, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	e, ok := c.items[filename]
+	if !ok {
+		return nil, false
+	}
+	c.list.MoveToFront(e)
+	return e.Value.(*contentCacheItem).content, true
+}
+
+func (c *FileContentCache) put(filename string, content []byte) {
+	size := int64(len(content))
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if _, ok := c.items[filename]; ok {
+		return
+	}
+	for c.size+size > c.maxBytes {
+		e := c.list.Back()
+		x := c.list.Remove(e).(*contentCacheItem)
+		delete(c.items, x.filename)
+		c.size -= int64(len(x.content))
+		logx.Trace("FileContentCache evict", slog.String("filename", x.filename), slog.Int("size", len(x.content)))
+	}
+	c.items[filename] = c.list.PushFront(&contentCacheItem{
+		filename: filename,
+		content:  content,
+	})
+	c.size += size
+}
+
+// Size returns the total bytes of the cached contents.
+func (c *FileContentCache) Size() int64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.size
+}
+
+// FS returns the fs.FS the contents are read from.
+func (c *FileContentCache) FS() fs.FS { return c.fsys }
+
+// ShouldStream reports whether the file is larger than the stream threshold.
+func (c *FileContentCache) ShouldStream(filename string) (bool, error) {
+	info, err := fs.Stat(c.fsys, filename)
+	if err != nil {
+		return false, err
+	}
+	return info.Size() > c.streamThreshold, nil
+}
+
+// Open returns the contents of the file.
+// Files larger than the stream threshold are streamed from the fs.FS, smaller ones are read via the cache.
+func (c *FileContentCache) Open(filename string) (io.ReadCloser, error) {
+	stream, err := c.ShouldStream(filename)
+	if err != nil {
+		return nil, err
+	}
+	if stream {
+		logx.Trace("FileContentCache stream", slog.String("filename", filename))
+		return c.fsys.Open(filename)
+	}
+	b, err := c.Get(filename)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
 }
@@ -0,0 +1,60 @@
+package iox_test
+
+import (
+	"io"
+	"testing"
+	"testing/fstest"
+
+	"github.com/berquerant/ndql/pkg/iox"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileContentCache(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a": {Data: []byte("aaaa")},
+		"b": {Data: []byte("bbbb")},
+		"c": {Data: []byte("cccc")},
+		"d": {Data: []byte("dddddddddddd")},
+	}
+
+	t.Run("BoundedByBytes", func(t *testing.T) {
+		c := iox.NewSizedContentCache(fsys, 10, 100)
+		for _, x := range []string{"a", "b", "a", "c"} {
+			_, err := c.Get(x)
+			assert.Nil(t, err)
+		}
+		// b is evicted
+		assert.Equal(t, int64(8), c.Size())
+
+		got, err := c.Get("d")
+		assert.Nil(t, err)
+		assert.Equal(t, "dddddddddddd", string(got))
+		// too large to cache
+		assert.Equal(t, int64(8), c.Size())
+	})
+
+	t.Run("Open", func(t *testing.T) {
+		c := iox.NewSizedContentCache(fsys, 100, 4)
+		for _, tc := range []struct {
+			filename string
+			stream   bool
+		}{
+			{filename: "a", stream: false},
+			{filename: "d", stream: true},
+		} {
+			stream, err := c.ShouldStream(tc.filename)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.stream, stream)
+
+			f, err := c.Open(tc.filename)
+			if !assert.Nil(t, err) {
+				return
+			}
+			got, err := io.ReadAll(f)
+			assert.Nil(t, err)
+			assert.Nil(t, f.Close())
+			assert.Equal(t, string(fsys[tc.filename].Data), string(got))
+		}
+		assert.Equal(t, int64(4), c.Size())
+	})
+}
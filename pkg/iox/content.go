@@ -0,0 +1,170 @@
+package iox
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	// Number of bytes to detect the type of contents.
+	SniffSize = 8000
+)
+
+var ErrUnknownHashAlgorithm = errors.New("UnknownHashAlgorithm")
+
+const (
+	HashSHA256 = "sha256"
+	HashMD5    = "md5"
+	HashXXH64  = "xxh64"
+)
+
+func newHash(algo string) (hash.Hash, error) {
+	switch strings.ToLower(algo) {
+	case HashSHA256:
+		return sha256.New(), nil
+	case HashMD5:
+		return md5.New(), nil
+	case HashXXH64:
+		return xxhash.New(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownHashAlgorithm, algo)
+	}
+}
+
+// HashContent returns the hex digest of the contents.
+func HashContent(r io.Reader, algo string) (string, error) {
+	h, err := newHash(algo)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CountLines returns the number of lines.
+// The last line without a trailing newline is also counted.
+func CountLines(r io.Reader) (int64, error) {
+	var (
+		buf   = make([]byte, 32*1024)
+		count int64
+		last  byte = '\n'
+	)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			count += int64(bytes.Count(buf[:n], []byte("\n")))
+			last = buf[n-1]
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if last != '\n' {
+		count++
+	}
+	return count, nil
+}
+
+// FirstLine returns the first line without the line terminator.
+func FirstLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Head returns at most n bytes from the beginning.
+func Head(r io.Reader, n int) ([]byte, error) {
+	return io.ReadAll(io.LimitReader(r, int64(n)))
+}
+
+// DetectMediaType returns the media type of the contents by magic-byte sniffing, like "text/plain".
+func DetectMediaType(head []byte) string {
+	t := http.DetectContentType(head)
+	if m, _, err := mime.ParseMediaType(t); err == nil {
+		return m
+	}
+	return t
+}
+
+// IsBinary reports whether the contents look like binary data, i.e. contain a NUL byte.
+func IsBinary(head []byte) bool {
+	return bytes.IndexByte(head, 0) >= 0
+}
+
+const (
+	EncodingUTF8    = "utf-8"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	EncodingUTF32LE = "utf-32le"
+	EncodingUTF32BE = "utf-32be"
+	EncodingASCII   = "ascii"
+	EncodingBinary  = "binary"
+	EncodingUnknown = "unknown"
+)
+
+// DetectEncoding guesses the character encoding of the contents from the byte order mark and the bytes.
+// head may be truncated in the middle of a character.
+func DetectEncoding(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return EncodingUTF8
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE, 0x00, 0x00}):
+		return EncodingUTF32LE
+	case bytes.HasPrefix(head, []byte{0x00, 0x00, 0xFE, 0xFF}):
+		return EncodingUTF32BE
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return EncodingUTF16BE
+	case IsBinary(head):
+		return EncodingBinary
+	}
+
+	ascii := true
+	for _, b := range head {
+		if b >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return EncodingASCII
+	}
+	if utf8.Valid(trimIncompleteRune(head)) {
+		return EncodingUTF8
+	}
+	return EncodingUnknown
+}
+
+// trimIncompleteRune removes a character truncated at the end.
+func trimIncompleteRune(b []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		if p := len(b) - i; utf8.RuneStart(b[p]) {
+			if !utf8.FullRune(b[p:]) {
+				return b[:p]
+			}
+			return b
+		}
+	}
+	return b
+}
@@ -0,0 +1,52 @@
+package iox_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/berquerant/ndql/pkg/iox"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountLines(t *testing.T) {
+	for _, tc := range []struct {
+		title   string
+		content string
+		want    int64
+	}{
+		{title: "empty", content: "", want: 0},
+		{title: "newline", content: "\n", want: 1},
+		{title: "no trailing newline", content: "a\nb", want: 2},
+		{title: "trailing newline", content: "a\nb\n", want: 2},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			got, err := iox.CountLines(strings.NewReader(tc.content))
+			assert.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestDetectEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		title   string
+		content []byte
+		want    string
+	}{
+		{title: "empty", content: []byte{}, want: iox.EncodingASCII},
+		{title: "ascii", content: []byte("abc"), want: iox.EncodingASCII},
+		{title: "utf8", content: []byte("あいう"), want: iox.EncodingUTF8},
+		{title: "utf8 truncated", content: []byte("あいう")[:8], want: iox.EncodingUTF8},
+		{title: "utf8 bom", content: []byte("\xEF\xBB\xBFabc"), want: iox.EncodingUTF8},
+		{title: "utf16le bom", content: []byte("\xFF\xFEa\x00"), want: iox.EncodingUTF16LE},
+		{title: "utf16be bom", content: []byte("\xFE\xFF\x00a"), want: iox.EncodingUTF16BE},
+		{title: "utf32le bom", content: []byte("\xFF\xFE\x00\x00"), want: iox.EncodingUTF32LE},
+		{title: "utf32be bom", content: []byte("\x00\x00\xFE\xFF"), want: iox.EncodingUTF32BE},
+		{title: "binary", content: []byte("a\x00b"), want: iox.EncodingBinary},
+		{title: "unknown", content: []byte("a\xFF\xFFb"), want: iox.EncodingUnknown},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			assert.Equal(t, tc.want, iox.DetectEncoding(tc.content))
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package iox
+
+import (
+	"context"
+	"io/fs"
+	"os"
+)
+
+// OSFS is a fs.FS backed by the host filesystem.
+// Unlike os.DirFS, it accepts any path that os.Open accepts, including absolute paths.
+type OSFS struct{}
+
+var (
+	_ fs.FS         = OSFS{}
+	_ fs.ReadFileFS = OSFS{}
+	_ fs.StatFS     = OSFS{}
+)
+
+func (OSFS) Open(name string) (fs.File, error)     { return os.Open(name) }
+func (OSFS) ReadFile(name string) ([]byte, error)  { return os.ReadFile(name) }
+func (OSFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+
+// FSProvider is implemented by walkers whose paths are relative to a fs.FS.
+type FSProvider interface {
+	FS() fs.FS
+}
+
+type contentCacheContextKey struct{}
+
+var defaultContentCache = NewFileContentCache()
+
+// WithFS returns a context that reads the contents of files from fsys.
+func WithFS(ctx context.Context, fsys fs.FS) context.Context {
+	return WithContentCache(ctx, NewFSContentCache(fsys))
+}
+
+// WithContentCache returns a context that reads the contents of files via c.
+func WithContentCache(ctx context.Context, c *FileContentCache) context.Context {
+	return context.WithValue(ctx, contentCacheContextKey{}, c)
+}
+
+// ContentCacheFromContext returns the cache set by WithContentCache,
+// or the shared cache of the host filesystem.
+func ContentCacheFromContext(ctx context.Context) *FileContentCache {
+	if c, ok := ctx.Value(contentCacheContextKey{}).(*FileContentCache); ok {
+		return c
+	}
+	return defaultContentCache
+}
+
+// FSFromContext returns the fs.FS to read the contents of files.
+func FSFromContext(ctx context.Context) fs.FS {
+	return ContentCacheFromContext(ctx).FS()
+}
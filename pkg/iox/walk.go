@@ -73,7 +73,9 @@ func NewPathWalker(root string) *PathWalker {
 
 var _ Walker = &PathWalker{}
 
-func (w PathWalker) Walk() iter.Seq[*WalkerEntry] {
+func (w PathWalker) Walk() iter.Seq[*WalkerEntry] { return w.walk(nil) }
+
+func (w PathWalker) walk(f WalkFilter) iter.Seq[*WalkerEntry] {
 	return func(yield func(*WalkerEntry) bool) {
 		_ = filepath.Walk(w.root, func(path string, info fs.FileInfo, err error) error {
 			if err != nil {
@@ -87,10 +89,109 @@ func (w PathWalker) Walk() iter.Seq[*WalkerEntry] {
 				IsDir:   info.IsDir(),
 			}
 			logx.Trace("PathWalker", slog.String("path", path))
-			if !yield(e) {
+			if (f == nil || f.Match(e)) && !yield(e) {
 				return filepath.SkipAll
 			}
+			if f != nil && e.IsDir && path != w.root && !f.Descend(path) {
+				logx.Trace("PathWalker skip", slog.String("path", path))
+				return filepath.SkipDir
+			}
 			return nil
 		})
 	}
 }
+
+type FSWalker struct {
+	fsys fs.FS
+	root string
+}
+
+// NewFSWalker returns a Walker that walks the file tree of the fs.FS from root.
+// The paths of the entries are relative to fsys, so read them with WithFS(ctx, fsys).
+func NewFSWalker(fsys fs.FS, root string) *FSWalker {
+	return &FSWalker{
+		fsys: fsys,
+		root: root,
+	}
+}
+
+var (
+	_ Walker     = &FSWalker{}
+	_ FSProvider = &FSWalker{}
+)
+
+func (w *FSWalker) FS() fs.FS { return w.fsys }
+
+func (w *FSWalker) Walk() iter.Seq[*WalkerEntry] { return w.walk(nil) }
+
+func (w *FSWalker) walk(f WalkFilter) iter.Seq[*WalkerEntry] {
+	return func(yield func(*WalkerEntry) bool) {
+		_ = fs.WalkDir(w.fsys, w.root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			e := &WalkerEntry{
+				Path:    path,
+				Size:    info.Size(),
+				Mode:    info.Mode(),
+				ModTime: info.ModTime(),
+				IsDir:   info.IsDir(),
+			}
+			logx.Trace("FSWalker", slog.String("path", path))
+			if (f == nil || f.Match(e)) && !yield(e) {
+				return fs.SkipAll
+			}
+			if f != nil && e.IsDir && path != w.root && !f.Descend(path) {
+				logx.Trace("FSWalker skip", slog.String("path", path))
+				return fs.SkipDir
+			}
+			return nil
+		})
+	}
+}
+
+// WalkFilter selects the entries of Walker.
+type WalkFilter interface {
+	// Match returns true if the entry should be yielded.
+	Match(e *WalkerEntry) bool
+	// Descend returns false if no entries under the directory match, so they can be skipped.
+	Descend(dir string) bool
+}
+
+type FilterWalker struct {
+	w Walker
+	f WalkFilter
+}
+
+// NewFilterWalker returns a Walker that yields the entries of w matched by f.
+// PathWalker and FSWalker do not read the directories that f does not descend into.
+func NewFilterWalker(w Walker, f WalkFilter) *FilterWalker {
+	return &FilterWalker{
+		w: w,
+		f: f,
+	}
+}
+
+var _ Walker = &FilterWalker{}
+
+func (w *FilterWalker) Walk() iter.Seq[*WalkerEntry] {
+	switch x := w.w.(type) {
+	case *PathWalker:
+		return x.walk(w.f)
+	case PathWalker:
+		return x.walk(w.f)
+	case *FSWalker:
+		return x.walk(w.f)
+	}
+	return func(yield func(*WalkerEntry) bool) {
+		for e := range w.w.Walk() {
+			if w.f.Match(e) && !yield(e) {
+				return
+			}
+		}
+	}
+}
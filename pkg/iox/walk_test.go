@@ -0,0 +1,113 @@
+package iox_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/berquerant/ndql/pkg/iox"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFSWalker(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt":     {Data: []byte("a")},
+		"sub/b.txt": {Data: []byte("bb")},
+	}
+
+	t.Run("Walk", func(t *testing.T) {
+		type entry struct {
+			path  string
+			size  int64
+			isDir bool
+		}
+		var got []entry
+		for e := range iox.NewFSWalker(fsys, ".").Walk() {
+			got = append(got, entry{
+				path:  e.Path,
+				size:  e.Size,
+				isDir: e.IsDir,
+			})
+		}
+		assert.Equal(t, []entry{
+			{path: ".", isDir: true},
+			{path: "a.txt", size: 1},
+			{path: "sub", isDir: true},
+			{path: "sub/b.txt", size: 2},
+		}, got)
+	})
+
+	t.Run("Break", func(t *testing.T) {
+		var got []string
+		for e := range iox.NewFSWalker(fsys, "sub").Walk() {
+			got = append(got, e.Path)
+			break
+		}
+		assert.Equal(t, []string{"sub"}, got)
+	})
+
+	t.Run("ReadThroughContext", func(t *testing.T) {
+		w := iox.NewFSWalker(fsys, ".")
+		ctx := iox.WithFS(context.TODO(), w.FS())
+		b, err := iox.ContentCacheFromContext(ctx).Get("sub/b.txt")
+		assert.Nil(t, err)
+		assert.Equal(t, "bb", string(b))
+	})
+
+	t.Run("DefaultIsHostFilesystem", func(t *testing.T) {
+		_, ok := iox.FSFromContext(context.TODO()).(iox.OSFS)
+		assert.True(t, ok)
+	})
+}
+
+type testWalkFilter struct {
+	seen []string
+}
+
+func (f *testWalkFilter) Match(e *iox.WalkerEntry) bool {
+	f.seen = append(f.seen, e.Path)
+	return !e.IsDir
+}
+
+func (f *testWalkFilter) Descend(dir string) bool { return dir != "skip" }
+
+func TestFilterWalker(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt":      {Data: []byte("a")},
+		"sub/b.txt":  {Data: []byte("bb")},
+		"skip/c.txt": {Data: []byte("ccc")},
+	}
+	for _, tc := range []struct {
+		title  string
+		walker iox.Walker
+		seen   []string
+	}{
+		{
+			title:  "skip directory",
+			walker: iox.NewFSWalker(fsys, "."),
+			seen:   []string{".", "a.txt", "skip", "sub", "sub/b.txt"},
+		},
+		{
+			title:  "other walker",
+			walker: iox.NewReaderWalker(strings.NewReader("a.txt\nskip/c.txt\n")),
+			seen:   []string{"a.txt", "skip/c.txt"},
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			f := &testWalkFilter{}
+			var got []string
+			for e := range iox.NewFilterWalker(tc.walker, f).Walk() {
+				got = append(got, e.Path)
+			}
+			assert.Equal(t, tc.seen, f.seen)
+			var want []string
+			for _, x := range tc.seen {
+				if x != "." && x != "skip" && x != "sub" {
+					want = append(want, x)
+				}
+			}
+			assert.Equal(t, want, got)
+		})
+	}
+}
@@ -192,6 +192,9 @@ func (f *MapFunction[T]) AsMultiMapFunction() (*MultiMapFunction[T], error) {
 		r := []T{}
 		for _, x := range v {
 			a, err := f.Call(x)
+			if errors.Is(err, ErrIgnore) {
+				continue
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -214,6 +217,9 @@ func (f *FanoutFunction[T]) AsMultiMapFunction() (*MultiMapFunction[T], error) {
 		r := []T{}
 		for _, x := range v {
 			a, err := f.Call(x)
+			if errors.Is(err, ErrIgnore) {
+				continue
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -230,3 +230,24 @@ func TestCombineFunction(t *testing.T) {
 		})
 	}
 }
+
+func TestCombineFunctionIgnore(t *testing.T) {
+	var (
+		fanout = iterx.NewFanoutFunction(func(v int) ([]int, error) {
+			return []int{v, v + 1, v + 2}, nil
+		})
+		odd = iterx.NewMapFunction(func(v int) (int, error) {
+			if v%2 == 0 {
+				return 0, iterx.ErrIgnore
+			}
+			return v, nil
+		})
+	)
+	h, err := iterx.CombineFunction[int](fanout, odd)
+	if !assert.Nil(t, err) {
+		return
+	}
+	got, err := h.(*iterx.FanoutFunction[int]).Call(1)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 3}, got)
+}
@@ -54,6 +54,28 @@ func (v String) Display() string   { return fmt.Sprintf("String(%s)", v.Raw()) }
 func (v Time) Display() string     { return fmt.Sprintf("Time(%v)", v.Raw()) }
 func (v Duration) Display() string { return fmt.Sprintf("Duration(%v)", v.Raw()) }
 
+// TypeName returns the name of the type of the data like Int.
+func TypeName(d Data) string {
+	switch d.(type) {
+	case Null:
+		return "Null"
+	case Float:
+		return "Float"
+	case Int:
+		return "Int"
+	case Bool:
+		return "Bool"
+	case String:
+		return "String"
+	case Time:
+		return "Time"
+	case Duration:
+		return "Duration"
+	default:
+		return fmt.Sprintf("%T", d)
+	}
+}
+
 func (v Null) AsOp() *Op     { return &Op{v} }
 func (v Float) AsOp() *Op    { return &Op{v} }
 func (v Int) AsOp() *Op      { return &Op{v} }
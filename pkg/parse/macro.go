@@ -0,0 +1,343 @@
+package parse
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	tidb "github.com/pingcap/tidb/pkg/parser"
+	ast "github.com/pingcap/tidb/pkg/parser/ast"
+)
+
+// Macros are functions defined by SQL.
+//
+// @title Macros
+// @path syntax.macros
+// @document
+// A macro is a function defined by an expression:
+//
+// ```
+// CREATE FUNCTION name(arg1, arg2, ...) RETURNS expression;
+// DEFINE name(arg1, arg2, ...) AS expression;
+// ```
+//
+// Calls of the macro are replaced with the expression before the query runs,
+// and the arguments in the expression are replaced with the arguments of the call.
+// For example,
+//
+// ```
+// define go_file(p) as extension(p) = ".go" and p not like "%_test.go";
+// select path where go_file(path);
+// ```
+//
+// is the same as
+//
+// ```
+// select path where extension(path) = ".go" and path not like "%_test.go";
+// ```
+//
+// Macros can be defined anywhere in the query and in the rc file (`--rc`), and can call other macros.
+// The arguments shadow the columns with the same names in the expression.
+// The macros shadow the functions with the same names.
+// Recursive macros are not allowed.
+// The placeholder `?` is not allowed in the expression, pass the value as an argument like `f(?)`.
+
+var (
+	ErrMacro = errors.New("MacroError")
+)
+
+// Macro is a function defined by SQL.
+type Macro struct {
+	Name   string
+	Params []string
+	Body   string
+	// Source is the name of the file where the macro is defined, empty means the query.
+	Source string
+	// Line is the 1-based line number of the definition.
+	Line int
+}
+
+func (m Macro) Position() string {
+	if m.Source == "" {
+		return fmt.Sprintf("line %d", m.Line)
+	}
+	return fmt.Sprintf("%s:%d", m.Source, m.Line)
+}
+
+func (m Macro) String() string {
+	return fmt.Sprintf("%s(%s) defined at %s", m.Name, strings.Join(m.Params, ", "), m.Position())
+}
+
+func (m *Macro) errorf(format string, a ...any) error {
+	return fmt.Errorf("%w: macro %s: %s", ErrMacro, m, fmt.Sprintf(format, a...))
+}
+
+// parseBody parses the body as a new expression.
+func (m *Macro) parseBody(p *tidb.Parser) (ast.ExprNode, error) {
+	nodes, _, err := p.Parse("select "+m.Body, charset, collation)
+	if err != nil {
+		return nil, m.errorf("invalid expression: %v", err)
+	}
+	if len(nodes) != 1 {
+		return nil, m.errorf("expression required")
+	}
+	s, ok := nodes[0].(*ast.SelectStmt)
+	if !ok || s.From != nil || s.Where != nil || s.Fields == nil || len(s.Fields.Fields) != 1 || s.Fields.Fields[0].Expr == nil {
+		return nil, m.errorf("expression required")
+	}
+	return s.Fields.Fields[0].Expr, nil
+}
+
+// MacroSet is a set of macros.
+type MacroSet struct {
+	macros map[string]*Macro
+}
+
+func NewMacroSet() *MacroSet {
+	return &MacroSet{
+		macros: map[string]*Macro{},
+	}
+}
+
+func (s *MacroSet) Clone() *MacroSet {
+	return &MacroSet{
+		macros: maps.Clone(s.macros),
+	}
+}
+
+func (s *MacroSet) Len() int { return len(s.macros) }
+
+// Merge adds the macros of x that are not in s.
+func (s *MacroSet) Merge(x *MacroSet) {
+	for k, m := range x.macros {
+		if _, ok := s.macros[k]; !ok {
+			s.macros[k] = m
+		}
+	}
+}
+
+func (s *MacroSet) Get(name string) (*Macro, bool) {
+	m, ok := s.macros[strings.ToLower(name)]
+	return m, ok
+}
+
+// Macros returns the macros sorted by name.
+func (s *MacroSet) Macros() []*Macro {
+	r := make([]*Macro, 0, len(s.macros))
+	for _, k := range slices.Sorted(maps.Keys(s.macros)) {
+		r = append(r, s.macros[k])
+	}
+	return r
+}
+
+// Define adds the macro.
+// A macro cannot be defined twice.
+func (s *MacroSet) Define(m *Macro) error {
+	if x, ok := s.macros[m.Name]; ok {
+		return m.errorf("already defined: %s", x)
+	}
+	body, err := m.parseBody(tidb.New())
+	if err != nil {
+		return err
+	}
+	// the positions of the placeholders in the body are not the positions in the query
+	c := &paramMarkerCollector{}
+	body.Accept(c)
+	if len(c.markers) > 0 {
+		return m.errorf("placeholder ? is not allowed, pass the value as an argument")
+	}
+	s.macros[m.Name] = m
+	return nil
+}
+
+// Load defines the macros in the text of the source.
+// The text should consist of macro definitions only.
+func (s *MacroSet) Load(source, text string) error {
+	for _, x := range splitStatements(text) {
+		m, ok, err := parseMacroDefinition(source, x)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: %s: only macro definitions are allowed", ErrMacro, x.position(source))
+		}
+		if err := s.Define(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// define defines the macros in the query, and returns the query without the definitions.
+// The definitions are replaced with spaces to keep the positions of the other statements.
+func (s *MacroSet) define(text string) (string, error) {
+	b := []byte(text)
+	for _, x := range splitStatements(text) {
+		m, ok, err := parseMacroDefinition("", x)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			continue
+		}
+		if err := s.Define(m); err != nil {
+			return "", err
+		}
+		for i := x.start; i < x.end; i++ {
+			if b[i] != '\n' {
+				b[i] = ' '
+			}
+		}
+	}
+	return string(b), nil
+}
+
+// expand replaces the calls of the macros in the statement.
+func (s *MacroSet) expand(n Node) (Node, error) {
+	e := &macroExpander{
+		macros: s,
+		parser: tidb.New(),
+	}
+	r, ok := n.Accept(e)
+	if !ok {
+		return nil, e.err
+	}
+	return r.(Node), nil
+}
+
+var (
+	macroDefinitionPrefixRegexp = regexp.MustCompile(`(?is)^(create\s+function|define)\b`)
+	macroDefinitionRegexp       = regexp.MustCompile(`(?is)^(?:create\s+function|define)\s+([a-z_][a-z0-9_]*)\s*\(([^)]*)\)\s*(?:returns|as)\s+(.+)$`)
+	macroParamRegexp            = regexp.MustCompile(`(?i)^[a-z_][a-z0-9_]*$`)
+)
+
+// parseMacroDefinition parses the statement as a macro definition.
+// ok is false if the statement is not a macro definition.
+func parseMacroDefinition(source string, x sqlStatement) (m *Macro, ok bool, err error) {
+	text := x.text()
+	if !macroDefinitionPrefixRegexp.MatchString(text) {
+		return nil, false, nil
+	}
+	ss := macroDefinitionRegexp.FindStringSubmatch(text)
+	if ss == nil {
+		return nil, true, fmt.Errorf("%w: %s: invalid macro definition, want CREATE FUNCTION name(args) RETURNS expression or DEFINE name(args) AS expression", ErrMacro, x.position(source))
+	}
+	m = &Macro{
+		Name:   strings.ToLower(ss[1]),
+		Body:   strings.TrimSpace(ss[3]),
+		Source: source,
+		Line:   x.line,
+	}
+	if params := strings.TrimSpace(ss[2]); params != "" {
+		for p := range strings.SplitSeq(params, ",") {
+			p = strings.ToLower(strings.TrimSpace(p))
+			if !macroParamRegexp.MatchString(p) {
+				return nil, true, m.errorf("invalid argument name %q", p)
+			}
+			if slices.Contains(m.Params, p) {
+				return nil, true, m.errorf("duplicate argument name %q", p)
+			}
+			m.Params = append(m.Params, p)
+		}
+	}
+	return m, true, nil
+}
+
+// macroExpander replaces the calls of the macros with their bodies.
+type macroExpander struct {
+	macros *MacroSet
+	parser *tidb.Parser
+	stack  []*Macro // macros being expanded
+	err    error
+}
+
+var _ ast.Visitor = &macroExpander{}
+
+func (e *macroExpander) Enter(n ast.Node) (ast.Node, bool) { return n, false }
+
+func (e *macroExpander) Leave(n ast.Node) (ast.Node, bool) {
+	f, ok := n.(*ast.FuncCallExpr)
+	if !ok {
+		return n, true
+	}
+	m, ok := e.macros.Get(f.FnName.L)
+	if !ok {
+		return n, true
+	}
+	r, err := e.expand(m, f.Args)
+	if err != nil {
+		e.err = err
+		return n, false
+	}
+	return r, true
+}
+
+func (e *macroExpander) expand(m *Macro, args []ast.ExprNode) (ast.ExprNode, error) {
+	if len(args) != len(m.Params) {
+		return nil, m.errorf("%d arguments required but got %d", len(m.Params), len(args))
+	}
+	if slices.Contains(e.stack, m) {
+		chain := make([]string, len(e.stack)+1)
+		for i, x := range e.stack {
+			chain[i] = x.Name
+		}
+		chain[len(e.stack)] = m.Name
+		return nil, m.errorf("recursive call: %s", strings.Join(chain, " -> "))
+	}
+	body, err := m.parseBody(e.parser)
+	if err != nil {
+		return nil, err
+	}
+
+	e.stack = append(e.stack, m)
+	defer func() {
+		e.stack = e.stack[:len(e.stack)-1]
+	}()
+	// expand the macros in the body, then bind the arguments
+	r, ok := body.Accept(e)
+	if !ok {
+		return nil, e.err
+	}
+	r, _ = r.Accept(&macroBinder{
+		params: m.Params,
+		args:   args,
+	})
+	return parenthesize(r.(ast.ExprNode)), nil
+}
+
+// parenthesize wraps the expression in parentheses to keep the precedence
+// when it is embedded in another expression.
+func parenthesize(x ast.ExprNode) ast.ExprNode {
+	switch x.(type) {
+	case *ast.ColumnNameExpr, *ast.FuncCallExpr, *ast.ParenthesesExpr, ast.ValueExpr:
+		return x
+	default:
+		return &ast.ParenthesesExpr{
+			Expr: x,
+		}
+	}
+}
+
+// macroBinder replaces the columns of the params with the args.
+type macroBinder struct {
+	params []string
+	args   []ast.ExprNode
+}
+
+var _ ast.Visitor = &macroBinder{}
+
+func (b *macroBinder) Enter(n ast.Node) (ast.Node, bool) {
+	c, ok := n.(*ast.ColumnNameExpr)
+	if !ok || c.Name.Schema.L != "" || c.Name.Table.L != "" {
+		return n, false
+	}
+	if i := slices.Index(b.params, c.Name.Name.L); i >= 0 {
+		return parenthesize(b.args[i]), true
+	}
+	return n, false
+}
+
+func (b *macroBinder) Leave(n ast.Node) (ast.Node, bool) { return n, true }
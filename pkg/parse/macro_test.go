@@ -0,0 +1,151 @@
+package parse_test
+
+import (
+	"strings"
+	"testing"
+
+	_ "github.com/pingcap/tidb/pkg/types/parser_driver"
+
+	"github.com/berquerant/ndql/pkg/parse"
+	"github.com/pingcap/tidb/pkg/parser/format"
+	"github.com/stretchr/testify/assert"
+)
+
+func restore(t *testing.T, r *parse.Result) []string {
+	t.Helper()
+	xs := make([]string, len(r.Nodes))
+	for i, n := range r.Nodes {
+		var b strings.Builder
+		if !assert.Nil(t, n.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, &b))) {
+			return nil
+		}
+		xs[i] = b.String()
+	}
+	return xs
+}
+
+func TestMacro(t *testing.T) {
+	rc := parse.NewMacroSet()
+	if !assert.Nil(t, rc.Load("rc.sql", `-- comment
+define is_go(p) as extension(p) = ".go";
+
+/* comment; */
+create function go_test(p) returns is_go(p) and p like "%_test.go";
+`)) {
+		return
+	}
+	assert.Equal(t, 2, rc.Len())
+	m, ok := rc.Get("GO_TEST")
+	if assert.True(t, ok) {
+		assert.Equal(t, "go_test(p) defined at rc.sql:5", m.String())
+	}
+
+	for _, tc := range []struct {
+		title string
+		query string
+		want  []string
+		err   string
+	}{
+		{
+			title: "no macros",
+			query: `select path where size > 1`,
+			want:  []string{"SELECT `path` FROM DUAL WHERE `size`>1"},
+		},
+		{
+			title: "rc macro",
+			query: `select path where is_go(path)`,
+			want:  []string{"SELECT `path` FROM DUAL WHERE (EXTENSION(`path`)=_UTF8MB4'.go')"},
+		},
+		{
+			title: "nested macro",
+			query: `select path where Go_Test(path)`,
+			want:  []string{"SELECT `path` FROM DUAL WHERE ((EXTENSION(`path`)=_UTF8MB4'.go') AND `path` LIKE _UTF8MB4'%_test.go')"},
+		},
+		{
+			title: "query macro",
+			query: `define twice(x) as x * 2;
+select twice(twice(size + 1)) as s, 'define; x' as d; define zero() as 0; select zero()`,
+			want: []string{
+				"SELECT (((`size`+1)*2)*2) AS `s`,_UTF8MB4'define; x' AS `d`",
+				"SELECT 0",
+			},
+		},
+		{
+			title: "argument shadows column",
+			query: `define add_size(size) as size + t.size; select add_size(1) from (select size) as t`,
+			want:  []string{"SELECT (1+`t`.`size`) FROM (SELECT `size`) AS `t`"},
+		},
+		{
+			title: "arity",
+			query: `select is_go(path, 1)`,
+			err:   "macro is_go(p) defined at rc.sql:2: 1 arguments required but got 2",
+		},
+		{
+			title: "recursion",
+			query: `define f(x) as g(x);
+define g(x) as f(x);
+select f(path)`,
+			err: "macro f(x) defined at line 1: recursive call: f -> g -> f",
+		},
+		{
+			title: "redefinition",
+			query: `define is_go(x) as x`,
+			err:   "macro is_go(x) defined at line 1: already defined: is_go(p) defined at rc.sql:2",
+		},
+		{
+			title: "invalid body",
+			query: `select 1;
+define f(x) as x +;`,
+			err: "macro f(x) defined at line 2: invalid expression",
+		},
+		{
+			title: "placeholder in body",
+			query: `define f(x) as x > ?;
+select path where f(size) and extension(path) = ?`,
+			err: "macro f(x) defined at line 1: placeholder ? is not allowed",
+		},
+		{
+			title: "placeholder as argument",
+			query: `define f(x) as size > x;
+select path where f(?) and extension(path) = ?`,
+			want: []string{"SELECT `path` FROM DUAL WHERE (`size`>?) AND EXTENSION(`path`)=?"},
+		},
+		{
+			title: "invalid definition",
+			query: `define f as 1`,
+			err:   "line 1: invalid macro definition",
+		},
+		{
+			title: "syntax error after definition keeps line number",
+			query: `define f(x) as x;
+select select`,
+			err: "line 2",
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			r, err := parse.NewSQLParserWithMacros(rc).Parse(tc.query)
+			if tc.err != "" {
+				if assert.ErrorIs(t, err, parse.ErrParse) {
+					assert.Contains(t, err.Error(), tc.err)
+				}
+				return
+			}
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, tc.want, restore(t, r))
+		})
+	}
+
+	t.Run("query macros are local", func(t *testing.T) {
+		_, err := parse.NewSQLParserWithMacros(rc).Parse(`define local() as 1`)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, rc.Len())
+	})
+
+	t.Run("rc allows only definitions", func(t *testing.T) {
+		err := parse.NewMacroSet().Load("rc.sql", "define f() as 1;\nselect 1")
+		assert.ErrorIs(t, err, parse.ErrMacro)
+		assert.Contains(t, err.Error(), "rc.sql:2")
+	})
+}
@@ -0,0 +1,74 @@
+package parse
+
+import (
+	"cmp"
+	"slices"
+
+	ast "github.com/pingcap/tidb/pkg/parser/ast"
+)
+
+// Parameters and variables.
+//
+// @title Parameters
+// @path syntax.parameters
+// @document
+// `?` and `:name` are placeholders of the values given by `--param`:
+//
+// ```
+// ndql query --param 1=.go --param size=1024 'select path where extension(path) = ? and size > :size' dir
+// ```
+//
+// `?` is bound to the value of the parameter named by its 1-based position in the query,
+// and `:name` is the same as the variable `@name`.
+//
+// `SET` statement assigns the values to the variables:
+//
+// ```
+// set @ext = ".go", @since = newtime(2024, 1, 1);
+// select path where extension(path) = @ext and mod_time >= @since;
+// ```
+//
+// The expressions of `SET` are evaluated once when the statement runs,
+// and cannot refer to the columns.
+// `SET` cannot change the parameters given by `--param`, it fails on the names of them like `set @size = 1`.
+// Parameters and variables are treated as literals, so they are available in `IN`, `LIKE` and `BETWEEN`.
+//
+// The types of the values of `--param` are inferred like JSON:
+//
+// - `1`: Int
+// - `1.5`: Float
+// - `true`: Bool
+// - `null`: Null
+// - `"1"`: String
+// - `1h`: Duration
+// - `2024-01-02 03:04:05`: Time
+// - otherwise: String
+
+// orderParamMarkers sets the 0-based positions in the query to the placeholders.
+func orderParamMarkers(nodes []Node) {
+	c := &paramMarkerCollector{}
+	for _, n := range nodes {
+		n.Accept(c)
+	}
+	slices.SortStableFunc(c.markers, func(a, b ast.ParamMarkerExpr) int {
+		return cmp.Compare(a.OriginTextPosition(), b.OriginTextPosition())
+	})
+	for i, x := range c.markers {
+		x.SetOrder(i)
+	}
+}
+
+type paramMarkerCollector struct {
+	markers []ast.ParamMarkerExpr
+}
+
+var _ ast.Visitor = &paramMarkerCollector{}
+
+func (c *paramMarkerCollector) Enter(n ast.Node) (ast.Node, bool) {
+	if x, ok := n.(ast.ParamMarkerExpr); ok {
+		c.markers = append(c.markers, x)
+	}
+	return n, false
+}
+
+func (c *paramMarkerCollector) Leave(n ast.Node) (ast.Node, bool) { return n, true }
@@ -0,0 +1,35 @@
+package parse_test
+
+import (
+	"testing"
+
+	"github.com/berquerant/ndql/pkg/parse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParams(t *testing.T) {
+	for _, tc := range []struct {
+		title string
+		query string
+		want  []string
+	}{
+		{
+			title: "named",
+			query: `select :a, ':b', "c:d" where x = :_g1 -- :h`,
+			want:  []string{"SELECT @`a`,_UTF8MB4':b',_UTF8MB4'c:d' FROM DUAL WHERE `x`=@`_g1`"},
+		},
+		{
+			title: "positional",
+			query: `select ?, ?; select ?`,
+			want:  []string{"SELECT ?,?", "SELECT ?"},
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			r, err := parse.NewSQLParser().Parse(tc.query)
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, tc.want, restore(t, r))
+		})
+	}
+}
@@ -16,6 +16,8 @@ type Node = ast.StmtNode
 type Result struct {
 	Nodes []Node
 	Warns []error
+	// Macros are the macros available in the query, including the ones defined in the query.
+	Macros *MacroSet
 }
 
 const (
@@ -25,11 +27,22 @@ const (
 
 type SQLParser struct {
 	parser *tidb.Parser
+	macros *MacroSet
 }
 
 func NewSQLParser() *SQLParser {
+	return NewSQLParserWithMacros(nil)
+}
+
+// NewSQLParserWithMacros returns a parser that expands the macros.
+// Macros defined in the query are available only in the query.
+func NewSQLParserWithMacros(macros *MacroSet) *SQLParser {
+	if macros == nil {
+		macros = NewMacroSet()
+	}
 	return &SQLParser{
 		parser: tidb.New(),
+		macros: macros,
 	}
 }
 
@@ -38,12 +51,26 @@ var _ Parser = &SQLParser{}
 var ErrParse = errors.New("ParseError")
 
 func (p *SQLParser) Parse(s string) (*Result, error) {
+	macros := p.macros.Clone()
+	s, err := macros.define(replaceNamedParams(s))
+	if err != nil {
+		return nil, errors.Join(ErrParse, err)
+	}
 	nodes, warns, err := p.parser.Parse(s, charset, collation)
 	if err != nil {
 		return nil, errors.Join(ErrParse, err)
 	}
+	orderParamMarkers(nodes)
+	if macros.Len() > 0 {
+		for i, n := range nodes {
+			if nodes[i], err = macros.expand(n); err != nil {
+				return nil, errors.Join(ErrParse, err)
+			}
+		}
+	}
 	return &Result{
-		Nodes: nodes,
-		Warns: warns,
+		Nodes:  nodes,
+		Warns:  warns,
+		Macros: macros,
 	}, nil
 }
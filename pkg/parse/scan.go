@@ -0,0 +1,144 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// scanSQL calls f with the bytes of the SQL text except the comments.
+// quoted is true for the bytes of the quoted strings and identifiers including the quotes.
+func scanSQL(src string, f func(i, line int, quoted bool)) {
+	line := 1
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '#' || (c == '-' && strings.HasPrefix(src[i:], "--") && (i+2 == len(src) || strings.ContainsRune(" \t\r\n", rune(src[i+2])))):
+			// skip to the end of the line
+			for i+1 < len(src) && src[i+1] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(src[i:], "/*"):
+			i++
+			for i+1 < len(src) && !strings.HasPrefix(src[i+1:], "*/") {
+				i++
+				if src[i] == '\n' {
+					line++
+				}
+			}
+			i += 2
+		case c == '\'' || c == '"' || c == '`':
+			f(i, line, true)
+			for i+1 < len(src) {
+				i++
+				f(i, line, true)
+				switch src[i] {
+				case '\n':
+					line++
+				case '\\':
+					if c != '`' && i+1 < len(src) {
+						i++
+						f(i, line, true)
+						if src[i] == '\n' {
+							line++
+						}
+					}
+					continue
+				}
+				if src[i] == c {
+					break
+				}
+			}
+		default:
+			f(i, line, false)
+			if c == '\n' {
+				line++
+			}
+		}
+	}
+}
+
+// sqlStatement is a statement in the SQL text.
+type sqlStatement struct {
+	src   string
+	start int // offset of the first token
+	end   int // offset of the end, excluding the semicolon
+	line  int // 1-based line number of the first token
+}
+
+func (x sqlStatement) text() string { return x.src[x.start:x.end] }
+
+func (x sqlStatement) position(source string) string {
+	if source == "" {
+		return fmt.Sprintf("line %d", x.line)
+	}
+	return fmt.Sprintf("%s:%d", source, x.line)
+}
+
+// splitStatements splits the text by semicolons outside of the quotes and the comments.
+// Empty statements are skipped.
+func splitStatements(src string) []sqlStatement {
+	var (
+		r     []sqlStatement
+		start = -1 // offset of the first token of the current statement
+		first int  // line of the first token
+		last  int  // offset of the end of the last token
+	)
+	flush := func() {
+		if start >= 0 {
+			r = append(r, sqlStatement{
+				src:   src,
+				start: start,
+				end:   last,
+				line:  first,
+			})
+		}
+		start = -1
+	}
+	scanSQL(src, func(i, line int, quoted bool) {
+		switch c := src[i]; {
+		case !quoted && c == ';':
+			flush()
+		case !quoted && strings.IndexByte(" \t\r\n", c) >= 0:
+		default:
+			if start < 0 {
+				start = i
+				first = line
+			}
+			last = i + 1
+		}
+	})
+	flush()
+	return r
+}
+
+func isIdentifierByte(c byte, head bool) bool {
+	switch {
+	case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		return true
+	case '0' <= c && c <= '9':
+		return !head
+	default:
+		return false
+	}
+}
+
+// replaceNamedParams replaces the named parameters :name with the variables @name.
+func replaceNamedParams(src string) string {
+	var b []byte
+	scanSQL(src, func(i, _ int, quoted bool) {
+		if quoted || src[i] != ':' || i+1 == len(src) || !isIdentifierByte(src[i+1], true) {
+			return
+		}
+		if i > 0 && (isIdentifierByte(src[i-1], false) || src[i-1] == ':' || src[i-1] == '@') {
+			return
+		}
+		if b == nil {
+			b = []byte(src)
+		}
+		b[i] = '@'
+	})
+	if b == nil {
+		return src
+	}
+	return string(b)
+}
@@ -0,0 +1,244 @@
+package plan
+
+import (
+	"github.com/berquerant/ndql/pkg/node"
+	"github.com/berquerant/ndql/pkg/tree"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/opcode"
+	driver "github.com/pingcap/tidb/pkg/types/parser_driver"
+)
+
+// Cost is the rough cost to evaluate a condition for a row.
+type Cost int
+
+const (
+	// CostCheap is of the columns, the literals, the operators and the functions computed in memory.
+	CostCheap Cost = iota
+	// CostIO is of the functions that read the files like read_file.
+	CostIO
+	// CostScript is of the functions that run scripts, sleep and the functions unknown to the plan.
+	CostScript
+)
+
+func (c Cost) String() string {
+	switch c {
+	case CostCheap:
+		return "Cheap"
+	case CostIO:
+		return "IO"
+	default:
+		return "Script"
+	}
+}
+
+var (
+	// ioFunctions read the files.
+	ioFunctions = map[string]bool{
+		tree.FuncLineCount: true,
+		tree.FuncFileHash:  true,
+		tree.FuncMimeType:  true,
+		tree.FuncIsBinary:  true,
+		tree.FuncEncoding:  true,
+		tree.FuncFirstLine: true,
+		tree.FuncReadFile:  true,
+	}
+	// scriptFunctions run scripts or take time.
+	scriptFunctions = map[string]bool{
+		tree.FuncExprValue: true,
+		tree.FuncLuaValue:  true,
+		tree.FuncSleep:     true,
+	}
+	// volatileFunctions may return different values for the same arguments.
+	volatileFunctions = map[string]bool{
+		tree.FuncRand:     true,
+		tree.FuncNow:      true,
+		tree.FuncSleep:    true,
+		tree.FuncLuaValue: true,
+	}
+	// generatorFunctions merge the generated nodes into the original node.
+	generatorFunctions = map[string]bool{
+		tree.FuncExpr:      true,
+		tree.FuncLua:       true,
+		tree.FuncStar:      true,
+		tree.FuncWasm:      true,
+		tree.FuncGrep:      true,
+		tree.FuncSh:        true,
+		tree.FuncProc:      true,
+		tree.FuncTmpl:      true,
+		tree.FuncReadLines: true,
+		tree.FuncLines:     true,
+	}
+	// passThroughFunctions are the generators whose keys of the generated nodes are not builtin keys.
+	passThroughFunctions = map[string]bool{
+		tree.FuncReadLines: true,
+		tree.FuncLines:     true,
+	}
+	// boolFunctions return Bool.
+	boolFunctions = map[string]bool{
+		tree.FuncIsBinary:   true,
+		tree.FuncRegexpLike: true,
+		tree.FuncToBool:     true,
+	}
+	builtinFunctions = func() map[string]bool {
+		r := map[string]bool{}
+		for _, x := range tree.NewFunctionRegistry().Names() {
+			r[x] = true
+		}
+		return r
+	}()
+)
+
+// Cond is a condition of WHERE.
+type Cond struct {
+	Expr ast.ExprNode
+	Cost Cost
+	// keys are the columns referred by the condition.
+	keys []string
+	// pure is true if the condition refers only to the unqualified builtin keys
+	// and the result depends only on them.
+	pure bool
+	// boolean is true if the condition is evaluated to Bool, so it can be joined with the others by AND.
+	boolean bool
+}
+
+func newCond(expr ast.ExprNode) *Cond {
+	a := &condAnalyzer{
+		cost: CostCheap,
+		pure: true,
+	}
+	expr.Accept(a)
+	return &Cond{
+		Expr:    expr,
+		Cost:    a.cost,
+		keys:    a.keys,
+		pure:    a.pure,
+		boolean: isBoolean(expr),
+	}
+}
+
+// splitConds returns the conjuncts of the expression,
+// or the expression itself if some of them are not evaluated to Bool
+// because AND fails on them while WHERE accepts them.
+func splitConds(expr ast.ExprNode) []*Cond {
+	if expr == nil {
+		return nil
+	}
+	xs := splitAnd(expr)
+	r := make([]*Cond, len(xs))
+	for i, x := range xs {
+		c := newCond(x)
+		if !c.boolean {
+			return []*Cond{newCond(expr)}
+		}
+		r[i] = c
+	}
+	return r
+}
+
+func splitAnd(expr ast.ExprNode) []ast.ExprNode {
+	switch x := expr.(type) {
+	case *ast.BinaryOperationExpr:
+		if x.Op == opcode.LogicAnd {
+			return append(splitAnd(x.L), splitAnd(x.R)...)
+		}
+	case *ast.ParenthesesExpr:
+		if y, ok := x.Expr.(*ast.BinaryOperationExpr); ok && y.Op == opcode.LogicAnd {
+			return splitAnd(y)
+		}
+	}
+	return []ast.ExprNode{expr}
+}
+
+// joinAnd joins the expressions by AND.
+func joinAnd(xs []ast.ExprNode) ast.ExprNode {
+	r := xs[0]
+	for _, x := range xs[1:] {
+		r = &ast.BinaryOperationExpr{
+			Op: opcode.LogicAnd,
+			L:  r,
+			R:  x,
+		}
+	}
+	return r
+}
+
+// isBoolean returns true if the expression is always evaluated to Bool or fails.
+func isBoolean(expr ast.ExprNode) bool {
+	switch x := expr.(type) {
+	case *ast.BinaryOperationExpr:
+		switch x.Op {
+		case opcode.EQ, opcode.NE, opcode.LT, opcode.LE, opcode.GT, opcode.GE:
+			return true
+		case opcode.LogicAnd, opcode.LogicOr, opcode.LogicXor:
+			return isBoolean(x.L) && isBoolean(x.R)
+		}
+		return false
+	case *ast.UnaryOperationExpr:
+		return x.Op == opcode.Not && isBoolean(x.V)
+	case *ast.ParenthesesExpr:
+		return isBoolean(x.Expr)
+	case *ast.PatternInExpr:
+		return x.Sel == nil
+	case *ast.PatternLikeOrIlikeExpr, *ast.PatternRegexpExpr, *ast.BetweenExpr, *ast.IsNullExpr, *ast.IsTruthExpr:
+		return true
+	case *ast.FuncCallExpr:
+		return boolFunctions[x.FnName.L]
+	case *ast.ColumnNameExpr:
+		return isBuiltinColumn(x.Name, node.KeyIsDir)
+	}
+	return false
+}
+
+// isBuiltinColumn returns true if the column is the unqualified builtin key.
+func isBuiltinColumn(n *ast.ColumnName, key string) bool {
+	return n.Schema.O == "" && n.Table.O == "" && n.Name.O == key
+}
+
+// condAnalyzer collects the columns and the cost of an expression.
+type condAnalyzer struct {
+	cost Cost
+	keys []string
+	pure bool
+}
+
+var _ ast.Visitor = &condAnalyzer{}
+
+func (a *condAnalyzer) Enter(n ast.Node) (ast.Node, bool) {
+	switch x := n.(type) {
+	case *ast.ColumnNameExpr:
+		if !(x.Name.Schema.O == "" && x.Name.Table.O == "" && node.IsBuiltinKey(x.Name.Name.O)) {
+			a.pure = false
+		}
+		a.keys = append(a.keys, x.Name.Name.O)
+		return n, true
+	case *ast.FuncCallExpr:
+		name := x.FnName.L
+		switch {
+		case !builtinFunctions[name] || generatorFunctions[name]:
+			a.pure = false
+			a.cost = max(a.cost, CostScript)
+		case scriptFunctions[name]:
+			a.cost = max(a.cost, CostScript)
+		case ioFunctions[name]:
+			a.cost = max(a.cost, CostIO)
+		}
+		if volatileFunctions[name] {
+			a.pure = false
+		}
+	case *ast.VariableExpr:
+		if x.Value != nil || x.IsSystem {
+			a.pure = false
+		}
+	case *driver.ValueExpr, *driver.ParamMarkerExpr,
+		*ast.BinaryOperationExpr, *ast.UnaryOperationExpr, *ast.ParenthesesExpr,
+		*ast.PatternInExpr, *ast.PatternLikeOrIlikeExpr, *ast.PatternRegexpExpr,
+		*ast.BetweenExpr, *ast.IsNullExpr, *ast.IsTruthExpr, *ast.CaseExpr, *ast.WhenClause:
+	default:
+		// subqueries, aggregations and so on
+		a.pure = false
+		a.cost = max(a.cost, CostScript)
+	}
+	return n, false
+}
+
+func (a *condAnalyzer) Leave(n ast.Node) (ast.Node, bool) { return n, true }
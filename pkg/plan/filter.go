@@ -0,0 +1,300 @@
+package plan
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/berquerant/ndql/pkg/iox"
+	"github.com/berquerant/ndql/pkg/node"
+	"github.com/berquerant/ndql/pkg/tree"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/opcode"
+	"github.com/pingcap/tidb/pkg/types"
+	driver "github.com/pingcap/tidb/pkg/types/parser_driver"
+)
+
+// Filter is the condition of the entries of the walker derived from WHERE of the innermost query.
+//
+// The entries rejected by the filter are also rejected by WHERE,
+// the conditions remain in WHERE because the filter does not cover all of them.
+type Filter struct {
+	// never is true if the conditions contradict.
+	never bool
+	// paths and exts are nil if any.
+	paths map[string]bool
+	exts  map[string]bool
+	isDir *bool
+	sizes []sizeBound
+}
+
+type sizeBound struct {
+	op    opcode.Op
+	value float64
+}
+
+func (b sizeBound) String() string {
+	var op string
+	switch b.op {
+	case opcode.EQ:
+		op = "="
+	case opcode.LT:
+		op = "<"
+	case opcode.LE:
+		op = "<="
+	case opcode.GT:
+		op = ">"
+	case opcode.GE:
+		op = ">="
+	}
+	return fmt.Sprintf("size %s %v", op, b.value)
+}
+
+func (b sizeBound) match(size int64) bool {
+	x := float64(size)
+	switch b.op {
+	case opcode.EQ:
+		return x == b.value
+	case opcode.LT:
+		return x < b.value
+	case opcode.LE:
+		return x <= b.value
+	case opcode.GT:
+		return x > b.value
+	case opcode.GE:
+		return x >= b.value
+	default:
+		return true
+	}
+}
+
+var _ iox.WalkFilter = &Filter{}
+
+// Filter returns the filter derived from the conditions of the innermost plan, nil if none.
+func (s *Select) Filter() *Filter {
+	if s.From != nil {
+		return s.From.Filter()
+	}
+	if s.stmt.From != nil {
+		// not a SELECT statement
+		return nil
+	}
+	f := &Filter{}
+	var ok bool
+	for _, c := range s.Conds {
+		if c.boolean && f.add(c.Expr) {
+			ok = true
+		}
+	}
+	if !ok {
+		return nil
+	}
+	return f
+}
+
+// add adds the condition, returns false if the condition is not available for the filter.
+func (f *Filter) add(expr ast.ExprNode) bool {
+	switch x := unwrapParentheses(expr).(type) {
+	case *ast.ColumnNameExpr:
+		// is_dir
+		if isBuiltinColumn(x.Name, node.KeyIsDir) {
+			return f.setIsDir(true)
+		}
+	case *ast.UnaryOperationExpr:
+		// not is_dir
+		if y, ok := unwrapParentheses(x.V).(*ast.ColumnNameExpr); ok && x.Op == opcode.Not && isBuiltinColumn(y.Name, node.KeyIsDir) {
+			return f.setIsDir(false)
+		}
+	case *ast.PatternInExpr:
+		// path in (...), extension(path) in (...)
+		if x.Not || x.Sel != nil {
+			return false
+		}
+		var values []string
+		for _, y := range x.List {
+			if !isLiteral(y) {
+				// variables
+				return false
+			}
+			// values other than String never equal to String
+			if s, ok := stringLiteral(y); ok {
+				values = append(values, s)
+			}
+		}
+		return f.addStrings(x.Expr, values)
+	case *ast.BinaryOperationExpr:
+		// path = "...", extension(path) = "...", size > 1024
+		op, operand, value := x.Op, x.L, x.R
+		if isLiteral(operand) {
+			op, operand, value = reverseCompare(op), value, operand
+		}
+		if s, ok := stringLiteral(value); ok && op == opcode.EQ {
+			return f.addStrings(operand, []string{s})
+		}
+		if v, ok := numberLiteral(value); ok {
+			if y, ok := unwrapParentheses(operand).(*ast.ColumnNameExpr); ok && isBuiltinColumn(y.Name, node.KeySize) {
+				switch op {
+				case opcode.EQ, opcode.LT, opcode.LE, opcode.GT, opcode.GE:
+					f.sizes = append(f.sizes, sizeBound{
+						op:    op,
+						value: v,
+					})
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (f *Filter) setIsDir(v bool) bool {
+	if f.isDir != nil && *f.isDir != v {
+		f.never = true
+	}
+	f.isDir = &v
+	return true
+}
+
+// addStrings adds the condition that path or extension(path) is one of the values.
+func (f *Filter) addStrings(expr ast.ExprNode, values []string) bool {
+	set := map[string]bool{}
+	for _, v := range values {
+		set[v] = true
+	}
+	switch x := unwrapParentheses(expr).(type) {
+	case *ast.ColumnNameExpr:
+		if isBuiltinColumn(x.Name, node.KeyPath) {
+			f.paths = intersect(f.paths, set)
+			return true
+		}
+	case *ast.FuncCallExpr:
+		if x.FnName.L != tree.FuncExtension || len(x.Args) != 1 {
+			return false
+		}
+		if y, ok := unwrapParentheses(x.Args[0]).(*ast.ColumnNameExpr); ok && isBuiltinColumn(y.Name, node.KeyPath) {
+			f.exts = intersect(f.exts, set)
+			return true
+		}
+	}
+	return false
+}
+
+func intersect(a, b map[string]bool) map[string]bool {
+	if a == nil {
+		return b
+	}
+	r := map[string]bool{}
+	for k := range a {
+		if b[k] {
+			r[k] = true
+		}
+	}
+	return r
+}
+
+func isLiteral(expr ast.ExprNode) bool {
+	_, ok := expr.(*driver.ValueExpr)
+	return ok
+}
+
+func stringLiteral(expr ast.ExprNode) (string, bool) {
+	x, ok := expr.(*driver.ValueExpr)
+	if !ok || x.Kind() != types.KindString {
+		return "", false
+	}
+	return x.GetString(), true
+}
+
+func numberLiteral(expr ast.ExprNode) (float64, bool) {
+	x, ok := expr.(*driver.ValueExpr)
+	if !ok {
+		return 0, false
+	}
+	switch x.Kind() {
+	case types.KindInt64:
+		return float64(x.GetInt64()), true
+	case types.KindUint64:
+		return float64(x.GetUint64()), true
+	case types.KindFloat32, types.KindFloat64:
+		return x.GetFloat64(), true
+	default:
+		return 0, false
+	}
+}
+
+// reverseCompare returns the operator for the swapped operands.
+func reverseCompare(op opcode.Op) opcode.Op {
+	switch op {
+	case opcode.LT:
+		return opcode.GT
+	case opcode.LE:
+		return opcode.GE
+	case opcode.GT:
+		return opcode.LT
+	case opcode.GE:
+		return opcode.LE
+	default:
+		return op
+	}
+}
+
+// Match returns true if the entry may satisfy the conditions.
+func (f *Filter) Match(e *iox.WalkerEntry) bool {
+	if f.never {
+		return false
+	}
+	if f.paths != nil && !f.paths[e.Path] {
+		return false
+	}
+	if f.exts != nil && !f.exts[filepath.Ext(e.Path)] {
+		return false
+	}
+	if f.isDir != nil && *f.isDir != e.IsDir {
+		return false
+	}
+	for _, b := range f.sizes {
+		if !b.match(e.Size) {
+			return false
+		}
+	}
+	return true
+}
+
+// Descend returns false if no paths of the conditions are under the directory.
+func (f *Filter) Descend(dir string) bool {
+	if f.never {
+		return false
+	}
+	if f.paths == nil {
+		return true
+	}
+	prefix := dir + string(filepath.Separator)
+	for p := range f.paths {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) String() string {
+	if f.never {
+		return "false"
+	}
+	var xs []string
+	if f.paths != nil {
+		xs = append(xs, fmt.Sprintf("path in %q", slices.Sorted(maps.Keys(f.paths))))
+	}
+	if f.exts != nil {
+		xs = append(xs, fmt.Sprintf("extension in %q", slices.Sorted(maps.Keys(f.exts))))
+	}
+	if f.isDir != nil {
+		xs = append(xs, fmt.Sprintf("is_dir = %v", *f.isDir))
+	}
+	for _, b := range f.sizes {
+		xs = append(xs, b.String())
+	}
+	return strings.Join(xs, " and ")
+}
@@ -0,0 +1,279 @@
+// Package plan rewrites SELECT statements to evaluate cheap conditions early.
+//
+// @title Optimizer
+// @path syntax.optimizer
+// @document
+// Queries are rewritten before they run, unless `--no_optimize`:
+//
+//   - The conditions of `WHERE` on the builtin keys like `path` and `size` are pushed into the subquery in `FROM`,
+//     so they run before `read_lines()` of the subquery.
+//   - The conditions are evaluated in the order of the cost, so the cheap ones run before the ones reading the files
+//     like `read_file()` or running scripts like `lua_value()`, and the rows rejected by the cheap ones are not passed to the others.
+//   - The conditions of the innermost query like `extension(path) = ".go"`, `path = "dir/file"`, `size > 1024` and `not is_dir`
+//     are evaluated by the walker of the paths, and the directories that cannot contain the paths of `path = ...` are not read.
+//
+// For example,
+//
+// ```
+// select path, line from (select read_lines(path) where not is_dir) where extension(path) = ".go" and line like "%TODO%"
+// ```
+//
+// reads only the lines of the go files.
+//
+// The conditions joined by `AND` are split only if all of them are evaluated to Bool, like comparisons and `LIKE`.
+// A condition is pushed only if it refers only to the builtin keys without table names,
+// does not contain functions like `rand()` and `now()`,
+// and the subquery passes the builtin keys through by `*`, by the columns of the same names or by `read_lines()` without `AS`.
+// The other generators like `sh()` may overwrite the builtin keys, so the conditions are not pushed through them.
+//
+// The rewritten query is the same as the original one except that some rows that fail to be evaluated
+// are rejected by the cheaper conditions before failing.
+package plan
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/format"
+)
+
+// Select is the logical plan of a SELECT statement:
+// the rows from the source are filtered by the conditions in order, then the fields are evaluated.
+type Select struct {
+	stmt *ast.SelectStmt
+	// From is the plan of the subquery in FROM,
+	// nil if the source is the input rows or the source is not a SELECT statement.
+	From  *Select
+	Conds []*Cond
+	// changed is true if the conditions are changed by Optimize.
+	changed bool
+}
+
+// New returns the logical plan of the statement.
+func New(stmt *ast.SelectStmt) *Select {
+	s := &Select{
+		stmt:  stmt,
+		Conds: splitConds(stmt.Where),
+	}
+	if x, ok := subquery(stmt); ok {
+		s.From = New(x)
+	}
+	return s
+}
+
+// subquery returns the SELECT statement in FROM.
+func subquery(stmt *ast.SelectStmt) (*ast.SelectStmt, bool) {
+	if stmt.From == nil || stmt.From.TableRefs == nil || stmt.From.TableRefs.Right != nil {
+		return nil, false
+	}
+	t, ok := stmt.From.TableRefs.Left.(*ast.TableSource)
+	if !ok {
+		return nil, false
+	}
+	x, ok := t.Source.(*ast.SelectStmt)
+	return x, ok
+}
+
+// Optimize applies the rules to the plan and the plans of the subqueries.
+func (s *Select) Optimize() {
+	s.pushDown()
+	if s.From != nil {
+		s.From.Optimize()
+	}
+	s.sortConds()
+}
+
+// pushDown moves the conditions on the builtin keys into the subquery.
+func (s *Select) pushDown() {
+	if s.From == nil || !acceptsConds(s.From.stmt) {
+		return
+	}
+	var conds []*Cond
+	for _, c := range s.Conds {
+		if c.pure && passesKeys(s.From.stmt.Fields, c.keys) {
+			s.From.Conds = append(s.From.Conds, c)
+			s.From.changed = true
+			s.changed = true
+			continue
+		}
+		conds = append(conds, c)
+	}
+	s.Conds = conds
+}
+
+// acceptsConds returns true if the conditions can be added to WHERE of the statement.
+func acceptsConds(stmt *ast.SelectStmt) bool {
+	return stmt.Kind == ast.SelectStmtKindSelect &&
+		!stmt.Distinct &&
+		stmt.GroupBy == nil &&
+		stmt.Having == nil &&
+		len(stmt.WindowSpecs) == 0 &&
+		stmt.OrderBy == nil &&
+		stmt.Limit == nil
+}
+
+// passesKeys returns true if the fields yield the values of the keys of the rows as they are.
+func passesKeys(fields *ast.FieldList, keys []string) bool {
+	if fields == nil {
+		return false
+	}
+	for _, k := range keys {
+		var passed bool
+		for _, f := range fields.Fields {
+			switch {
+			case f.WildCard != nil:
+				passed = true
+			case f.AsName.O != "" && f.AsName.O != k:
+				// renames the other column
+			case isPassThroughField(f, len(fields.Fields)):
+				passed = true
+			default:
+				if x, ok := unwrapParentheses(f.Expr).(*ast.ColumnNameExpr); ok {
+					if x.Name.Name.O == k || f.AsName.O == k {
+						passed = isBuiltinColumn(x.Name, k)
+					}
+					continue
+				}
+				if f.AsName.O == k || f.AsName.O == "" && f.Text() == k {
+					passed = false
+				}
+			}
+		}
+		if !passed {
+			return false
+		}
+	}
+	return true
+}
+
+func unwrapParentheses(expr ast.ExprNode) ast.ExprNode {
+	for {
+		x, ok := expr.(*ast.ParenthesesExpr)
+		if !ok {
+			return expr
+		}
+		expr = x.Expr
+	}
+}
+
+// isPassThroughField returns true if the field is a builtin generator that merges the generated nodes into the original node
+// without overwriting the builtin keys.
+func isPassThroughField(f *ast.SelectField, fields int) bool {
+	x, ok := f.Expr.(*ast.FuncCallExpr)
+	return ok && fields == 1 && f.AsName.O == "" && passThroughFunctions[x.FnName.L]
+}
+
+// sortConds sorts the conditions by the cost, stably.
+func (s *Select) sortConds() {
+	if slices.IsSortedFunc(s.Conds, compareCond) {
+		return
+	}
+	slices.SortStableFunc(s.Conds, compareCond)
+	s.changed = true
+}
+
+func compareCond(a, b *Cond) int { return int(a.Cost - b.Cost) }
+
+// stages returns the WHERE clauses to be evaluated in order.
+// The conditions of the same cost are joined by AND if possible.
+func (s *Select) stages() []ast.ExprNode {
+	var (
+		r     []ast.ExprNode
+		stage []ast.ExprNode
+		cost  Cost
+	)
+	flush := func() {
+		if len(stage) > 0 {
+			r = append(r, joinAnd(stage))
+			stage = nil
+		}
+	}
+	for _, c := range s.Conds {
+		if !c.boolean {
+			flush()
+			r = append(r, c.Expr)
+			continue
+		}
+		if c.Cost != cost {
+			flush()
+			cost = c.Cost
+		}
+		stage = append(stage, c.Expr)
+	}
+	flush()
+	return r
+}
+
+// Stmt returns the statement of the plan, the original one if not changed.
+//
+// The conditions of different costs are evaluated in the subqueries
+// like SELECT * FROM (SELECT * FROM src WHERE cheap) WHERE expensive.
+func (s *Select) Stmt() *ast.SelectStmt {
+	from := s.stmt.From
+	if s.From != nil {
+		if x := s.From.Stmt(); x != s.From.stmt {
+			from = replaceSubquery(from, x)
+		}
+	}
+	if !s.changed && from == s.stmt.From {
+		return s.stmt
+	}
+
+	r := *s.stmt
+	r.From = from
+	if !s.changed {
+		return &r
+	}
+	r.Where = nil
+	stages := s.stages()
+	if n := len(stages); n > 0 {
+		for _, x := range stages[:n-1] {
+			r.From = &ast.TableRefsClause{
+				TableRefs: &ast.Join{
+					Left: &ast.TableSource{
+						Source: &ast.SelectStmt{
+							SelectStmtOpts: &ast.SelectStmtOpts{
+								SQLCache: true,
+							},
+							Kind: ast.SelectStmtKindSelect,
+							Fields: &ast.FieldList{
+								Fields: []*ast.SelectField{
+									{
+										WildCard: &ast.WildCardField{},
+									},
+								},
+							},
+							From:  r.From,
+							Where: x,
+						},
+					},
+				},
+			}
+		}
+		r.Where = stages[n-1]
+	}
+	return &r
+}
+
+func replaceSubquery(from *ast.TableRefsClause, stmt *ast.SelectStmt) *ast.TableRefsClause {
+	t := *from.TableRefs.Left.(*ast.TableSource)
+	t.Source = stmt
+	j := *from.TableRefs
+	j.Left = &t
+	return &ast.TableRefsClause{
+		TableRefs: &j,
+	}
+}
+
+// String returns the SQL of the statement of the plan.
+func (s *Select) String() string { return Restore(s.Stmt()) }
+
+// Restore returns the SQL of the node.
+func Restore(n ast.Node) string {
+	var b strings.Builder
+	if err := n.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, &b)); err != nil {
+		return err.Error()
+	}
+	return b.String()
+}
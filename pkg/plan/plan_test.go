@@ -0,0 +1,174 @@
+package plan_test
+
+import (
+	"testing"
+
+	"github.com/berquerant/ndql/pkg/errorx"
+	"github.com/berquerant/ndql/pkg/iox"
+	"github.com/berquerant/ndql/pkg/parse"
+	"github.com/berquerant/ndql/pkg/plan"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelect(t *testing.T) {
+	for _, tc := range []struct {
+		title  string
+		query  string
+		want   string // empty means not changed
+		filter string // empty means no filter
+	}{
+		{
+			title:  "no where",
+			query:  `select path`,
+			filter: "",
+		},
+		{
+			title:  "cheap only",
+			query:  `select path where size > 10 and not is_dir`,
+			filter: "is_dir = false and size > 10",
+		},
+		{
+			title:  "cheap first",
+			query:  `select path where read_file(path) = "x" and extension(path) = ".txt"`,
+			want:   "SELECT `path` FROM (SELECT * FROM DUAL WHERE EXTENSION(`path`)=_UTF8MB4'.txt') WHERE READ_FILE(`path`)=_UTF8MB4'x'",
+			filter: `extension in [".txt"]`,
+		},
+		{
+			title:  "cost order",
+			query:  `select path where lua_value("", "") = 1 and line_count(path) > 1 and size > 1`,
+			want:   "SELECT `path` FROM (SELECT * FROM (SELECT * FROM DUAL WHERE `size`>1) WHERE LINE_COUNT(`path`)>1) WHERE LUA_VALUE(_UTF8MB4'', _UTF8MB4'')=1",
+			filter: "size > 1",
+		},
+		{
+			title:  "push down into subquery",
+			query:  `select sh("echo x") from (select * where is_dir) where extension(path) = ".mp3" and x = 1`,
+			want:   "SELECT SH(_UTF8MB4'echo x') FROM (SELECT * FROM DUAL WHERE `is_dir` AND EXTENSION(`path`)=_UTF8MB4'.mp3') WHERE `x`=1",
+			filter: `extension in [".mp3"] and is_dir = true`,
+		},
+		{
+			title:  "push down before read_lines",
+			query:  `select line from (select read_lines(path)) as t where extension(path) = ".go" and line = "x" and line_count(path) > 1`,
+			want:   "SELECT `line` FROM (SELECT READ_LINES(`path`) FROM (SELECT * FROM DUAL WHERE EXTENSION(`path`)=_UTF8MB4'.go') WHERE LINE_COUNT(`path`)>1) AS `t` WHERE `line`=_UTF8MB4'x'",
+			filter: `extension in [".go"]`,
+		},
+		{
+			title: "generator overwriting builtin keys",
+			query: `select path, size from (select sh("echo size=100")) where size = "100"`,
+		},
+		{
+			title:  "not push down through generator",
+			query:  `select x from (select sh("echo x")) as t where line_count(path) > 1 and extension(path) = ".mp3"`,
+			want:   "SELECT `x` FROM (SELECT * FROM (SELECT SH(_UTF8MB4'echo x')) AS `t` WHERE EXTENSION(`path`)=_UTF8MB4'.mp3') WHERE LINE_COUNT(`path`)>1",
+			filter: "",
+		},
+		{
+			title:  "push down through nested subqueries",
+			query:  `select path from (select * from (select path, size)) where path in ("a", "b/c") and size <= 3`,
+			want:   "SELECT `path` FROM (SELECT * FROM (SELECT `path`,`size` FROM DUAL WHERE `path` IN (_UTF8MB4'a',_UTF8MB4'b/c') AND `size`<=3))",
+			filter: `path in ["a" "b/c"] and size <= 3`,
+		},
+		{
+			title: "generator with alias",
+			query: `select x from (select sh("echo x") as y) where extension(path) = ".mp3"`,
+		},
+		{
+			title:  "renamed key",
+			query:  `select x from (select path, size + 1 as size) where extension(path) = ".mp3" and size > 1`,
+			want:   "SELECT `x` FROM (SELECT `path`,`size`+1 AS `size` FROM DUAL WHERE EXTENSION(`path`)=_UTF8MB4'.mp3') WHERE `size`>1",
+			filter: `extension in [".mp3"]`,
+		},
+		{
+			title: "missing key",
+			query: `select x from (select path) where size > 1`,
+		},
+		{
+			title: "qualified key",
+			query: `select x from (select *) as t where t.size > 1`,
+		},
+		{
+			title: "volatile",
+			query: `select x from (select *) where size > rand()`,
+		},
+		{
+			title: "unknown function",
+			query: `select x from (select *) where my_func(path)`,
+		},
+		{
+			title:  "not Bool",
+			query:  `select x from (select *) where size and is_dir`,
+			want:   "SELECT `x` FROM (SELECT * FROM DUAL WHERE `size` AND `is_dir`)",
+			filter: "",
+		},
+		{
+			title:  "not Bool in subquery",
+			query:  `select x from (select * where size) where is_dir`,
+			want:   "SELECT `x` FROM (SELECT * FROM (SELECT * FROM DUAL WHERE `size`) WHERE `is_dir`)",
+			filter: "is_dir = true",
+		},
+		{
+			title:  "contradiction",
+			query:  `select path where is_dir and not is_dir`,
+			filter: "false",
+		},
+		{
+			title: "variables",
+			query: `select path where extension(path) in (@a, ".go")`,
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			p, err := parse.NewSQLParser().Parse(tc.query)
+			if !assert.Nil(t, err, "query syntax: %s", errorx.AsString(err)) {
+				return
+			}
+			stmt := p.Nodes[0].(*ast.SelectStmt)
+			s := plan.New(stmt)
+			s.Optimize()
+			if tc.want == "" {
+				assert.Same(t, stmt, s.Stmt())
+			} else {
+				assert.Equal(t, tc.want, s.String())
+			}
+			if f := s.Filter(); tc.filter == "" {
+				assert.Nil(t, f)
+			} else if assert.NotNil(t, f) {
+				assert.Equal(t, tc.filter, f.String())
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	p, err := parse.NewSQLParser().Parse(`select path where path in ("a/b/c.go", "a/d.txt", "x.go") and extension(path) = ".go" and size > 1`)
+	if !assert.Nil(t, err) {
+		return
+	}
+	s := plan.New(p.Nodes[0].(*ast.SelectStmt))
+	s.Optimize()
+	f := s.Filter()
+	if !assert.NotNil(t, f) {
+		return
+	}
+
+	for _, tc := range []struct {
+		path string
+		size int64
+		want bool
+	}{
+		{path: "a/b/c.go", size: 2, want: true},
+		{path: "x.go", size: 2, want: true},
+		{path: "x.go", size: 1, want: false},
+		{path: "a/d.txt", size: 2, want: false},
+		{path: "a/b/e.go", size: 2, want: false},
+	} {
+		assert.Equal(t, tc.want, f.Match(&iox.WalkerEntry{
+			Path: tc.path,
+			Size: tc.size,
+		}), tc.path)
+	}
+
+	assert.True(t, f.Descend("a"))
+	assert.True(t, f.Descend("a/b"))
+	assert.False(t, f.Descend("a/b/c"))
+	assert.False(t, f.Descend("b"))
+}
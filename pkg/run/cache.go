@@ -0,0 +1,29 @@
+package run
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/berquerant/ndql/pkg/cachex"
+)
+
+func (r *runner) cachePrune() error {
+	var olderThan time.Duration
+	if r.OlderThan != "" {
+		d, err := time.ParseDuration(r.OlderThan)
+		if err != nil {
+			return fmt.Errorf("%w: invalid older_than", err)
+		}
+		olderThan = d
+	}
+
+	c := cachex.NewDiskCache(r.ResultCacheDir())
+	slog.Debug("Prune cache", slog.String("dir", c.Root()), slog.Duration("older_than", olderThan))
+	n, err := c.Prune(olderThan)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(r.Stdout, n)
+	return nil
+}
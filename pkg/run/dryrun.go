@@ -9,7 +9,7 @@ func (r *runner) dryrun() error {
 	if err := r.SetupQuery(); err != nil {
 		return err
 	}
-	p, err := parse.NewSQLParser().Parse(r.Query)
+	p, err := parse.NewSQLParserWithMacros(r.Macros).Parse(r.Query)
 	if err != nil {
 		return err
 	}
@@ -2,12 +2,11 @@ package run
 
 import (
 	"context"
-	"fmt"
+	"iter"
 
-	"github.com/berquerant/ndql/pkg/iterx"
-	"github.com/berquerant/ndql/pkg/parse"
+	"github.com/berquerant/ndql"
+	"github.com/berquerant/ndql/pkg/node"
 	"github.com/berquerant/ndql/pkg/tree"
-	"golang.org/x/sync/errgroup"
 )
 
 func (r *runner) query(ctx context.Context) error {
@@ -17,42 +16,61 @@ func (r *runner) query(ctx context.Context) error {
 	if err := r.SetupQuery(); err != nil {
 		return err
 	}
-	p, err := parse.NewSQLParser().Parse(r.Query)
+	it, err := r.Sources.ReadInput()
 	if err != nil {
 		return err
 	}
-	it, err := r.Sources.ReadInput()
+	opts, err := r.queryOptions()
 	if err != nil {
 		return err
 	}
-	cit := iterx.NewClonableIter(it)
-	defer cit.Close()
-
-	var (
-		eg, eCtx = errgroup.WithContext(ctx)
-		recvCs   = make([]chan *tree.N, len(p.Nodes))
-	)
-	for i := range p.Nodes {
-		recvC := make(chan *tree.N, 100)
-		recvCs[i] = recvC
-		eg.Go(func() error {
-			for n := range recvC {
-				r.WriteNode(n)
-			}
-			return nil
-		})
+	report, err := r.newRowErrorReport()
+	if err != nil {
+		return err
+	}
+	defer report.close()
+	defer report.writeSummary(r.Stderr)
+	opts = append(opts, ndql.WithRowErrorHandler(report.add))
+	for n, err := range ndql.Query(ctx, r.Query, r.querySource(it), opts...) {
+		if err != nil {
+			return err
+		}
+		r.WriteNode(n)
 	}
+	return nil
+}
 
-	concurrency := int(r.Concurrency)
-	for i, n := range p.Nodes {
-		vit := cit.Clone()
-		eg.Go(func() error {
-			if err := tree.AsChan(eCtx, vit.Values(), n, concurrency, recvCs[i]); err != nil {
-				return fmt.Errorf("%w: node[%d]", err, i)
-			}
-			return nil
-		})
+// querySource returns the source of the query,
+// the walker of the paths so that the optimizer filters the entries.
+func (r *runner) querySource(it iter.Seq[*node.Node]) ndql.Source {
+	if r.Sources.Path != nil {
+		return ndql.FromWalker(r.Sources.Path)
 	}
+	return ndql.FromNodes(it)
+}
 
-	return eg.Wait()
+func (r *runner) queryOptions() ([]ndql.Option, error) {
+	opts := []ndql.Option{
+		ndql.WithConcurrency(int(r.Concurrency)),
+		ndql.WithOrdered(r.Ordered),
+		ndql.WithOptimize(!r.NoOptimize),
+		ndql.WithRawKeys(true), // WriteNode fixes keys unless --raw
+		ndql.WithContentCache(r.ContentCacheBytes, r.StreamThreshold),
+		ndql.WithMacros(r.Macros),
+		ndql.WithMaxErrors(r.MaxRowErrors()),
+	}
+	if !r.NoCache {
+		opts = append(opts, ndql.WithResultCache(r.ResultCacheDir()))
+	}
+	for _, p := range r.Params {
+		name, value, err := tree.ParseParam(p)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, ndql.WithParam(name, value))
+	}
+	if r.Functions != nil {
+		opts = append(opts, ndql.WithFunctionRegistry(r.Functions))
+	}
+	return opts, nil
 }
@@ -0,0 +1,369 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/berquerant/ndql"
+	"github.com/berquerant/ndql/pkg/iterx"
+	"github.com/berquerant/ndql/pkg/logx"
+	"github.com/berquerant/ndql/pkg/node"
+	"github.com/berquerant/ndql/pkg/schema"
+	"github.com/berquerant/ndql/pkg/tree"
+	"github.com/peterh/liner"
+)
+
+const (
+	replPrompt             = "ndql> "
+	replContinuationPrompt = " ...> "
+	replHelp               = `Enter a query terminated by ; to run it against the loaded rows.
+
+.help           show this message
+.explain [KEY]  describe ndql resources like ndql explain
+.schema [QUERY] describe the columns of the loaded rows, or of the result of the query
+.quit, .exit    exit, as well as Ctrl-D`
+)
+
+var replCommands = []string{".help", ".explain", ".schema", ".quit", ".exit"}
+
+// repl runs queries read interactively against the rows read once.
+func (r *runner) repl(ctx context.Context) error {
+	if err := r.SetupSources(); err != nil {
+		return err
+	}
+	if err := r.SetupQuery(); err != nil {
+		return err
+	}
+	it, err := r.Sources.ReadInput()
+	if err != nil {
+		return err
+	}
+	opts, err := r.queryOptions()
+	if err != nil {
+		return err
+	}
+
+	report, err := r.newRowErrorReport()
+	if err != nil {
+		return err
+	}
+	defer report.close()
+	// keep the macros and the variables defined by the queries for the following queries
+	variables := tree.NewVariables()
+	opts = append(opts,
+		ndql.WithRowErrorHandler(report.add),
+		ndql.WithDefineMacros(true),
+		ndql.WithVariables(variables),
+	)
+
+	rows := iterx.NewClonableIter(it)
+	defer rows.Close()
+	s := &replSession{
+		runner:    r,
+		rows:      rows,
+		opts:      opts,
+		schema:    schema.New(schema.DefaultSamples),
+		report:    report,
+		variables: variables,
+	}
+	if err := s.load(ctx); err != nil {
+		return err
+	}
+	return s.loop(ctx)
+}
+
+type replSession struct {
+	*runner
+	rows   *iterx.ClonableIter[*node.Node]
+	opts   []ndql.Option
+	schema *schema.Schema
+	report *rowErrorReport
+	// variables set by the queries, the macros defined by the queries are in Macros
+	variables *tree.Variables
+	// columns seen in the results
+	columns map[string]bool
+}
+
+// load reads all the rows to cache them.
+func (s *replSession) load(ctx context.Context) error {
+	start := time.Now()
+	var count int
+	for n := range s.rows.Clone().Values() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		count++
+		s.schema.Add(n)
+	}
+	_, _ = fmt.Fprintf(s.Stderr, "%d rows loaded in %s, type .help for help\n", count, time.Since(start))
+	return nil
+}
+
+func (s *replSession) loop(ctx context.Context) error {
+	line := liner.NewLiner()
+	defer line.Close()
+	line.SetCtrlCAborts(true)
+	line.SetMultiLineMode(true)
+	line.SetWordCompleter(s.complete)
+	s.readHistory(line)
+	defer s.writeHistory(line)
+
+	var buf strings.Builder
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		prompt := replPrompt
+		if buf.Len() > 0 {
+			prompt = replContinuationPrompt
+		}
+		text, err := line.Prompt(prompt)
+		switch {
+		case errors.Is(err, liner.ErrPromptAborted):
+			buf.Reset()
+			continue
+		case errors.Is(err, io.EOF):
+			_, _ = fmt.Fprintln(s.Stderr)
+			return nil
+		case err != nil:
+			return err
+		}
+
+		trimmed := strings.TrimSpace(text)
+		if buf.Len() == 0 {
+			if trimmed == "" {
+				continue
+			}
+			if strings.HasPrefix(trimmed, ".") {
+				line.AppendHistory(trimmed)
+				if quit := s.command(ctx, trimmed); quit {
+					return nil
+				}
+				continue
+			}
+		}
+
+		buf.WriteString(text)
+		buf.WriteString("\n")
+		query := strings.TrimSpace(buf.String())
+		if !strings.HasSuffix(query, ";") {
+			continue
+		}
+		buf.Reset()
+		line.AppendHistory(strings.Join(strings.Fields(query), " "))
+		s.query(ctx, query)
+	}
+}
+
+// query runs the query, Ctrl-C cancels only the query.
+func (s *replSession) query(ctx context.Context, query string) {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	start := time.Now()
+	var count int
+	for n, err := range ndql.Query(ctx, query, ndql.FromNodes(s.rows.Clone().Values()), s.opts...) {
+		if err != nil {
+			_, _ = fmt.Fprintf(s.Stderr, "Error: %v\n", err)
+			break
+		}
+		count++
+		s.addColumns(n)
+		s.WriteNode(n)
+	}
+	s.report.writeSummary(s.Stderr)
+	_, _ = fmt.Fprintf(s.Stderr, "%d rows in %s\n", count, time.Since(start))
+}
+
+func (s *replSession) addColumns(n *node.Node) {
+	if s.columns == nil {
+		s.columns = map[string]bool{}
+	}
+	for k := range n.Unwrap() {
+		s.columns[tree.KeyFromString(k).Name()] = true
+	}
+}
+
+// command runs the meta command, returns true to quit.
+func (s *replSession) command(ctx context.Context, text string) bool {
+	name, arg, _ := strings.Cut(text, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case ".quit", ".exit":
+		return true
+	case ".help":
+		_, _ = fmt.Fprintln(s.Stderr, replHelp)
+	case ".explain":
+		s.explain(arg)
+	case ".schema":
+		s.writeSchema(ctx, arg)
+	default:
+		_, _ = fmt.Fprintf(s.Stderr, "Error: unknown command %s, type .help for help\n", name)
+	}
+	return false
+}
+
+func (s *replSession) writeSchema(ctx context.Context, query string) {
+	if query == "" {
+		if err := s.schema.WriteTable(s.Stdout); err != nil {
+			_, _ = fmt.Fprintf(s.Stderr, "Error: %v\n", err)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	x := schema.New(schema.DefaultSamples)
+	// the same keys as the output of query
+	opts := append(slices.Clone(s.opts), ndql.WithRawKeys(s.RawOutput))
+	for n, err := range ndql.Query(ctx, query, ndql.FromNodes(s.rows.Clone().Values()), opts...) {
+		if err != nil {
+			_, _ = fmt.Fprintf(s.Stderr, "Error: %v\n", err)
+			return
+		}
+		x.Add(n)
+	}
+	s.report.writeSummary(s.Stderr)
+	if err := x.WriteTable(s.Stdout); err != nil {
+		_, _ = fmt.Fprintf(s.Stderr, "Error: %v\n", err)
+	}
+}
+
+func (s *replSession) explain(key string) {
+	if s.Documents == nil {
+		_, _ = fmt.Fprintln(s.Stderr, "Error: no documents")
+		return
+	}
+	showKeys := func() {
+		keys := s.Documents.Keys()
+		xs := make([]string, len(keys))
+		for i, k := range keys {
+			xs[i] = "- " + k
+		}
+		_, _ = fmt.Fprintf(s.Stdout, "Available keys:\n%s\n", strings.Join(xs, "\n"))
+	}
+	if key == "" {
+		showKeys()
+		return
+	}
+	d, ok := s.Documents.Get(key)
+	if !ok {
+		showKeys()
+		_, _ = fmt.Fprintf(s.Stderr, "Error: %s is not found\n", key)
+		return
+	}
+	_, _ = fmt.Fprintln(s.Stdout, d)
+}
+
+func (s *replSession) readHistory(line *liner.State) {
+	f, err := os.Open(s.HistoryFile())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logx.Error(err, "Failed to open history")
+		}
+		return
+	}
+	defer f.Close()
+	if _, err := line.ReadHistory(f); err != nil {
+		logx.Error(err, "Failed to read history")
+	}
+}
+
+func (s *replSession) writeHistory(line *liner.State) {
+	path := s.HistoryFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		logx.Error(err, "Failed to create history directory")
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		logx.Error(err, "Failed to create history")
+		return
+	}
+	defer f.Close()
+	if _, err := line.WriteHistory(f); err != nil {
+		logx.Error(err, "Failed to write history")
+		return
+	}
+	slog.Debug("Write history", slog.String("path", path))
+}
+
+// complete completes the word before the cursor.
+func (s *replSession) complete(line string, pos int) (string, []string, string) {
+	head, tail := line[:pos], line[pos:]
+	i := len(head)
+	for i > 0 && isReplWordByte(head[i-1]) {
+		i--
+	}
+	head, word := head[:i], head[i:]
+	if word == "" {
+		return head, nil, tail
+	}
+
+	var candidates []string
+	if strings.HasPrefix(word, ".") && strings.TrimSpace(head) == "" {
+		candidates = replCommands
+	} else {
+		candidates = s.words()
+	}
+	lower := strings.ToLower(word)
+	var r []string
+	for _, c := range candidates {
+		if strings.HasPrefix(strings.ToLower(c), lower) && c != word {
+			r = append(r, c)
+		}
+	}
+	return head, r, tail
+}
+
+// words returns the candidates of the completion.
+func (s *replSession) words() []string {
+	set := map[string]bool{}
+	for _, x := range replKeywords {
+		set[x] = true
+	}
+	if s.Functions != nil {
+		for _, x := range s.Functions.Names() {
+			set[x] = true
+		}
+	}
+	if s.Macros != nil {
+		for _, m := range s.Macros.Macros() {
+			set[m.Name] = true
+		}
+	}
+	if s.variables != nil {
+		for _, x := range s.variables.Names() {
+			set["@"+x] = true
+		}
+	}
+	for _, x := range s.schema.Keys() {
+		set[x] = true
+	}
+	for x := range s.columns {
+		set[x] = true
+	}
+	return slices.Sorted(maps.Keys(set))
+}
+
+func isReplWordByte(b byte) bool {
+	return b == '_' || b == '.' || b == '@' || b == ':' ||
+		'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9'
+}
+
+var replKeywords = []string{
+	"select", "from", "where", "as", "and", "or", "not", "is", "null",
+	"in", "like", "between", "case", "when", "then", "else", "end",
+	"true", "false", "distinct", "order", "by", "asc", "desc", "limit",
+	"set", "define", "returns",
+}
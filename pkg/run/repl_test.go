@@ -0,0 +1,287 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/berquerant/ndql/pkg/config"
+	"github.com/berquerant/ndql/pkg/gopkg"
+	"github.com/berquerant/ndql/pkg/node"
+	"github.com/berquerant/ndql/pkg/parse"
+	"github.com/berquerant/ndql/pkg/schema"
+	"github.com/berquerant/ndql/pkg/tree"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestReplSession(t *testing.T) (*replSession, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+	macros := parse.NewMacroSet()
+	if !assert.Nil(t, macros.Load("rc.sql", `define is_go(p) as extension(p) = ".go"`)) {
+		t.FailNow()
+	}
+	functions := tree.NewFunctionRegistry()
+	if !assert.Nil(t, functions.RegisterScalar(tree.ScalarFunction{
+		Name:    "twice",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Call: func(xs ...node.Data) (node.Data, error) {
+			return xs[0], nil
+		},
+	})) {
+		t.FailNow()
+	}
+	variables := tree.NewVariables()
+	variables.Set("ext", node.String(".go"))
+	variables.SetParam("size", node.Int(10))
+	rows := schema.New(schema.DefaultSamples)
+	rows.Add(node.FromMap(map[string]node.Data{
+		"path": node.String("a.go"),
+		"size": node.Int(1),
+	}))
+
+	var stdout, stderr bytes.Buffer
+	s := &replSession{
+		runner: &runner{&config.Config{
+			Stdout:    &stdout,
+			Stderr:    &stderr,
+			Functions: functions,
+			Macros:    macros,
+			Documents: gopkg.NewDocumentSet(&gopkg.Document{
+				Path:  "data",
+				Title: "Data",
+				Text:  "the types of the values",
+			}),
+		}},
+		schema:    rows,
+		report:    &rowErrorReport{counts: map[string]int{}},
+		variables: variables,
+		columns: map[string]bool{
+			"size2": true,
+		},
+	}
+	return s, &stdout, &stderr
+}
+
+func TestReplWords(t *testing.T) {
+	s, _, _ := newTestReplSession(t)
+	got := s.words()
+	for _, tc := range []struct {
+		title string
+		word  string
+	}{
+		{
+			title: "keyword",
+			word:  "select",
+		},
+		{
+			title: "builtin function",
+			word:  "extension",
+		},
+		{
+			title: "registered function",
+			word:  "twice",
+		},
+		{
+			title: "macro",
+			word:  "is_go",
+		},
+		{
+			title: "variable",
+			word:  "@ext",
+		},
+		{
+			title: "parameter",
+			word:  "@size",
+		},
+		{
+			title: "loaded column",
+			word:  "path",
+		},
+		{
+			title: "result column",
+			word:  "size2",
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			assert.Contains(t, got, tc.word)
+		})
+	}
+	assert.IsNonDecreasing(t, got, "sorted")
+}
+
+func TestReplComplete(t *testing.T) {
+	s, _, _ := newTestReplSession(t)
+	for _, tc := range []struct {
+		title string
+		line  string
+		pos   int
+		head  string
+		want  []string
+		tail  string
+	}{
+		{
+			title: "empty",
+			line:  "",
+			pos:   0,
+		},
+		{
+			title: "after space",
+			line:  "select ",
+			pos:   7,
+			head:  "select ",
+		},
+		{
+			title: "command",
+			line:  ".ex",
+			pos:   3,
+			want:  []string{".explain", ".exit"},
+		},
+		{
+			title: "command after spaces",
+			line:  "  .sc",
+			pos:   5,
+			head:  "  ",
+			want:  []string{".schema"},
+		},
+		{
+			title: "not a command in query",
+			line:  "select .ex",
+			pos:   10,
+			head:  "select ",
+		},
+		{
+			title: "case-insensitive",
+			line:  "SEL",
+			pos:   3,
+			want:  []string{"select"},
+		},
+		{
+			title: "complete word is not a candidate",
+			line:  "select is_go",
+			pos:   12,
+			head:  "select ",
+		},
+		{
+			title: "variable",
+			line:  "select @e",
+			pos:   9,
+			head:  "select ",
+			want:  []string{"@ext"},
+		},
+		{
+			title: "keep the tail",
+			line:  "select size2 wh size",
+			pos:   15,
+			head:  "select size2 ",
+			want:  []string{"when", "where"},
+			tail:  " size",
+		},
+		{
+			title: "word in the middle",
+			line:  "select twi(size)",
+			pos:   10,
+			head:  "select ",
+			want:  []string{"twice"},
+			tail:  "(size)",
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			head, got, tail := s.complete(tc.line, tc.pos)
+			assert.Equal(t, tc.head, head, "head")
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.tail, tail, "tail")
+		})
+	}
+}
+
+func TestIsReplWordByte(t *testing.T) {
+	for _, tc := range []struct {
+		b    byte
+		want bool
+	}{
+		{b: 'a', want: true},
+		{b: 'Z', want: true},
+		{b: '0', want: true},
+		{b: '_', want: true},
+		{b: '.', want: true},
+		{b: '@', want: true},
+		{b: ':', want: true},
+		{b: ' ', want: false},
+		{b: '(', want: false},
+		{b: ',', want: false},
+		{b: '`', want: false},
+		{b: '-', want: false},
+	} {
+		t.Run(string(tc.b), func(t *testing.T) {
+			assert.Equal(t, tc.want, isReplWordByte(tc.b))
+		})
+	}
+}
+
+func TestReplCommand(t *testing.T) {
+	for _, tc := range []struct {
+		title  string
+		text   string
+		quit   bool
+		stdout string
+		stderr string
+	}{
+		{
+			title: "quit",
+			text:  ".quit",
+			quit:  true,
+		},
+		{
+			title: "exit",
+			text:  ".exit",
+			quit:  true,
+		},
+		{
+			title:  "help",
+			text:   ".help",
+			stderr: replHelp,
+		},
+		{
+			title:  "explain keys",
+			text:   ".explain",
+			stdout: "Available keys:\n- data",
+		},
+		{
+			title:  "explain",
+			text:   ".explain   data ",
+			stdout: "the types of the values",
+		},
+		{
+			title:  "explain unknown",
+			text:   ".explain nothing",
+			stdout: "Available keys:\n- data",
+			stderr: "Error: nothing is not found",
+		},
+		{
+			title:  "schema",
+			text:   ".schema",
+			stdout: "KEY",
+		},
+		{
+			title:  "unknown",
+			text:   ".select",
+			stderr: "Error: unknown command .select",
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			s, stdout, stderr := newTestReplSession(t)
+			assert.Equal(t, tc.quit, s.command(context.TODO(), tc.text))
+			if tc.stdout == "" {
+				assert.Empty(t, stdout.String(), "stdout")
+			} else {
+				assert.Contains(t, stdout.String(), tc.stdout, "stdout")
+			}
+			if tc.stderr == "" {
+				assert.Empty(t, stderr.String(), "stderr")
+			} else {
+				assert.Contains(t, stderr.String(), tc.stderr, "stderr")
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/berquerant/ndql/pkg/logx"
+	"github.com/berquerant/ndql/pkg/tree"
+)
+
+// rowErrorReport writes the row errors to --errors-to and counts them by class.
+type rowErrorReport struct {
+	file   *os.File // nil if --errors-to is not set
+	enc    *json.Encoder
+	counts map[string]int
+}
+
+func (r *runner) newRowErrorReport() (*rowErrorReport, error) {
+	x := &rowErrorReport{
+		counts: map[string]int{},
+	}
+	if r.ErrorsTo == "" {
+		return x, nil
+	}
+	f, err := os.Create(r.ErrorsTo)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open errors_to", err)
+	}
+	x.file = f
+	x.enc = json.NewEncoder(f)
+	return x, nil
+}
+
+// add is the handler of the row errors.
+func (x *rowErrorReport) add(err *tree.RowError) {
+	x.counts[err.Class()]++
+	if x.enc == nil {
+		return
+	}
+	if err := x.enc.Encode(err); err != nil {
+		logx.Error(err, "Failed to write row error")
+	}
+}
+
+// writeSummary writes the counts of the errors by class if any, and resets them.
+func (x *rowErrorReport) writeSummary(w io.Writer) {
+	if len(x.counts) == 0 {
+		return
+	}
+	var (
+		total int
+		xs    []string
+	)
+	for _, k := range slices.Sorted(maps.Keys(x.counts)) {
+		total += x.counts[k]
+		xs = append(xs, fmt.Sprintf("%s: %d", k, x.counts[k]))
+	}
+	_, _ = fmt.Fprintf(w, "Row errors: %d (%s)\n", total, strings.Join(xs, ", "))
+	clear(x.counts)
+}
+
+func (x *rowErrorReport) close() {
+	if x.file != nil {
+		_ = x.file.Close()
+	}
+}
@@ -27,6 +27,12 @@ func Main(ctx context.Context, c *config.Config) error {
 		return r.dryrun()
 	case config.ModeList:
 		return r.list()
+	case config.ModeCachePrune:
+		return r.cachePrune()
+	case config.ModeRepl:
+		return r.repl(ctx)
+	case config.ModeSchema:
+		return r.schema(ctx)
 	}
 	return r.dryrun()
 }
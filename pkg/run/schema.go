@@ -0,0 +1,53 @@
+package run
+
+import (
+	"context"
+
+	"github.com/berquerant/ndql"
+	"github.com/berquerant/ndql/pkg/schema"
+)
+
+func (r *runner) schema(ctx context.Context) error {
+	if err := schema.CheckFormat(r.Format); err != nil {
+		return err
+	}
+	if err := r.SetupSources(); err != nil {
+		return err
+	}
+	if err := r.SetupQuery(); err != nil {
+		return err
+	}
+	it, err := r.Sources.ReadInput()
+	if err != nil {
+		return err
+	}
+
+	s := schema.New(schema.DefaultSamples)
+	if r.Query == "" {
+		for n := range it {
+			s.Add(n)
+		}
+		return s.Write(r.Stdout, r.Format)
+	}
+
+	opts, err := r.queryOptions()
+	if err != nil {
+		return err
+	}
+	report, err := r.newRowErrorReport()
+	if err != nil {
+		return err
+	}
+	defer report.close()
+	defer report.writeSummary(r.Stderr)
+	opts = append(opts, ndql.WithRowErrorHandler(report.add))
+	// the same keys as the output of query
+	opts = append(opts, ndql.WithRawKeys(r.RawOutput))
+	for n, err := range ndql.Query(ctx, r.Query, r.querySource(it), opts...) {
+		if err != nil {
+			return err
+		}
+		s.Add(n)
+	}
+	return s.Write(r.Stdout, r.Format)
+}
@@ -0,0 +1,221 @@
+// Package schema infers the columns of nodes.
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/berquerant/ndql/pkg/node"
+)
+
+var ErrUnknownFormat = errors.New("UnknownFormat")
+
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+)
+
+// DefaultSamples is the default number of the sample values of each column.
+const DefaultSamples = 3
+
+// Schema collects the keys and the values of nodes.
+//
+// Nodes do not need to have the same keys, a key missing from some nodes is reported with the missing rate.
+type Schema struct {
+	samples int
+	rows    int
+	columns map[string]*column
+}
+
+// New returns a new schema that keeps at most samples distinct values of each column.
+func New(samples int) *Schema {
+	return &Schema{
+		samples: samples,
+		columns: map[string]*column{},
+	}
+}
+
+// Add adds the keys and the values of the node.
+func (s *Schema) Add(n *node.Node) {
+	s.rows++
+	for k, v := range n.Unwrap() {
+		c, ok := s.columns[k]
+		if !ok {
+			c = &column{
+				types: map[string]int{},
+			}
+			s.columns[k] = c
+		}
+		c.add(v, s.samples)
+	}
+}
+
+// Rows returns the number of the added nodes.
+func (s *Schema) Rows() int { return s.rows }
+
+// Keys returns the keys of the columns, sorted.
+func (s *Schema) Keys() []string { return slices.Sorted(maps.Keys(s.columns)) }
+
+// Columns returns the columns sorted by key.
+func (s *Schema) Columns() []*Column {
+	keys := s.Keys()
+	r := make([]*Column, len(keys))
+	for i, k := range keys {
+		r[i] = s.columns[k].result(k, s.rows)
+	}
+	return r
+}
+
+// Column is the summary of the values of a key.
+type Column struct {
+	Key string `json:"key"`
+	// Types are the numbers of the values by the type names.
+	Types map[string]int `json:"types"`
+	// Count is the number of the nodes that have the key.
+	Count int `json:"count"`
+	// NullRate is the ratio of the nodes whose value is Null.
+	NullRate float64 `json:"null_rate"`
+	// MissingRate is the ratio of the nodes that do not have the key.
+	MissingRate float64 `json:"missing_rate"`
+	// Min and Max are nil if there are no values except Null.
+	// Values not comparable with the first value like String and Int are ignored.
+	Min     *node.Op   `json:"min"`
+	Max     *node.Op   `json:"max"`
+	Samples []*node.Op `json:"samples"`
+}
+
+// TypeNames returns the names of the observed types, sorted.
+func (c *Column) TypeNames() []string { return slices.Sorted(maps.Keys(c.Types)) }
+
+type column struct {
+	types   map[string]int
+	count   int
+	nulls   int
+	min     node.Data
+	max     node.Data
+	samples []node.Data
+}
+
+func (c *column) add(v node.Data, samples int) {
+	c.count++
+	c.types[node.TypeName(v)]++
+	op := v.AsOp()
+	if op.IsNull() {
+		c.nulls++
+		return
+	}
+
+	if c.min == nil {
+		c.min = v
+		c.max = v
+	} else {
+		if op.Compare(c.min.AsOp()) == node.CmpLess {
+			c.min = v
+		}
+		if op.Compare(c.max.AsOp()) == node.CmpGreater {
+			c.max = v
+		}
+	}
+
+	if len(c.samples) < samples && !slices.ContainsFunc(c.samples, func(x node.Data) bool {
+		return op.EqualType(x.AsOp()) && op.Compare(x.AsOp()) == node.CmpEqual
+	}) {
+		c.samples = append(c.samples, v)
+	}
+}
+
+func (c *column) result(key string, rows int) *Column {
+	r := &Column{
+		Key:     key,
+		Types:   maps.Clone(c.types),
+		Count:   c.count,
+		Samples: make([]*node.Op, len(c.samples)),
+	}
+	if rows > 0 {
+		r.NullRate = float64(c.nulls) / float64(rows)
+		r.MissingRate = float64(rows-c.count) / float64(rows)
+	}
+	if c.min != nil {
+		r.Min = c.min.AsOp()
+		r.Max = c.max.AsOp()
+	}
+	for i, x := range c.samples {
+		r.Samples[i] = x.AsOp()
+	}
+	return r
+}
+
+// CheckFormat returns an error if the format is not available for Write.
+func CheckFormat(format string) error {
+	switch format {
+	case FormatTable, FormatJSON, "":
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+}
+
+// Write writes the columns in the format, table or json.
+func (s *Schema) Write(w io.Writer, format string) error {
+	if err := CheckFormat(format); err != nil {
+		return err
+	}
+	if format == FormatJSON {
+		return s.WriteJSON(w)
+	}
+	return s.WriteTable(w)
+}
+
+// WriteJSON writes the columns as JSON, a column per line.
+func (s *Schema) WriteJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	for _, c := range s.Columns() {
+		if err := e.Encode(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTable writes the columns as a table.
+func (s *Schema) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "KEY\tTYPES\tNULL\tMISSING\tMIN\tMAX\tSAMPLES")
+	for _, c := range s.Columns() {
+		types := make([]string, len(c.Types))
+		for i, t := range c.TypeNames() {
+			types[i] = fmt.Sprintf("%s:%d", t, c.Types[t])
+		}
+		samples := make([]string, len(c.Samples))
+		for i, x := range c.Samples {
+			samples[i] = displayValue(x)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%.1f%%\t%.1f%%\t%s\t%s\t%s\n",
+			c.Key,
+			strings.Join(types, ","),
+			c.NullRate*100,
+			c.MissingRate*100,
+			displayValue(c.Min),
+			displayValue(c.Max),
+			strings.Join(samples, ", "),
+		)
+	}
+	return tw.Flush()
+}
+
+func displayValue(v *node.Op) string {
+	if v == nil {
+		return "-"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v.AsData().Display()
+	}
+	return string(b)
+}
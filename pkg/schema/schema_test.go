@@ -0,0 +1,107 @@
+package schema_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/berquerant/ndql/pkg/node"
+	"github.com/berquerant/ndql/pkg/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchema(t *testing.T) {
+	s := schema.New(2)
+	for _, x := range []map[string]node.Data{
+		{
+			"a": node.Int(3),
+			"b": node.String("x"),
+		},
+		{
+			"a": node.Float(1.5),
+			"b": node.NewNull(),
+		},
+		{
+			"a": node.Int(10),
+			"b": node.String("x"),
+			"c": node.Bool(true),
+		},
+		{
+			"a": node.String("ignored"),
+			"b": node.String("y"),
+		},
+	} {
+		s.Add(node.FromMap(x))
+	}
+
+	assert.Equal(t, 4, s.Rows())
+	assert.Equal(t, []string{"a", "b", "c"}, s.Keys())
+
+	t.Run("columns", func(t *testing.T) {
+		assert.Equal(t, []*schema.Column{
+			{
+				Key: "a",
+				Types: map[string]int{
+					"Float":  1,
+					"Int":    2,
+					"String": 1,
+				},
+				Count:   4,
+				Min:     node.Float(1.5).AsOp(),
+				Max:     node.Int(10).AsOp(),
+				Samples: []*node.Op{node.Int(3).AsOp(), node.Float(1.5).AsOp()},
+			},
+			{
+				Key: "b",
+				Types: map[string]int{
+					"Null":   1,
+					"String": 3,
+				},
+				Count:    4,
+				NullRate: 0.25,
+				Min:      node.String("x").AsOp(),
+				Max:      node.String("y").AsOp(),
+				Samples:  []*node.Op{node.String("x").AsOp(), node.String("y").AsOp()},
+			},
+			{
+				Key: "c",
+				Types: map[string]int{
+					"Bool": 1,
+				},
+				Count:       1,
+				MissingRate: 0.75,
+				Min:         node.Bool(true).AsOp(),
+				Max:         node.Bool(true).AsOp(),
+				Samples:     []*node.Op{node.Bool(true).AsOp()},
+			},
+		}, s.Columns())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var b bytes.Buffer
+		assert.Nil(t, s.Write(&b, schema.FormatJSON))
+		assert.Equal(t, `{"key":"a","types":{"Float":1,"Int":2,"String":1},"count":4,"null_rate":0,"missing_rate":0,"min":1.5,"max":10,"samples":[3,1.5]}
+{"key":"b","types":{"Null":1,"String":3},"count":4,"null_rate":0.25,"missing_rate":0,"min":"x","max":"y","samples":["x","y"]}
+{"key":"c","types":{"Bool":1},"count":1,"null_rate":0,"missing_rate":0.75,"min":true,"max":true,"samples":[true]}
+`, b.String())
+	})
+
+	t.Run("table", func(t *testing.T) {
+		var b bytes.Buffer
+		assert.Nil(t, s.Write(&b, schema.FormatTable))
+		assert.Equal(t, `KEY  TYPES                   NULL   MISSING  MIN   MAX   SAMPLES
+a    Float:1,Int:2,String:1  0.0%   0.0%     1.5   10    3, 1.5
+b    Null:1,String:3         25.0%  0.0%     "x"   "y"   "x", "y"
+c    Bool:1                  0.0%   75.0%    true  true  true
+`, b.String())
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		assert.ErrorIs(t, s.Write(&bytes.Buffer{}, "xml"), schema.ErrUnknownFormat)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		var b bytes.Buffer
+		assert.Nil(t, schema.New(1).Write(&b, schema.FormatJSON))
+		assert.Equal(t, "", b.String())
+	})
+}
@@ -1,17 +1,21 @@
 package tree
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"slices"
+	"strings"
 
 	"github.com/berquerant/ndql/pkg/errorx"
 	"github.com/berquerant/ndql/pkg/iterx"
+	"github.com/berquerant/ndql/pkg/node"
 	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/format"
 )
 
 var (
-	ErrIgnore               = errors.New("Ignore")
+	ErrIgnore               = iterx.ErrIgnore
 	ErrNotImplmented        = errors.New("NotImplemented")
 	ErrInvalidTree          = errors.New("InvalidTree")
 	ErrInvalidKey           = errors.New("InvalidKey")
@@ -23,11 +27,89 @@ var (
 )
 
 func (TreeVisitor) newErr(err error, n ast.Node, msg string, v ...any) error {
-	return fmt.Errorf("%w: %T, %s",
-		errorx.WithVerbose(err, n),
-		n,
-		fmt.Sprintf(msg, v...),
+	return &exprError{
+		err: fmt.Errorf("%w: %T, %s",
+			errorx.WithVerbose(err, n),
+			n,
+			fmt.Sprintf(msg, v...),
+		),
+		node: n,
+	}
+}
+
+// exprError is the error of the AST node.
+type exprError struct {
+	err  error
+	node ast.Node
+}
+
+func (e *exprError) Error() string { return e.err.Error() }
+func (e *exprError) Unwrap() error { return e.err }
+
+// ErrorExpr returns the SQL of the innermost AST node in the error, empty if not found.
+func ErrorExpr(err error) string {
+	var (
+		x     *exprError
+		inner *exprError
 	)
+	for errors.As(err, &x) {
+		inner = x
+		err = x.err
+	}
+	if inner == nil {
+		return ""
+	}
+	var b strings.Builder
+	// like the query written by the user, without the charsets of the strings
+	flags := format.RestoreStringDoubleQuotes | format.RestoreStringWithoutCharset | format.RestoreKeyWordLowercase | format.RestoreNameBackQuotes
+	if err := inner.node.Restore(format.NewRestoreCtx(flags, &b)); err != nil {
+		return fmt.Sprintf("%T", inner.node)
+	}
+	return b.String()
+}
+
+// errorClasses are the sentinel errors to classify the errors.
+var errorClasses = []error{
+	ErrNotImplmented,
+	ErrInvalidTree,
+	ErrInvalidKey,
+	ErrInvalidValue,
+	ErrInvalidArgument,
+	ErrInvalidFunctionArity,
+	ErrParseGenResult,
+	ErrGenTemplate,
+	node.ErrUnknownData,
+	node.ErrUnavailable,
+	node.ErrInvalidNode,
+	iterx.ErrInvalidCall,
+	context.DeadlineExceeded,
+	context.Canceled,
+}
+
+// ErrorClass returns the name of the innermost sentinel error in the error like InvalidValue,
+// Unknown if not found.
+func ErrorClass(err error) string {
+	class := "Unknown"
+	for err != nil {
+		for _, c := range errorClasses {
+			if err == c {
+				class = c.Error()
+			}
+		}
+		switch x := err.(type) {
+		case interface{ Unwrap() error }:
+			err = x.Unwrap()
+		case interface{ Unwrap() []error }:
+			if xs := x.Unwrap(); len(xs) > 0 {
+				err = xs[0]
+			} else {
+				err = nil
+			}
+		default:
+			err = nil
+		}
+	}
+	return class
 }
 
 func (v TreeVisitor) notImplemented(n ast.Node, msg string, a ...any) error {
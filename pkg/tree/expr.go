@@ -34,13 +34,23 @@ func (v TreeVisitor) VisitExpr(n ExprNode) (NFunction, error) {
 		return v.VisitUnaryOperationExpr(n)
 	case *ColumnNameExpr:
 		return v.VisitColumnNameExpr(n)
+	case *VariableExpr:
+		return v.VisitVariableExpr(n)
 	default:
 		return nil, v.notImplemented(n, "unknown ExprNode")
 	}
 }
 
 func (v TreeVisitor) VisitColumnNameExpr(n *ColumnNameExpr) (NFunction, error) {
-	return v.VisitColumnName(n.Name).NFunction(), nil
+	k := v.VisitColumnName(n.Name)
+	// missing columns are row errors, the rows are dropped unless the errors exceed the max
+	return iterx.NewMapFunction(func(x *N) (*N, error) {
+		r, ok := k.Get(x)
+		if !ok {
+			return nil, v.newErr(ErrInvalidKey, n, "column %s not found", k.Name())
+		}
+		return r, nil
+	}), nil
 }
 
 func (TreeVisitor) VisitColumnName(n *ColumnName) *Key {
@@ -3,6 +3,7 @@ package tree
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 
 	"github.com/berquerant/ndql/pkg/iox"
@@ -18,10 +19,20 @@ import (
 // @path syntax.functions
 // @document
 // - grep(pattern: String, template: String) -> []Node
+// - grep(pattern: String, template: String, option: String) -> []Node
 // - tmpl(template: String) -> []Node
 // - sh(script: String) -> []Node
+// - sh(script: String, option: String) -> []Node
+// - proc(command: String) -> []Node
+// - proc(command: String, option: String) -> []Node
 // - lua(script: String, entrypoint: String) -> []Node
+// - star(script: String, entrypoint: String) -> []Node
+// - wasm(module_path: String, function: String) -> []Node
 // - expr(expression: String) -> []Node
+// - expr_value(expression: String) -> Null | Bool | Int | Float | String | Time | Duration
+// - lua_value(script: String, entrypoint: String) -> Null | Bool | Int | Float | String | Time | Duration
+// - read_lines(path: String) -> []Node
+// - `lines`(path: String) -> []Node
 // - to_int(value) -> Int
 // - to_float(value) -> Float
 // - to_bool(value) -> Bool
@@ -99,10 +110,22 @@ import (
 // - extension(path: String) -> String
 // - abspath(path: String) -> String
 // - relpath(path: String, base: String) -> String
+// - line_count(path: String) -> Int
+// - file_hash(path: String) -> String
+// - file_hash(path: String, algorithm: String) -> String
+// - mime_type(path: String) -> String
+// - is_binary(path: String) -> Bool
+// - encoding(path: String) -> String
+// - first_line(path: String) -> String
+// - read_file(path: String) -> String
+// - read_file(path: String, max_bytes: Int) -> String
 // - inverse(value: Float | Int) -> Float
 // - inverse(value: String) -> String
 // - env(name: String) -> String
 // - envor(name: String, default: String) -> String
+//
+// Programs embedding ndql can add functions by FunctionRegistry,
+// their documents are listed under syntax.functions by explain.
 func (v TreeVisitor) VisitFuncCallExpr(n *FuncCallExpr) (NFunction, error) {
 	name := n.FnName.L
 	f, err := v.visitFuncCallExpr(n)
@@ -117,11 +140,19 @@ const (
 )
 
 const (
-	FuncGrep = "grep"
-	FuncTmpl = "tmpl"
-	FuncSh   = "sh"
-	FuncLua  = "lua"
-	FuncExpr = "expr"
+	FuncGrep      = "grep"
+	FuncTmpl      = "tmpl"
+	FuncSh        = "sh"
+	FuncProc      = "proc"
+	FuncLua       = "lua"
+	FuncStar      = "star"
+	FuncWasm      = "wasm"
+	FuncExpr      = "expr"
+	FuncExprValue = "expr_value"
+	FuncLuaValue  = "lua_value"
+	// FuncLines is an alias of FuncReadLines, callable only when quoted like `lines` because LINES is a reserved word.
+	FuncLines     = "lines"
+	FuncReadLines = "read_lines"
 
 	FuncToInt      = "to_int"
 	FuncToFloat    = "to_float"
@@ -201,175 +232,136 @@ const (
 	FuncAbsPath   = "abspath"
 	FuncRelPath   = "relpath"
 
+	FuncLineCount = "line_count"
+	FuncFileHash  = "file_hash"
+	FuncMimeType  = "mime_type"
+	FuncIsBinary  = "is_binary"
+	FuncEncoding  = "encoding"
+	FuncFirstLine = "first_line"
+	FuncReadFile  = "read_file"
+
 	FuncInverse = "inverse"
 	FuncEnvOr   = "envor"
 	FuncEnv     = "env"
 )
 
+func init() {
+	for name, b := range map[string]FunctionBuilder{
+		FuncExpr:          TreeVisitor.funcCallExpr,
+		FuncLua:           TreeVisitor.funcCallLua,
+		FuncStar:          TreeVisitor.funcCallStar,
+		FuncWasm:          TreeVisitor.funcCallWasm,
+		FuncExprValue:     TreeVisitor.funcCallExprValue,
+		FuncLuaValue:      TreeVisitor.funcCallLuaValue,
+		FuncGrep:          TreeVisitor.funcCallGrep,
+		FuncSh:            TreeVisitor.funcCallSh,
+		FuncProc:          TreeVisitor.funcCallProc,
+		FuncTmpl:          TreeVisitor.funcCallTmpl,
+		FuncReadLines:     TreeVisitor.funcCallLines,
+		FuncLines:         TreeVisitor.funcCallLines,
+		FuncToInt:         TreeVisitor.funcCallToInt,
+		FuncToFloat:       TreeVisitor.funcCallToFloat,
+		FuncToBool:        TreeVisitor.funcCallToBool,
+		FuncToString:      TreeVisitor.funcCallToString,
+		FuncToTime:        TreeVisitor.funcCallToTime,
+		FuncToDuration:    TreeVisitor.funcCallToDuration,
+		FuncLeast:         TreeVisitor.funcCallLeast,
+		FuncGreatest:      TreeVisitor.funcCallGreatest,
+		FuncCoalesce:      TreeVisitor.funcCallCoalesce,
+		FuncIf:            TreeVisitor.funcCallIf,
+		FuncIfNull:        TreeVisitor.funcCallIfNull,
+		FuncNullIf:        TreeVisitor.funcCallNullIf,
+		FuncInverse:       TreeVisitor.funcCallInverse,
+		FuncAbs:           TreeVisitor.funcCallAbs,
+		FuncSqrt:          TreeVisitor.funcCallSqrt,
+		FuncDegrees:       TreeVisitor.funcCallDegrees,
+		FuncRadians:       TreeVisitor.funcCallRadians,
+		FuncAcos:          TreeVisitor.funcCallAcos,
+		FuncAsin:          TreeVisitor.funcCallAsin,
+		FuncAtan:          TreeVisitor.funcCallAtan,
+		FuncCos:           TreeVisitor.funcCallCos,
+		FuncSin:           TreeVisitor.funcCallSin,
+		FuncTan:           TreeVisitor.funcCallTan,
+		FuncCot:           TreeVisitor.funcCallCot,
+		FuncLn:            TreeVisitor.funcCallLn,
+		FuncLog2:          TreeVisitor.funcCallLog2,
+		FuncLog10:         TreeVisitor.funcCallLog10,
+		FuncExp:           TreeVisitor.funcCallExp,
+		FuncCeil:          TreeVisitor.funcCallCeil,
+		FuncFloor:         TreeVisitor.funcCallFloor,
+		FuncRound:         TreeVisitor.funcCallRound,
+		FuncAtan2:         TreeVisitor.funcCallAtan2,
+		FuncPow:           TreeVisitor.funcCallPow,
+		FuncE:             TreeVisitor.funcCallE,
+		FuncPi:            TreeVisitor.funcCallPi,
+		FuncRand:          TreeVisitor.funcCallRand,
+		FuncLen:           TreeVisitor.funcCallLen,
+		FuncSize:          TreeVisitor.funcCallSize,
+		FuncRegexpCount:   TreeVisitor.funcCallRegexpCount,
+		FuncRegexpInstr:   TreeVisitor.funcCallRegexpInstr,
+		FuncRegexpSubstr:  TreeVisitor.funcCallRegexpSubstr,
+		FuncRegexpReplace: TreeVisitor.funcCallRegexpReplace,
+		FuncRegexpLike:    TreeVisitor.funcCallRegexpLike,
+		FuncFormat:        TreeVisitor.funcCallFormat,
+		FuncLower:         TreeVisitor.funcCallLower,
+		FuncUpper:         TreeVisitor.funcCallUpper,
+		FuncSha2:          TreeVisitor.funcCallSha2,
+		FuncConcatWs:      TreeVisitor.funcCallConcatWs,
+		FuncInstr:         TreeVisitor.funcCallInstr,
+		FuncInstrCount:    TreeVisitor.funcCallInstrCount,
+		FuncSubstr:        TreeVisitor.funcCallSubstr,
+		FuncSubstrIndex:   TreeVisitor.funcCallSubstrIndex,
+		FuncReplace:       TreeVisitor.funcCallReplace,
+		FuncTrim:          TreeVisitor.funcCallTrim,
+		FuncStrToTime:     TreeVisitor.funcCallStrToTime,
+		FuncTimeFormat:    TreeVisitor.funcCallTimeFormat,
+		FuncYear:          TreeVisitor.funcCallYear,
+		FuncMonth:         TreeVisitor.funcCallMonth,
+		FuncDay:           TreeVisitor.funcCallDay,
+		FuncHour:          TreeVisitor.funcCallHour,
+		FuncMinute:        TreeVisitor.funcCallMinute,
+		FuncSecond:        TreeVisitor.funcCallSecond,
+		FuncDayOfWeek:     TreeVisitor.funcCallDayOfWeek,
+		FuncDayOfYear:     TreeVisitor.funcCallDayOfYear,
+		FuncNewTime:       TreeVisitor.funcCallNewTime,
+		FuncSleep:         TreeVisitor.funcCallSleep,
+		FuncEnvOr:         TreeVisitor.funcCallEnvOr,
+		FuncEnv:           TreeVisitor.funcCallEnv,
+		FuncNow:           TreeVisitor.funcCallNow,
+		FuncDir:           TreeVisitor.funcCallDir,
+		FuncBasename:      TreeVisitor.funcCallBasename,
+		FuncExtension:     TreeVisitor.funcCallExtension,
+		FuncAbsPath:       TreeVisitor.funcCallAbsPath,
+		FuncRelPath:       TreeVisitor.funcCallRelPath,
+		FuncLineCount:     TreeVisitor.funcCallLineCount,
+		FuncFileHash:      TreeVisitor.funcCallFileHash,
+		FuncMimeType:      TreeVisitor.funcCallMimeType,
+		FuncIsBinary:      TreeVisitor.funcCallIsBinary,
+		FuncEncoding:      TreeVisitor.funcCallEncoding,
+		FuncFirstLine:     TreeVisitor.funcCallFirstLine,
+		FuncReadFile:      TreeVisitor.funcCallReadFile,
+	} {
+		if err := builtinFunctions.Register(name, "", b); err != nil {
+			panic(err)
+		}
+	}
+}
+
 func (v TreeVisitor) visitFuncCallExpr(n *FuncCallExpr) (NFunction, error) {
-	args := n.Args
-	switch n.FnName.L {
-	case FuncExpr:
-		return v.funcCallExpr(args)
-	case FuncLua:
-		return v.funcCallLua(args)
-	case FuncGrep:
-		return v.funcCallGrep(args)
-	case FuncSh:
-		return v.funcCallSh(args)
-	case FuncTmpl:
-		return v.funcCallTmpl(args)
-	case FuncToInt:
-		return v.funcCallToInt(args)
-	case FuncToFloat:
-		return v.funcCallToFloat(args)
-	case FuncToBool:
-		return v.funcCallToBool(args)
-	case FuncToString:
-		return v.funcCallToString(args)
-	case FuncToTime:
-		return v.funcCallToTime(args)
-	case FuncToDuration:
-		return v.funcCallToDuration(args)
-	case FuncLeast:
-		return v.funcCallLeast(args)
-	case FuncGreatest:
-		return v.funcCallGreatest(args)
-	case FuncCoalesce:
-		return v.funcCallCoalesce(args)
-	case FuncIf:
-		return v.funcCallIf(args)
-	case FuncIfNull:
-		return v.funcCallIfNull(args)
-	case FuncNullIf:
-		return v.funcCallNullIf(args)
-	case FuncInverse:
-		return v.funcCallInverse(args)
-	case FuncAbs:
-		return v.funcCallAbs(args)
-	case FuncSqrt:
-		return v.funcCallSqrt(args)
-	case FuncDegrees:
-		return v.funcCallDegrees(args)
-	case FuncRadians:
-		return v.funcCallRadians(args)
-	case FuncAcos:
-		return v.funcCallAcos(args)
-	case FuncAsin:
-		return v.funcCallAsin(args)
-	case FuncAtan:
-		return v.funcCallAtan(args)
-	case FuncCos:
-		return v.funcCallCos(args)
-	case FuncSin:
-		return v.funcCallSin(args)
-	case FuncTan:
-		return v.funcCallTan(args)
-	case FuncCot:
-		return v.funcCallCot(args)
-	case FuncLn:
-		return v.funcCallLn(args)
-	case FuncLog2:
-		return v.funcCallLog2(args)
-	case FuncLog10:
-		return v.funcCallLog10(args)
-	case FuncExp:
-		return v.funcCallExp(args)
-	case FuncCeil:
-		return v.funcCallCeil(args)
-	case FuncFloor:
-		return v.funcCallFloor(args)
-	case FuncRound:
-		return v.funcCallRound(args)
-	case FuncAtan2:
-		return v.funcCallAtan2(args)
-	case FuncPow:
-		return v.funcCallPow(args)
-	case FuncE:
-		return v.funcCallE(args)
-	case FuncPi:
-		return v.funcCallPi(args)
-	case FuncRand:
-		return v.funcCallRand(args)
-	case FuncLen:
-		return v.funcCallLen(args)
-	case FuncSize:
-		return v.funcCallSize(args)
-	case FuncRegexpCount:
-		return v.funcCallRegexpCount(args)
-	case FuncRegexpInstr:
-		return v.funcCallRegexpInstr(args)
-	case FuncRegexpSubstr:
-		return v.funcCallRegexpSubstr(args)
-	case FuncRegexpReplace:
-		return v.funcCallRegexpReplace(args)
-	case FuncRegexpLike:
-		return v.funcCallRegexpLike(args)
-	case FuncFormat:
-		return v.funcCallFormat(args)
-	case FuncLower:
-		return v.funcCallLower(args)
-	case FuncUpper:
-		return v.funcCallUpper(args)
-	case FuncSha2:
-		return v.funcCallSha2(args)
-	case FuncConcatWs:
-		return v.funcCallConcatWs(args)
-	case FuncInstr:
-		return v.funcCallInstr(args)
-	case FuncInstrCount:
-		return v.funcCallInstrCount(args)
-	case FuncSubstr:
-		return v.funcCallSubstr(args)
-	case FuncSubstrIndex:
-		return v.funcCallSubstrIndex(args)
-	case FuncReplace:
-		return v.funcCallReplace(args)
-	case FuncTrim:
-		return v.funcCallTrim(args)
-	case FuncStrToTime:
-		return v.funcCallStrToTime(args)
-	case FuncTimeFormat:
-		return v.funcCallTimeFormat(args)
-	case FuncYear:
-		return v.funcCallYear(args)
-	case FuncMonth:
-		return v.funcCallMonth(args)
-	case FuncDay:
-		return v.funcCallDay(args)
-	case FuncHour:
-		return v.funcCallHour(args)
-	case FuncMinute:
-		return v.funcCallMinute(args)
-	case FuncSecond:
-		return v.funcCallSecond(args)
-	case FuncDayOfWeek:
-		return v.funcCallDayOfWeek(args)
-	case FuncDayOfYear:
-		return v.funcCallDayOfYear(args)
-	case FuncNewTime:
-		return v.funcCallNewTime(args)
-	case FuncSleep:
-		return v.funcCallSleep(args)
-	case FuncEnvOr:
-		return v.funcCallEnvOr(args)
-	case FuncEnv:
-		return v.funcCallEnv(args)
-	case FuncNow:
-		return v.funcCallNow(args)
-	case FuncDir:
-		return v.funcCallDir(args)
-	case FuncBasename:
-		return v.funcCallBasename(args)
-	case FuncExtension:
-		return v.funcCallExtension(args)
-	case FuncAbsPath:
-		return v.funcCallAbsPath(args)
-	case FuncRelPath:
-		return v.funcCallRelPath(args)
-	default:
+	f, ok := functionRegistryFromContext(v.ctx).get(n.FnName.L)
+	if !ok {
 		return nil, ErrNotImplmented
 	}
+	v.call = n
+	return f.build(v, n.Args)
+}
+
+// callErr wraps the error with the function call being built if any.
+func (v TreeVisitor) callErr(err error, msg string, args ...any) error {
+	if v.call == nil {
+		return fmt.Errorf("%w: %s", err, fmt.Sprintf(msg, args...))
+	}
+	return v.newErr(err, v.call, msg, args...)
 }
 
 func (TreeVisitor) assertFuncCallArgLen(args []ExprNode, minLen, maxLen int) error {
@@ -519,11 +511,11 @@ func (v TreeVisitor) newGeneratorFunction(args []ExprNode, name string, minLen,
 			}
 			g, err := f(xs...)
 			if err != nil {
-				return nil, fmt.Errorf("%w: generator function failed to create template", err)
+				return nil, v.callErr(err, "generator function %s failed to create template", name)
 			}
 			rv, err := GenerateAndParse(v.ctx, x, g)
 			if err != nil {
-				return nil, fmt.Errorf("%w: generator function failed to generate", err)
+				return nil, v.callErr(err, "generator function %s failed to generate", name)
 			}
 			r := make([]*N, len(rv))
 			for i, d := range rv {
@@ -538,6 +530,43 @@ func (v TreeVisitor) newGeneratorFunction(args []ExprNode, name string, minLen,
 	}
 }
 
+// newNodeArgUnaryRetFunction returns a function that calls f with the current node and the evaluated arguments.
+func (v TreeVisitor) newNodeArgUnaryRetFunction(args []ExprNode, name string, minLen, maxLen int, f func(*N, ...ND) (ND, error)) (NFunction, error) {
+	if err := v.assertFuncCallArgLen(args, minLen, maxLen); err != nil {
+		return nil, err
+	}
+	a, err := v.evalFuncCallArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	for i, b := range a {
+		if b.RetArity() == iterx.Variadic {
+			return nil, fmt.Errorf("%w: %s arg[%d] should not be variadic", ErrInvalidArgument, name, i)
+		}
+	}
+	return iterx.NewMapFunction(func(x *N) (*N, error) {
+		xs := make([]ND, len(a))
+		for i, b := range a {
+			c, err := b.CallAny(x)
+			if err != nil {
+				return nil, fmt.Errorf("%w: function call arg[%d]", err, i)
+			}
+			_, d, ok := AsValueContainer(c[0]).GetFirstValue()
+			if !ok {
+				return nil, fmt.Errorf("%w: function call arg[%d] contain no value", ErrInvalidValue, i)
+			}
+			xs[i] = d
+		}
+		rd, err := f(x, xs...)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s failed", err, name)
+		}
+		r := AsValueContainer(node.New())
+		r.SetContainerValue(rd)
+		return r.N, nil
+	}), nil
+}
+
 // @file: read the file, otherwise use the string as it is.
 func (v TreeVisitor) readFileOrString(d ND) ([]byte, error) {
 	s, ok := d.AsOp().String()
@@ -566,17 +595,36 @@ func (v TreeVisitor) readFileOrString(d ND) ([]byte, error) {
 // @path syntax.functions.expr
 // @document
 // This is one of the available generators.
-// It generates nodes using [CEL](https://cel.dev/overview/cel-overview).
+// It generates nodes using [expr](https://expr-lang.org/docs/language-definition).
 //
 // The following variables are predefined:
 //
 // - e: Environment variables, equivalent to [os.Environ](https://pkg.go.dev/os#Environ).
-// - n: The current node.
+// - n: The current node. Attributes of other tables like `a.k` are available as `n.a.k`.
+//
+// The following ndql functions are also available:
+// dir, basename, extension, abspath, relpath,
+// strtotime, timeformat, year, month, day, hour, minute, second, dayofweek, dayofyear, newtime,
+// regexp_like, regexp_count, regexp_instr, regexp_substr and regexp_replace.
+//
+// The result is converted into nodes as follows:
+//
+// - String: Parsed like the output of `sh`.
+// - Map: A node, keeping the types of the values. Nested maps become attributes of other tables.
+// - List of maps: Nodes.
+// - nil: No nodes.
+// - Other values: A node with the value in the expr attribute.
 //
 // For example, the following expression determines if the size attribute is less than 1000 and stores the result in the small attribute:
 //
 // ```
-// expr("\"small=\" + string(n.size < 1000)")
+// expr("{\"small\": n.size < 1000}")
+// ```
+//
+// The following expression stores the extension and the modified date:
+//
+// ```
+// expr("{\"ext\": extension(n.path), \"date\": timeformat(n.mod_time, \"2006-01-02\")}")
 // ```
 //
 // If `@file` is specified as expression, the contents of the file will be used.
@@ -590,6 +638,31 @@ func (v TreeVisitor) funcCallExpr(args []ExprNode) (NFunction, error) {
 	})
 }
 
+// @title expr_value(expression: String) -> Null | Bool | Int | Float | String | Time | Duration
+// @path syntax.functions.expr_value
+// @document
+// It evaluates the expression like `expr` and returns the result as a single value,
+// so it can be used anywhere a value is accepted, like WHERE and SELECT.
+//
+// The result should be nil, Bool, Int, Float, String, Time or Duration; nil becomes Null.
+//
+// For example, the following query selects large log files:
+//
+// ```
+// select path where expr_value('n.size > 1e6 && n.path endsWith ".log"')
+// ```
+//
+// If `@file` is specified as expression, the contents of the file will be used.
+func (v TreeVisitor) funcCallExprValue(args []ExprNode) (NFunction, error) {
+	return v.newNodeArgUnaryRetFunction(args, FuncExprValue, 1, 1, func(n *N, x ...ND) (ND, error) {
+		b, err := v.readFileOrString(x[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read expression", err)
+		}
+		return NewExprGenTemplate(string(b)).Value(v.ctx, n)
+	})
+}
+
 // @title lua(script: String, entrypoint: String) -> []Node
 // @path syntax.functions.lua
 // @document
@@ -597,16 +670,37 @@ func (v TreeVisitor) funcCallExpr(args []ExprNode) (NFunction, error) {
 // It generates nodes by executing Lua scripts.
 //
 // The entrypoint must specify a function predefined within the script
-// This function must accept exactly one argument and return a string.
+// This function must accept exactly one argument and return a string, a table or a list of tables.
 // The first argument is the current node, passed as a Lua table.
+// Attributes of other tables like `a.k` are passed as nested tables like `n.a.k`.
+// Time and Duration are passed as userdata, which can be converted into strings by `tostring` and compared with each other.
+// Time has the methods `unix`, `unix_milli`, `format(layout)`, `year`, `month`, `day`, `hour`, `minute`, `second`, `add(duration)` and `sub(time)`.
+// Duration has the methods `seconds`, `milliseconds` and `nanoseconds`.
+//
+// A string return value is parsed like the output of `sh`.
+// A table is converted into a node, keeping the types of the values; nested tables become attributes of other tables.
+// A list of tables is converted into nodes, and nil into no nodes.
+// Integral numbers become Int, other numbers Float.
+//
 // A global table `E` is predefined, containing environment variables equivalent to [os.Environ](https://pkg.go.dev/os#Environ).
+// A global table `N` is predefined, and `N.get(key, default)` returns the attribute of the current node like `key` or `table.key`, or default if not found.
 //
 // For example, the following expression calculates the logarithm of the size attribute and stores the result in the lsize attribute:
 //
 // ```
-// lua("function f(n) return \"lsize=\" .. tostring(math.log(n.size, 10)) end", "f")
+// lua("function f(n) return {lsize = math.log(n.size, 10)} end", "f")
 // ```
 //
+// The following expression generates a row with the age of the file:
+//
+// ```
+// lua("function f(n) return {age = os.time() - N.get(\"mod_time\"):unix()} end", "f")
+// ```
+//
+// The script is executed once per Lua state, and the states are reused across rows, up to `--concurrency` states per script.
+// Global variables, including fields of tables reachable from them, are restored after each call,
+// but local variables captured by functions are not, so do not keep state in them.
+//
 // If `@file` is specified as script, the contents of the file will be used.
 func (v TreeVisitor) funcCallLua(args []ExprNode) (NFunction, error) {
 	return v.newGeneratorFunction(args, FuncLua, 2, 2, func(x ...ND) (GenTemplate, error) {
@@ -622,7 +716,117 @@ func (v TreeVisitor) funcCallLua(args []ExprNode) (NFunction, error) {
 	})
 }
 
-// @title grep(pattern: String, template: String) -> []Node
+// @title star(script: String, entrypoint: String) -> []Node
+// @path syntax.functions.star
+// @document
+// This is one of the available generators.
+// It generates nodes by executing [Starlark](https://github.com/bazelbuild/starlark) scripts.
+//
+// Unlike `sh` and `lua`, scripts are sandboxed: they cannot do any I/O, `load` is disabled,
+// and only the `math` and `json` modules are predeclared.
+// Each call runs on fresh globals, so rows do not affect each other.
+//
+// The entrypoint must specify a function predefined within the script
+// This function must accept exactly one argument and return a string, a dict or a list of dicts.
+// The first argument is the current node, passed as a dict.
+// Attributes of other tables like `a.k` are passed as nested dicts like `n["a"]["k"]`.
+// Time and Duration are passed as the values of the Starlark time module, which have attributes like `unix` and `year`.
+//
+// A string return value is parsed like the output of `sh`.
+// A dict is converted into a node, keeping the types of the values; nested dicts become attributes of other tables.
+// A list or tuple of dicts is converted into nodes, and None into no nodes.
+//
+// For example, the following expression calculates the logarithm of the size attribute and stores the result in the lsize attribute:
+//
+// ```
+// star("def f(n): return {'lsize': math.log(n['size'], 10)}", "f")
+// ```
+//
+// If `@file` is specified as script, the contents of the file will be used.
+func (v TreeVisitor) funcCallStar(args []ExprNode) (NFunction, error) {
+	return v.newGeneratorFunction(args, FuncStar, 2, 2, func(x ...ND) (GenTemplate, error) {
+		b, err := v.readFileOrString(x[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to create star template", err)
+		}
+		e, ok := x[1].AsOp().String()
+		if !ok {
+			return nil, fmt.Errorf("%w: star template requires entrypoint", ErrInvalidArgument)
+		}
+		return NewStarGenTemplate(string(b), e.Raw()), nil
+	})
+}
+
+// @title wasm(module_path: String, function: String) -> []Node
+// @path syntax.functions.wasm
+// @document
+// This is one of the available generators.
+// It generates nodes by calling a function of a [WASI](https://wasi.dev/) module.
+//
+// The module is compiled once per path by a pure-Go runtime, and instantiated for each row,
+// so plugins written in languages like Rust and Zig can run without spawning processes.
+//
+// The function must be exported by the module and take no arguments.
+// `_start` can be specified to run the main function of a command module.
+// If the module exports `_initialize`, it is called before the function.
+//
+// The current node is passed as JSON via stdin, like `{"path":"a.txt","size":10}`.
+// The output to stdout is parsed like the output of `sh`, and stderr is forwarded to the stderr of ndql.
+// The function fails if the module exits with a non-zero code.
+//
+// The filesystem visible to the module is read-only and contains only the path of the node, at the same path.
+// Relative paths are relative to the root of the module.
+//
+// For example, the following expression calls `extract` of `meta.wasm`:
+//
+// ```
+// wasm("meta.wasm", "extract")
+// ```
+func (v TreeVisitor) funcCallWasm(args []ExprNode) (NFunction, error) {
+	return v.newGeneratorFunction(args, FuncWasm, 2, 2, func(x ...ND) (GenTemplate, error) {
+		p, ok := x[0].AsOp().String()
+		if !ok {
+			return nil, fmt.Errorf("%w: wasm template requires module path", ErrInvalidArgument)
+		}
+		f, ok := x[1].AsOp().String()
+		if !ok {
+			return nil, fmt.Errorf("%w: wasm template requires function", ErrInvalidArgument)
+		}
+		return NewWasmGenTemplate(p.Raw(), f.Raw()), nil
+	})
+}
+
+// @title lua_value(script: String, entrypoint: String) -> Null | Bool | Int | Float | String | Time | Duration
+// @path syntax.functions.lua_value
+// @document
+// It calls the entrypoint like `lua` and returns the result as a single value,
+// so it can be used anywhere a value is accepted, like WHERE and SELECT.
+//
+// The result should be nil, boolean, number, string, Time or Duration; nil becomes Null.
+// Integral numbers become Int, other numbers Float.
+//
+// For example, the following query selects files modified within a day:
+//
+// ```
+// select path where lua_value('function f(n) return os.time() - n.mod_time:unix() < 86400 end', 'f')
+// ```
+//
+// If `@file` is specified as script, the contents of the file will be used.
+func (v TreeVisitor) funcCallLuaValue(args []ExprNode) (NFunction, error) {
+	return v.newNodeArgUnaryRetFunction(args, FuncLuaValue, 2, 2, func(n *N, x ...ND) (ND, error) {
+		b, err := v.readFileOrString(x[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read script", err)
+		}
+		e, ok := x[1].AsOp().String()
+		if !ok {
+			return nil, fmt.Errorf("%w: lua_value requires entrypoint", ErrInvalidArgument)
+		}
+		return NewLuaGenTemplate(string(b), e.Raw()).Value(v.ctx, n)
+	})
+}
+
+// @title grep(pattern: String, template: String) -> []Node, grep(pattern: String, template: String, option: String) -> []Node
 // @path syntax.functions.grep
 // @document
 // This is one of the available generators.
@@ -633,8 +837,35 @@ func (v TreeVisitor) funcCallLua(args []ExprNode) (NFunction, error) {
 // ```
 // grep("func (?P<name>[^(]+)", "func=$name")
 // ```
+//
+// The following variables are also available in the template unless the pattern has the capturing group of the same name:
+//
+// - $line: The line number of the match, starting from 1.
+// - $column: The column (in bytes) of the match, starting from 1.
+// - $offset: The byte offset of the match, starting from 0.
+//
+// ```
+// grep("func (?P<name>[^(]+)", "func=$name,line=$line")
+// ```
+//
+// option is a comma-separated list of the following:
+//
+// - i, m, s, U: [Flags](https://pkg.go.dev/regexp/syntax) of the pattern, can be combined like `im`.
+// - max=N: Stop after N matches.
+// - B=N: Store N lines before the match in the grep_before attribute.
+// - A=N: Store N lines after the match in the grep_after attribute.
+// - C=N: Equivalent to `B=N,A=N`.
+//
+// ```
+// grep("todo", "todo=$line", "i,C=1")
+// ```
+//
+// Files larger than `--stream_threshold` bytes are not loaded into memory but grepped line by line,
+// and the lines longer than 1 MiB are grepped by the chunks of 1 MiB,
+// so the pattern cannot match across the lines or the chunks in such files.
+// `^`, `$` and the patterns matching newlines like `(?s).` and `\s` match differently then, and a warning is logged.
 func (v TreeVisitor) funcCallGrep(args []ExprNode) (NFunction, error) {
-	return v.newGeneratorFunction(args, FuncGrep, 2, 2, func(x ...ND) (GenTemplate, error) {
+	return v.newGeneratorFunction(args, FuncGrep, 2, 3, func(x ...ND) (GenTemplate, error) {
 		expr, ok := x[0].AsOp().String()
 		if !ok {
 			return nil, fmt.Errorf("%w: grep template requires expr", ErrInvalidArgument)
@@ -643,11 +874,23 @@ func (v TreeVisitor) funcCallGrep(args []ExprNode) (NFunction, error) {
 		if !ok {
 			return nil, fmt.Errorf("%w: grep template requires tmpl", ErrInvalidArgument)
 		}
-		return NewRegexpGenTemplate(expr.Raw(), tmpl.Raw()), nil
+		opt := &GrepOption{}
+		if len(x) > 2 {
+			s, ok := x[2].AsOp().String()
+			if !ok {
+				return nil, fmt.Errorf("%w: grep template option should be String", ErrInvalidArgument)
+			}
+			o, err := ParseGrepOption(s.Raw())
+			if err != nil {
+				return nil, err
+			}
+			opt = o
+		}
+		return NewRegexpGenTemplateWithOption(expr.Raw(), tmpl.Raw(), opt), nil
 	})
 }
 
-// @title sh(script: String) -> []Node
+// @title sh(script: String) -> []Node, sh(script: String, option: String) -> []Node
 // @path syntax.functions.sh
 // @document
 // This is one of the available generators.
@@ -666,13 +909,101 @@ func (v TreeVisitor) funcCallGrep(args []ExprNode) (NFunction, error) {
 // ```
 //
 // If `@file` is specified as script, the contents of the file will be used.
+//
+// option is a comma-separated list of the following:
+//
+// - shell=NAME: The interpreter to run the script, like `sh`, `zsh` and `python3`. get and get_or are available only in bash (default).
+// - timeout=DURATION: Kill the script after the [duration](https://pkg.go.dev/time#ParseDuration), like `10s`.
+// - max_output=N: Fail if the script writes more than N bytes to stdout. The captured stderr is truncated to N bytes without failing.
+// - capture: Do not drop the row when the script fails, but store the exit code in the sh_exit_code attribute and stderr in the sh_stderr attribute.
+// - cache: Store the results in the persistent result cache and reuse them. Ignored with capture.
+//
+// ```
+// sh("import os; print('lines=' + str(len(open(os.environ['path']).readlines())))", "shell=python3,timeout=5s,capture")
+// ```
+//
+// The exit code is -1 if the script was killed or could not be run.
+//
+// sh is the only generator that supports the result cache.
+// The cache is keyed by the script, the attributes of the row, and the size and modification time of the file pointed to by the path attribute,
+// so the script should depend only on them.
+// The cache directory can be changed by `--cache_dir`, disabled by `--no_cache` and cleaned by `ndql cache prune`.
+//
+// ```
+// sh("echo lines=$(wc -l < $(get path))", "cache")
+// ```
 func (v TreeVisitor) funcCallSh(args []ExprNode) (NFunction, error) {
-	return v.newGeneratorFunction(args, FuncSh, 1, 1, func(x ...ND) (GenTemplate, error) {
+	return v.newGeneratorFunction(args, FuncSh, 1, 2, func(x ...ND) (GenTemplate, error) {
 		b, err := v.readFileOrString(x[0])
 		if err != nil {
 			return nil, fmt.Errorf("%w: failed to create sh template", err)
 		}
-		return NewShellGenTemplate(string(b)), nil
+		opt := &ShellOption{
+			Shell: defaultShell,
+		}
+		if len(x) > 1 {
+			s, ok := x[1].AsOp().String()
+			if !ok {
+				return nil, fmt.Errorf("%w: sh template option should be String", ErrInvalidArgument)
+			}
+			o, err := ParseShellOption(s.Raw())
+			if err != nil {
+				return nil, err
+			}
+			opt = o
+		}
+		return NewShellGenTemplateWithOption(string(b), opt), nil
+	})
+}
+
+// @title proc(command: String) -> []Node, proc(command: String, option: String) -> []Node
+// @path syntax.functions.proc
+// @document
+// This is one of the available generators.
+// It generates nodes by sending rows to a long-running command, like a classifier that takes time to start.
+//
+// The command is run by bash and kept running, up to `--concurrency` processes per command.
+// Each row is written to the stdin of the command as a line of JSON, like `{"path":"a.txt","size":10}`.
+// The command must write exactly one line to stdout for each line of stdin,
+// which is parsed like the output of `sh`: a JSON object, a JSON array, or equal pairs like `k1=v1,k2=v2`.
+// An empty line generates no nodes.
+// stderr is forwarded to the stderr of ndql.
+//
+// If the command exits or does not respond in time, it is killed and the row is dropped,
+// and the next row starts the command again.
+// The command is stopped by closing stdin when the query finishes.
+//
+// For example, the following expression passes rows to a Python script:
+//
+// ```
+// proc("python3 classify.py", "timeout=10s")
+// ```
+//
+// option is a comma-separated list of the following:
+//
+// - shell=NAME: The interpreter to run the command, like `sh` and `zsh`.
+// - timeout=DURATION: Kill the command if it does not respond to a row within the [duration](https://pkg.go.dev/time#ParseDuration), like `10s`.
+func (v TreeVisitor) funcCallProc(args []ExprNode) (NFunction, error) {
+	return v.newGeneratorFunction(args, FuncProc, 1, 2, func(x ...ND) (GenTemplate, error) {
+		c, ok := x[0].AsOp().String()
+		if !ok {
+			return nil, fmt.Errorf("%w: proc template requires command", ErrInvalidArgument)
+		}
+		opt := &ProcOption{
+			Shell: defaultShell,
+		}
+		if len(x) > 1 {
+			s, ok := x[1].AsOp().String()
+			if !ok {
+				return nil, fmt.Errorf("%w: proc template option should be String", ErrInvalidArgument)
+			}
+			o, err := ParseProcOption(s.Raw())
+			if err != nil {
+				return nil, err
+			}
+			opt = o
+		}
+		return NewProcGenTemplateWithOption(c.Raw(), opt), nil
 	})
 }
 
@@ -687,6 +1018,9 @@ func (v TreeVisitor) funcCallSh(args []ExprNode) (NFunction, error) {
 //
 // - env: Wrapper for [os.Getenv](https://pkg.go.dev/os#Getenv).
 // - envor: Similar to [os.Getenv](https://pkg.go.dev/os#Getenv), but allows a default value as the second argument. It returns the default value if os.Getenv returns an empty string.
+// - get: Retrieves the value of the specified attribute like `key` or `table.key`, like get of `sh`. Returns an empty string if the attribute is not found.
+// - getor: Retrieves the value of the specified attribute. Returns the second argument if the attribute is not found.
+// - ndql functions: dir, basename, extension, abspath, relpath, strtotime, timeformat, year, month, day, hour, minute, second, dayofweek, dayofyear, newtime, now, regexp_like, regexp_count, regexp_instr, regexp_substr, regexp_replace, lower, upper, sha2, trim, substr, substr_index, format, concat_ws, instr, instr_count and replace.
 //
 // For example, the following expression sets the type attribute to "dir" if the is_dir attribute is true, and "file" otherwise:
 //
@@ -694,6 +1028,12 @@ func (v TreeVisitor) funcCallSh(args []ExprNode) (NFunction, error) {
 // tmpl("type={{if .is_dir}}dir{{else}}file{{end}}")'
 // ```
 //
+// The following expression stores the extension and the modified date:
+//
+// ```
+// tmpl("ext={{ extension .path }},date={{ timeformat .mod_time \"2006-01-02\" }}")
+// ```
+//
 // If `@file` is specified as template, the contents of the file will be used.
 func (v TreeVisitor) funcCallTmpl(args []ExprNode) (NFunction, error) {
 	return v.newGeneratorFunction(args, FuncTmpl, 1, 1, func(x ...ND) (GenTemplate, error) {
@@ -705,6 +1045,35 @@ func (v TreeVisitor) funcCallTmpl(args []ExprNode) (NFunction, error) {
 	})
 }
 
+// @title read_lines(path: String) -> []Node
+// @path syntax.functions.read_lines
+// @document
+// This is one of the available generators.
+// It reads the file and generates a node per line.
+// The name is read_lines, not lines, because LINES is a reserved word of SQL.
+// The line number (Int, starting from 1) is stored in the line_no attribute and the line without the line terminator (String) in the line attribute.
+//
+// For example, the following query lists the TODO comments with their line numbers:
+//
+// ```
+// select path, line_no, line from (select read_lines(path) where not is_dir) where line like "%TODO%"
+// ```
+//
+// `lines` is an alias that works only when quoted by backticks, unquoted lines(path) is a syntax error:
+//
+// ```
+// select line from (select `lines`(path))
+// ```
+func (v TreeVisitor) funcCallLines(args []ExprNode) (NFunction, error) {
+	return v.newGeneratorFunction(args, FuncReadLines, 1, 1, func(x ...ND) (GenTemplate, error) {
+		p, ok := x[0].AsOp().String()
+		if !ok {
+			return nil, fmt.Errorf("%w: lines template requires path", ErrInvalidArgument)
+		}
+		return NewLinesGenTemplate(p.Raw()), nil
+	})
+}
+
 //
 // cast
 //
@@ -1131,6 +1500,155 @@ func (v TreeVisitor) funcCallRelPath(args []ExprNode) (NFunction, error) {
 	)
 }
 
+//
+// content
+//
+
+// readContent calls f with the contents of the file pointed to by d.
+func (v TreeVisitor) readContent(d ND, f func(io.Reader) (ND, error)) (ND, error) {
+	s, ok := d.AsOp().String()
+	if !ok {
+		return nil, fmt.Errorf("%w: path should be String: %v", ErrInvalidArgument, d)
+	}
+	r, err := iox.ContentCacheFromContext(v.ctx).Open(s.Raw())
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open %s", errors.Join(ErrInvalidValue, err), s.Raw())
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	x, err := f(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read %s", errors.Join(ErrInvalidValue, err), s.Raw())
+	}
+	return x, nil
+}
+
+// readContentHead calls f with the beginning of the file pointed to by d.
+func (v TreeVisitor) readContentHead(d ND, f func([]byte) ND) (ND, error) {
+	return v.readContent(d, func(r io.Reader) (ND, error) {
+		b, err := iox.Head(r, iox.SniffSize)
+		if err != nil {
+			return nil, err
+		}
+		return f(b), nil
+	})
+}
+
+// @title line_count(path: String) -> Int
+// @path syntax.functions.line_count
+// @document
+// Count the lines of the file.
+// The last line without a trailing newline is also counted.
+func (v TreeVisitor) funcCallLineCount(args []ExprNode) (NFunction, error) {
+	return v.newUnaryArgUnaryRetFunction(args, FuncLineCount, func(x ND) (ND, error) {
+		return v.readContent(x, func(r io.Reader) (ND, error) {
+			c, err := iox.CountLines(r)
+			if err != nil {
+				return nil, err
+			}
+			return node.Int(c), nil
+		})
+	})
+}
+
+// @title file_hash(path: String) -> String, file_hash(path: String, algorithm: String) -> String
+// @path syntax.functions.file_hash
+// @document
+// Calculate the hex digest of the file.
+// algorithm is one of `sha256` (default), `md5` and `xxh64`.
+func (v TreeVisitor) funcCallFileHash(args []ExprNode) (NFunction, error) {
+	return v.newVariadicArgUnaryRetFunction(args, FuncFileHash, 1, 2, func(x ...ND) (ND, error) {
+		algo := iox.HashSHA256
+		if len(x) > 1 {
+			a, ok := x[1].AsOp().String()
+			if !ok {
+				return nil, fmt.Errorf("%w: algorithm should be String: %v", ErrInvalidArgument, x[1])
+			}
+			algo = a.Raw()
+		}
+		return v.readContent(x[0], func(r io.Reader) (ND, error) {
+			h, err := iox.HashContent(r, algo)
+			if err != nil {
+				return nil, err
+			}
+			return node.String(h), nil
+		})
+	})
+}
+
+// @title mime_type(path: String) -> String
+// @path syntax.functions.mime_type
+// @document
+// Detect the media type of the file by the magic bytes, like `text/plain`, `image/png`.
+// See [http.DetectContentType](https://pkg.go.dev/net/http#DetectContentType).
+func (v TreeVisitor) funcCallMimeType(args []ExprNode) (NFunction, error) {
+	return v.newUnaryArgUnaryRetFunction(args, FuncMimeType, func(x ND) (ND, error) {
+		return v.readContentHead(x, func(b []byte) ND { return node.String(iox.DetectMediaType(b)) })
+	})
+}
+
+// @title is_binary(path: String) -> Bool
+// @path syntax.functions.is_binary
+// @document
+// true if the beginning of the file contains a NUL byte.
+func (v TreeVisitor) funcCallIsBinary(args []ExprNode) (NFunction, error) {
+	return v.newUnaryArgUnaryRetFunction(args, FuncIsBinary, func(x ND) (ND, error) {
+		return v.readContentHead(x, func(b []byte) ND { return node.Bool(iox.IsBinary(b)) })
+	})
+}
+
+// @title encoding(path: String) -> String
+// @path syntax.functions.encoding
+// @document
+// Guess the character encoding of the file from the byte order mark and the beginning of the file.
+// One of `ascii`, `utf-8`, `utf-16le`, `utf-16be`, `utf-32le`, `utf-32be`, `binary` and `unknown`.
+func (v TreeVisitor) funcCallEncoding(args []ExprNode) (NFunction, error) {
+	return v.newUnaryArgUnaryRetFunction(args, FuncEncoding, func(x ND) (ND, error) {
+		return v.readContentHead(x, func(b []byte) ND { return node.String(iox.DetectEncoding(b)) })
+	})
+}
+
+// @title first_line(path: String) -> String
+// @path syntax.functions.first_line
+// @document
+// The first line of the file without the line terminator.
+func (v TreeVisitor) funcCallFirstLine(args []ExprNode) (NFunction, error) {
+	return v.newUnaryArgUnaryRetFunction(args, FuncFirstLine, func(x ND) (ND, error) {
+		return v.readContent(x, func(r io.Reader) (ND, error) {
+			s, err := iox.FirstLine(r)
+			if err != nil {
+				return nil, err
+			}
+			return node.String(s), nil
+		})
+	})
+}
+
+// @title read_file(path: String) -> String, read_file(path: String, max_bytes: Int) -> String
+// @path syntax.functions.read_file
+// @document
+// Read the contents of the file.
+// If max_bytes is specified, read at most max_bytes bytes from the beginning.
+func (v TreeVisitor) funcCallReadFile(args []ExprNode) (NFunction, error) {
+	return v.newVariadicArgUnaryRetFunction(args, FuncReadFile, 1, 2, func(x ...ND) (ND, error) {
+		return v.readContent(x[0], func(r io.Reader) (ND, error) {
+			if len(x) > 1 {
+				m, ok := x[1].AsOp().Int()
+				if !ok || m.Raw() < 0 {
+					return nil, fmt.Errorf("%w: max_bytes should be non-negative Int: %v", ErrInvalidArgument, x[1])
+				}
+				r = io.LimitReader(r, m.Raw())
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			return node.String(string(b)), nil
+		})
+	})
+}
+
 //
 // etc
 //
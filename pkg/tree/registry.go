@@ -0,0 +1,187 @@
+package tree
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	. "github.com/pingcap/tidb/pkg/parser/ast"
+)
+
+// FunctionBuilder builds a function from the arguments of the call.
+type FunctionBuilder func(v TreeVisitor, args []ExprNode) (NFunction, error)
+
+// ScalarFunction is a function that returns a value from the values of the arguments.
+type ScalarFunction struct {
+	Name string
+	// Bounds of the number of the arguments.
+	MinArgs int
+	MaxArgs int
+	// Doc is returned by Documents, optional.
+	Doc  string
+	Call func(...ND) (ND, error)
+}
+
+// GeneratorFunction is a function that generates nodes from the values of the arguments.
+//
+// Generated nodes are merged into the original node like sh and lua.
+type GeneratorFunction struct {
+	Name string
+	// Bounds of the number of the arguments, MinArgs should be positive.
+	MinArgs int
+	MaxArgs int
+	// Doc is returned by Documents, optional.
+	Doc string
+	New func(...ND) (GenTemplate, error)
+}
+
+// FunctionDocument is the document of a registered function.
+type FunctionDocument struct {
+	Name string
+	Doc  string
+}
+
+type registeredFunction struct {
+	build FunctionBuilder
+	doc   string
+}
+
+// FunctionRegistry is a set of functions callable in queries, except aggregations.
+type FunctionRegistry struct {
+	mux   sync.RWMutex
+	funcs map[string]*registeredFunction
+}
+
+// NewFunctionRegistry returns a new registry that contains the builtin functions.
+func NewFunctionRegistry() *FunctionRegistry {
+	builtinFunctions.mux.RLock()
+	defer builtinFunctions.mux.RUnlock()
+	return &FunctionRegistry{
+		funcs: maps.Clone(builtinFunctions.funcs),
+	}
+}
+
+// builtinFunctions is populated by init in func.go.
+var builtinFunctions = &FunctionRegistry{
+	funcs: map[string]*registeredFunction{},
+}
+
+var functionNameRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// Register registers a function built by b under the name.
+// Names are case-insensitive and cannot be registered twice.
+func (r *FunctionRegistry) Register(name, doc string, b FunctionBuilder) error {
+	name = strings.ToLower(name)
+	if !functionNameRegexp.MatchString(name) {
+		return fmt.Errorf("%w: invalid function name %q", ErrInvalidKey, name)
+	}
+	if b == nil {
+		return fmt.Errorf("%w: function %s has no builder", ErrInvalidArgument, name)
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if _, ok := r.funcs[name]; ok {
+		return fmt.Errorf("%w: function %s is already registered", ErrInvalidKey, name)
+	}
+	r.funcs[name] = &registeredFunction{
+		build: b,
+		doc:   doc,
+	}
+	return nil
+}
+
+func validateFunctionArgLen(name string, minArgs, maxArgs int) error {
+	if minArgs < 0 || maxArgs < minArgs {
+		return fmt.Errorf("%w: function %s argLen [%d, %d]", ErrInvalidArgument, name, minArgs, maxArgs)
+	}
+	return nil
+}
+
+// RegisterScalar registers a scalar function.
+func (r *FunctionRegistry) RegisterScalar(f ScalarFunction) error {
+	if err := validateFunctionArgLen(f.Name, f.MinArgs, f.MaxArgs); err != nil {
+		return err
+	}
+	if f.Call == nil {
+		return fmt.Errorf("%w: function %s has no Call", ErrInvalidArgument, f.Name)
+	}
+	return r.Register(f.Name, f.Doc, func(v TreeVisitor, args []ExprNode) (NFunction, error) {
+		if len(args) == 0 && f.MinArgs == 0 {
+			return v.newNullaryArgUnaryRetFunction(args, f.Name, func() (ND, error) {
+				return f.Call()
+			})
+		}
+		return v.newVariadicArgUnaryRetFunction(args, f.Name, f.MinArgs, f.MaxArgs, f.Call)
+	})
+}
+
+// RegisterGenerator registers a generator function.
+func (r *FunctionRegistry) RegisterGenerator(f GeneratorFunction) error {
+	if err := validateFunctionArgLen(f.Name, f.MinArgs, f.MaxArgs); err != nil {
+		return err
+	}
+	if f.MinArgs < 1 {
+		return fmt.Errorf("%w: generator function %s requires at least 1 argument", ErrInvalidArgument, f.Name)
+	}
+	if f.New == nil {
+		return fmt.Errorf("%w: function %s has no New", ErrInvalidArgument, f.Name)
+	}
+	return r.Register(f.Name, f.Doc, func(v TreeVisitor, args []ExprNode) (NFunction, error) {
+		return v.newGeneratorFunction(args, f.Name, f.MinArgs, f.MaxArgs, f.New)
+	})
+}
+
+func (r *FunctionRegistry) get(name string) (*registeredFunction, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	f, ok := r.funcs[strings.ToLower(name)]
+	return f, ok
+}
+
+// Has returns true if the function is registered.
+func (r *FunctionRegistry) Has(name string) bool {
+	_, ok := r.get(name)
+	return ok
+}
+
+// Names returns the names of the functions, sorted.
+func (r *FunctionRegistry) Names() []string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return slices.Sorted(maps.Keys(r.funcs))
+}
+
+// Documents returns the documents of the functions that have them, sorted by name.
+func (r *FunctionRegistry) Documents() []FunctionDocument {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	var docs []FunctionDocument
+	for _, name := range slices.Sorted(maps.Keys(r.funcs)) {
+		if doc := r.funcs[name].doc; doc != "" {
+			docs = append(docs, FunctionDocument{
+				Name: name,
+				Doc:  doc,
+			})
+		}
+	}
+	return docs
+}
+
+type functionRegistryContextKey struct{}
+
+// WithFunctionRegistry returns a context that resolves function calls with r.
+func WithFunctionRegistry(ctx context.Context, r *FunctionRegistry) context.Context {
+	return context.WithValue(ctx, functionRegistryContextKey{}, r)
+}
+
+func functionRegistryFromContext(ctx context.Context) *FunctionRegistry {
+	if r, ok := ctx.Value(functionRegistryContextKey{}).(*FunctionRegistry); ok {
+		return r
+	}
+	return builtinFunctions
+}
@@ -0,0 +1,179 @@
+package tree_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/berquerant/ndql/pkg/errorx"
+	"github.com/berquerant/ndql/pkg/node"
+	"github.com/berquerant/ndql/pkg/parse"
+	"github.com/berquerant/ndql/pkg/tree"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFunctionRegistry(t *testing.T) {
+	r := tree.NewFunctionRegistry()
+	assert.True(t, r.Has(tree.FuncLen), "builtin")
+	assert.Nil(t, r.RegisterScalar(tree.ScalarFunction{
+		Name:    "Repeat",
+		MinArgs: 1,
+		MaxArgs: 2,
+		Doc:     "repeat(s: String, count: Int) -> String",
+		Call: func(xs ...node.Data) (node.Data, error) {
+			s, ok := xs[0].AsOp().String()
+			if !ok {
+				return nil, errors.New("not a string")
+			}
+			count := node.Int(2)
+			if len(xs) > 1 {
+				if count, ok = xs[1].AsOp().Int(); !ok {
+					return nil, errors.New("not an int")
+				}
+			}
+			return node.String(strings.Repeat(s.Raw(), int(count))), nil
+		},
+	}))
+	assert.Nil(t, r.RegisterScalar(tree.ScalarFunction{
+		Name: "answer",
+		Call: func(...node.Data) (node.Data, error) {
+			return node.Int(42), nil
+		},
+	}))
+	assert.Nil(t, r.RegisterGenerator(tree.GeneratorFunction{
+		Name:    "gen_pairs",
+		MinArgs: 1,
+		MaxArgs: 1,
+		New: func(xs ...node.Data) (tree.GenTemplate, error) {
+			s, _ := xs[0].AsOp().String()
+			return tree.NewStringGenTemplate(strings.ReplaceAll(s.Raw(), ",", "\n")), nil
+		},
+	}))
+
+	t.Run("register", func(t *testing.T) {
+		assert.ErrorIs(t, r.RegisterScalar(tree.ScalarFunction{
+			Name:    tree.FuncLen,
+			MinArgs: 1,
+			MaxArgs: 1,
+			Call:    func(...node.Data) (node.Data, error) { return nil, nil },
+		}), tree.ErrInvalidKey, "builtin")
+		assert.ErrorIs(t, r.RegisterScalar(tree.ScalarFunction{
+			Name: "REPEAT",
+			Call: func(...node.Data) (node.Data, error) { return nil, nil },
+		}), tree.ErrInvalidKey, "case-insensitive")
+		assert.ErrorIs(t, r.RegisterScalar(tree.ScalarFunction{
+			Name: "bad-name",
+			Call: func(...node.Data) (node.Data, error) { return nil, nil },
+		}), tree.ErrInvalidKey)
+		assert.ErrorIs(t, r.RegisterScalar(tree.ScalarFunction{
+			Name:    "bad_bounds",
+			MinArgs: 2,
+			MaxArgs: 1,
+			Call:    func(...node.Data) (node.Data, error) { return nil, nil },
+		}), tree.ErrInvalidArgument)
+		assert.ErrorIs(t, r.RegisterGenerator(tree.GeneratorFunction{
+			Name: "no_args",
+			New:  func(...node.Data) (tree.GenTemplate, error) { return nil, nil },
+		}), tree.ErrInvalidArgument)
+		assert.False(t, tree.NewFunctionRegistry().Has("repeat"), "registries are independent")
+	})
+
+	t.Run("names", func(t *testing.T) {
+		names := r.Names()
+		assert.True(t, slices.IsSorted(names))
+		assert.Contains(t, names, tree.FuncLen)
+		assert.Contains(t, names, "repeat")
+		assert.Contains(t, names, "gen_pairs")
+	})
+
+	t.Run("documents", func(t *testing.T) {
+		assert.Equal(t, []tree.FunctionDocument{
+			{
+				Name: "repeat",
+				Doc:  "repeat(s: String, count: Int) -> String",
+			},
+		}, r.Documents())
+	})
+
+	data := newNodes([]map[string]node.Data{
+		{
+			"k1": node.String("ab"),
+		},
+	})
+	for _, tc := range []struct {
+		title string
+		query string
+		want  []*tree.N
+		err   error
+	}{
+		{
+			title: "scalar",
+			query: `select repeat(k1, 3) as k1, REPEAT(k1) as k2`,
+			want: newNodes([]map[string]node.Data{
+				{
+					"k1": node.String("ababab"),
+					"k2": node.String("abab"),
+				},
+			}),
+		},
+		{
+			title: "nullary scalar",
+			query: `select answer() as k1`,
+			want: newNodes([]map[string]node.Data{
+				{
+					"k1": node.Int(42),
+				},
+			}),
+		},
+		{
+			title: "generator",
+			query: `select k2 from (select gen_pairs("k2=x,k2=y"))`,
+			want: newNodes([]map[string]node.Data{
+				{
+					"k2": node.String("x"),
+				},
+				{
+					"k2": node.String("y"),
+				},
+			}),
+		},
+		{
+			title: "arity",
+			query: `select repeat(k1, 1, 2) as k1`,
+			err:   tree.ErrInvalidValue,
+		},
+		{
+			title: "not registered",
+			query: `select unknown_func(k1) as k1`,
+			err:   tree.ErrNotImplmented,
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			p, err := parse.NewSQLParser().Parse(tc.query)
+			if !assert.Nil(t, err, "query syntax: %s", errorx.AsString(err)) {
+				return
+			}
+			ctx := tree.WithFunctionRegistry(context.TODO(), r)
+			it, err := tree.AsIter(ctx, slices.Values(data), p.Nodes[0])
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err, errorx.AsString(err))
+				return
+			}
+			if !assert.Nil(t, err, errorx.AsString(err)) {
+				return
+			}
+			assert.Equal(t, tc.want, slices.Collect(it))
+		})
+	}
+
+	t.Run("builtin registry by default", func(t *testing.T) {
+		p, err := parse.NewSQLParser().Parse(`select repeat(k1) as k1`)
+		if !assert.Nil(t, err) {
+			return
+		}
+		_, err = tree.AsIter(context.TODO(), slices.Values(data), p.Nodes[0])
+		assert.ErrorIs(t, err, tree.ErrNotImplmented)
+	})
+}
@@ -0,0 +1,159 @@
+package tree
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/berquerant/ndql/pkg/logx"
+	"github.com/berquerant/ndql/pkg/node"
+)
+
+var ErrTooManyRowErrors = errors.New("TooManyRowErrors")
+
+// RowError is the error of a row that failed to be evaluated.
+type RowError struct {
+	Node *N
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	b, _ := json.Marshal(e.Node)
+	return fmt.Sprintf("%v: row %s", e.Err, b)
+}
+
+func (e *RowError) Unwrap() error { return e.Err }
+
+// Class returns the class of the error like InvalidValue.
+func (e *RowError) Class() string { return ErrorClass(e.Err) }
+
+// MarshalJSON returns the record of the error:
+// the input node, the failing expression, the class of the error, the error message and the stderr of the generator.
+func (e *RowError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Node   *N     `json:"node"`
+		Expr   string `json:"expr,omitempty"`
+		Class  string `json:"class"`
+		Error  string `json:"error"`
+		Stderr string `json:"stderr,omitempty"`
+	}{
+		Node:   e.Node,
+		Expr:   ErrorExpr(e.Err),
+		Class:  e.Class(),
+		Error:  e.Err.Error(),
+		Stderr: ErrorStderr(e.Err),
+	})
+}
+
+// tooManyRowErrors is the error that aborts the query.
+// The message describes the last row error shortly by the class, the expression and the path of the row,
+// because the error of the row can be large.
+type tooManyRowErrors struct {
+	count int
+	max   int
+	last  *RowError
+}
+
+func (e *tooManyRowErrors) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v: %d errors exceed max %d: %s", ErrTooManyRowErrors, e.count, e.max, e.last.Class())
+	if x := ErrorExpr(e.last.Err); x != "" {
+		fmt.Fprintf(&b, " at %s", x)
+	}
+	if p, ok := e.last.Node.Get(node.KeyPath); ok {
+		fmt.Fprintf(&b, " in %v", p.Any())
+	}
+	return b.String()
+}
+
+func (e *tooManyRowErrors) Unwrap() []error { return []error{ErrTooManyRowErrors, e.last} }
+
+// RowErrors counts the rows that failed to be evaluated.
+//
+// The rows are dropped until the number of them exceeds the max,
+// then the query is aborted.
+type RowErrors struct {
+	mux     sync.Mutex
+	max     int
+	count   int
+	err     error
+	handler func(*RowError)
+}
+
+// NewRowErrors returns a new RowErrors that tolerates max errors, negative means unlimited.
+func NewRowErrors(max int) *RowErrors {
+	return &RowErrors{
+		max: max,
+	}
+}
+
+// SetHandler sets the function called with every error, not concurrently.
+func (r *RowErrors) SetHandler(f func(*RowError)) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.handler = f
+}
+
+// Limited returns true if the number of the errors is limited.
+func (r *RowErrors) Limited() bool { return r.max >= 0 }
+
+// Add records the error of the row.
+// It returns an error if the number of the errors exceeds the max.
+func (r *RowErrors) Add(n *N, err error) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.count++
+	rowErr := &RowError{
+		Node: n,
+		Err:  err,
+	}
+	if r.handler != nil {
+		r.handler(rowErr)
+	}
+	if r.max >= 0 && r.count > r.max {
+		r.err = &tooManyRowErrors{
+			count: r.count,
+			max:   r.max,
+			last:  rowErr,
+		}
+		return r.err
+	}
+	slog.Debug("failed to yield node", logx.JSON("node", n), logx.Err(err))
+	return nil
+}
+
+// Count returns the number of the errors.
+func (r *RowErrors) Count() int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.count
+}
+
+// Err returns the error that aborted the query, nil if not aborted.
+func (r *RowErrors) Err() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.err
+}
+
+type rowErrorsContextKey struct{}
+
+// WithRowErrors returns a context that records the errors of rows into r.
+func WithRowErrors(ctx context.Context, r *RowErrors) context.Context {
+	return context.WithValue(ctx, rowErrorsContextKey{}, r)
+}
+
+// rowErrorsFromContext returns the RowErrors of the context, or unlimited one.
+func rowErrorsFromContext(ctx context.Context) *RowErrors {
+	if r, ok := ctx.Value(rowErrorsContextKey{}).(*RowErrors); ok {
+		return r
+	}
+	return NewRowErrors(-1)
+}
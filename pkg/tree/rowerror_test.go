@@ -0,0 +1,225 @@
+package tree_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/berquerant/ndql/pkg/errorx"
+	"github.com/berquerant/ndql/pkg/node"
+	"github.com/berquerant/ndql/pkg/parse"
+	"github.com/berquerant/ndql/pkg/tree"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRowErrors(t *testing.T) {
+	errRow := errors.New("row")
+
+	t.Run("unlimited", func(t *testing.T) {
+		r := tree.NewRowErrors(-1)
+		assert.False(t, r.Limited())
+		for range 3 {
+			assert.Nil(t, r.Add(node.New(), errRow))
+		}
+		assert.Equal(t, 3, r.Count())
+		assert.Nil(t, r.Err())
+	})
+
+	t.Run("limited", func(t *testing.T) {
+		r := tree.NewRowErrors(1)
+		assert.True(t, r.Limited())
+		assert.Nil(t, r.Add(node.New(), errRow))
+		err := r.Add(node.FromMap(map[string]node.Data{
+			"path": node.String("a.txt"),
+		}), errRow)
+		assert.ErrorIs(t, err, tree.ErrTooManyRowErrors)
+		assert.ErrorIs(t, err, errRow)
+		assert.Equal(t, "TooManyRowErrors: 2 errors exceed max 1: Unknown in a.txt", err.Error())
+		assert.Equal(t, err, r.Err())
+		assert.Equal(t, err, r.Add(node.New(), errRow), "keep the first error")
+		assert.Equal(t, 2, r.Count())
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		r := tree.NewRowErrors(-1)
+		var got []*tree.RowError
+		r.SetHandler(func(x *tree.RowError) {
+			got = append(got, x)
+		})
+		n := node.New()
+		assert.Nil(t, r.Add(n, errRow))
+		assert.Equal(t, []*tree.RowError{
+			{
+				Node: n,
+				Err:  errRow,
+			},
+		}, got)
+	})
+
+	data := newNodes([]map[string]node.Data{
+		{
+			"k1": node.Int(1),
+			"k3": node.Duration(time.Second),
+		},
+		{
+			"k1": node.Int(2),
+			"k2": node.Bool(true),
+		},
+		{
+			"k1": node.Int(3),
+			"k2": node.NewNull(),
+		},
+	})
+	for _, tc := range []struct {
+		title     string
+		query     string
+		maxErrors int
+		want      []*tree.N
+		err       error
+		record    map[string]any
+		// the number of the errors, when no error aborts the query
+		count int
+	}{
+		{
+			title:     "missing column is dropped and counted",
+			query:     `select k2`,
+			maxErrors: -1,
+			want: newNodes([]map[string]node.Data{
+				{
+					"k2": node.Bool(true),
+				},
+				{
+					"k2": node.NewNull(),
+				},
+			}),
+			count: 1,
+		},
+		{
+			title:     "missing column is an error",
+			query:     `select k2`,
+			maxErrors: 0,
+			err:       tree.ErrInvalidKey,
+			record: map[string]any{
+				"node": map[string]any{
+					"k1": float64(1),
+					"k3": "1s",
+				},
+				"expr":  "`k2`",
+				"class": "InvalidKey",
+			},
+		},
+		{
+			title:     "missing column is tolerated",
+			query:     `select k2`,
+			maxErrors: 1,
+			want: newNodes([]map[string]node.Data{
+				{
+					"k2": node.Bool(true),
+				},
+				{
+					"k2": node.NewNull(),
+				},
+			}),
+			count: 1,
+		},
+		{
+			title:     "null where is false",
+			query:     `select k1 where null`,
+			maxErrors: 0,
+			want:      []*tree.N{},
+		},
+		{
+			title:     "not bool where is an error",
+			query:     `select k1 where k3`,
+			maxErrors: 0,
+			err:       tree.ErrInvalidValue,
+			record: map[string]any{
+				"node": map[string]any{
+					"k1": float64(1),
+					"k3": "1s",
+				},
+				"expr":  "`k3`",
+				"class": "InvalidValue",
+			},
+		},
+		{
+			title:     "generator stderr",
+			query:     `select sh("echo failed >&2; exit 1")`,
+			maxErrors: 0,
+			err:       tree.ErrGenTemplate,
+			record: map[string]any{
+				"node": map[string]any{
+					"k1": float64(1),
+					"k3": "1s",
+				},
+				"expr":   `sh("echo failed >&2; exit 1")`,
+				"class":  "GenTemplate",
+				"stderr": "failed\n",
+			},
+		},
+		{
+			title:     "expr without charset",
+			query:     `select k3 + "a" as x`,
+			maxErrors: 0,
+			err:       node.ErrUnavailable,
+			record: map[string]any{
+				"node": map[string]any{
+					"k1": float64(1),
+					"k3": "1s",
+				},
+				"expr":  "`k3`+\"a\"",
+				"class": "Unavailable",
+			},
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			p, err := parse.NewSQLParser().Parse(tc.query)
+			if !assert.Nil(t, err, "query syntax: %s", errorx.AsString(err)) {
+				return
+			}
+			r := tree.NewRowErrors(tc.maxErrors)
+			var handled []string
+			r.SetHandler(func(x *tree.RowError) {
+				handled = append(handled, x.Class())
+			})
+			ctx := tree.WithRowErrors(context.TODO(), r)
+			it, err := tree.AsIter(ctx, slices.Values(data), p.Nodes[0])
+			if !assert.Nil(t, err, errorx.AsString(err)) {
+				return
+			}
+			got := slices.Collect(it)
+			if tc.err != nil {
+				assert.ErrorIs(t, r.Err(), tc.err, errorx.AsString(r.Err()))
+				assert.Equal(t, fmt.Sprintf("TooManyRowErrors: 1 errors exceed max 0: %s at %s", tc.record["class"], tc.record["expr"]), r.Err().Error())
+				var rowErr *tree.RowError
+				if !assert.ErrorAs(t, r.Err(), &rowErr) {
+					return
+				}
+				b, err := json.Marshal(rowErr)
+				if !assert.Nil(t, err) {
+					return
+				}
+				var record map[string]any
+				if !assert.Nil(t, json.Unmarshal(b, &record)) {
+					return
+				}
+				assert.Contains(t, record["error"], tc.err.Error())
+				delete(record, "error")
+				assert.Equal(t, tc.record, record)
+				return
+			}
+			assert.Nil(t, r.Err(), errorx.AsString(r.Err()))
+			assert.Equal(t, tc.count, r.Count())
+			assert.Len(t, handled, tc.count)
+			if len(tc.want) == 0 {
+				assert.Empty(t, got)
+				return
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
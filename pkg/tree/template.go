@@ -2,7 +2,19 @@ package tree
 
 import (
 	"context"
+	"encoding/json"
+	"io/fs"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/berquerant/ndql/pkg/cachex"
+	"github.com/berquerant/ndql/pkg/iox"
+	"github.com/berquerant/ndql/pkg/logx"
+	"github.com/berquerant/ndql/pkg/node"
+	"github.com/berquerant/ndql/pkg/util"
 )
 
 type GenTemplate interface {
@@ -39,7 +51,46 @@ type GenTemplate interface {
 	Generate(ctx context.Context, n *N) ([]byte, error)
 }
 
+// NodeGenTemplate is a GenTemplate that can generate nodes directly.
+// Unlike Generate, the types of the values are kept as they are.
+type NodeGenTemplate interface {
+	GenTemplate
+	GenerateNodes(ctx context.Context, n *N) ([]*N, error)
+}
+
+// CacheableGenTemplate is a GenTemplate whose results can be stored in the result cache.
+// The result of Generate is cached instead of GenerateNodes, so the values are parsed by ParseGenResult
+// even if it is also a NodeGenTemplate.
+// Only ShellGenTemplate implements this.
+type CacheableGenTemplate interface {
+	GenTemplate
+	// CacheKey returns the key of the result for the node, false if the result should not be cached.
+	CacheKey(ctx context.Context, n *N) (string, bool)
+}
+
+type resultCacheContextKey struct{}
+
+// WithResultCache returns a context that caches the results of CacheableGenTemplate in c.
+func WithResultCache(ctx context.Context, c *cachex.DiskCache) context.Context {
+	return context.WithValue(ctx, resultCacheContextKey{}, c)
+}
+
+func resultCacheFromContext(ctx context.Context) (*cachex.DiskCache, bool) {
+	c, ok := ctx.Value(resultCacheContextKey{}).(*cachex.DiskCache)
+	return c, ok
+}
+
 func GenerateAndParse(ctx context.Context, n *N, g GenTemplate) ([]*N, error) {
+	if c, ok := resultCacheFromContext(ctx); ok {
+		if cg, ok := g.(CacheableGenTemplate); ok {
+			if key, ok := cg.CacheKey(ctx, n); ok {
+				return generateAndParseWithCache(ctx, n, cg, c, key)
+			}
+		}
+	}
+	if ng, ok := g.(NodeGenTemplate); ok {
+		return ng.GenerateNodes(ctx, n)
+	}
 	b, err := g.Generate(ctx, n)
 	if err != nil {
 		return nil, err
@@ -47,6 +98,43 @@ func GenerateAndParse(ctx context.Context, n *N, g GenTemplate) ([]*N, error) {
 	return ParseGenResult(b)
 }
 
+func generateAndParseWithCache(ctx context.Context, n *N, g GenTemplate, c *cachex.DiskCache, key string) ([]*N, error) {
+	if b, ok := c.Get(key); ok {
+		return ParseGenResult(b)
+	}
+	b, err := g.Generate(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ParseGenResult(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Set(key, b); err != nil {
+		slog.Warn("failed to store generator result", slog.String("dir", c.Root()), logx.Err(err))
+	}
+	return r, nil
+}
+
+// genTemplateCacheKey returns the key of the generator result for the node.
+// It depends on the kind and the script of the generator, the attributes of the node,
+// and the size and the modification time of the file pointed to by the path attribute.
+func genTemplateCacheKey(ctx context.Context, kind, script string, n *N) string {
+	b, _ := json.Marshal(n)
+	parts := []string{kind, util.Hash(script), string(b)}
+	if d, ok := n.Get(node.KeyPath); ok {
+		if p, ok := d.AsOp().String(); ok {
+			if info, err := fs.Stat(iox.FSFromContext(ctx), p.Raw()); err == nil {
+				parts = append(parts,
+					strconv.FormatInt(info.Size(), 10),
+					info.ModTime().Format(time.RFC3339Nano),
+				)
+			}
+		}
+	}
+	return util.Hash(strings.Join(parts, "\x00"))
+}
+
 func NodeAsEnviron(n *N) []string {
 	xs := []string{}
 	for k, v := range n.Unwrap() {
@@ -66,6 +154,19 @@ func genTemplateEnvOr(key, v string) string {
 	return v
 }
 
+// genTemplateGetOr returns the value of the key like 'key' or 'table.key' as a string,
+// or v if not found or empty, like get_or of sh.
+func genTemplateGetOr(n *N, name, v string) string {
+	if r, ok := KeyFromName(name).Get(n); ok {
+		for _, d := range r.Unwrap() {
+			if s, err := d.AsOp().AsString(); err == nil && s.Raw() != "" {
+				return s.Raw()
+			}
+		}
+	}
+	return v
+}
+
 func NodeAsStructuredMap(n *N) map[string]any {
 	r := make(map[string]any)
 	for k, v := range n.Unwrap() {
@@ -77,13 +178,37 @@ func NodeAsStructuredMap(n *N) map[string]any {
 		}
 		if d, ok := r[key.Table]; ok {
 			if dv, ok := d.(map[string]any); ok {
-				dv[key.Column] = v
+				dv[key.Column] = value
 				continue
 			}
 		}
 		r[key.Table] = map[string]any{
-			key.Column: v,
+			key.Column: value,
+		}
+	}
+	return r
+}
+
+type templateOption struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+// parseTemplateOptions splits a comma-separated list of options like "a,b=1".
+func parseTemplateOptions(s string) []templateOption {
+	r := []templateOption{}
+	for x := range strings.SplitSeq(s, ",") {
+		x = strings.TrimSpace(x)
+		if x == "" {
+			continue
 		}
+		k, v, ok := strings.Cut(x, "=")
+		r = append(r, templateOption{
+			key:      strings.TrimSpace(k),
+			value:    strings.TrimSpace(v),
+			hasValue: ok,
+		})
 	}
 	return r
 }
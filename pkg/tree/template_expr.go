@@ -2,6 +2,7 @@ package tree
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -10,6 +11,7 @@ import (
 	"github.com/berquerant/ndql/pkg/cachex"
 	"github.com/berquerant/ndql/pkg/util"
 	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
 )
 
 //
@@ -21,6 +23,19 @@ import (
 //
 // - e: environment variables from os.Environ
 // - n: node
+// - helper functions like basename, timeformat and regexp_like
+//
+// ## Result
+//
+// - string: parsed by ParseGenResult
+// - map: a node
+// - list of maps: nodes
+// - nil: no nodes
+// - other scalar: a node with the expr key
+
+const (
+	ExprKeyResult = "expr"
+)
 
 type ExprGenTemplate struct {
 	expr string
@@ -32,9 +47,17 @@ func NewExprGenTemplate(expr string) *ExprGenTemplate {
 	}
 }
 
-var _ GenTemplate = &ExprGenTemplate{}
+var _ NodeGenTemplate = &ExprGenTemplate{}
 
-var exprGenTemplateCache = util.Must(cachex.NewExprCache())
+var exprGenTemplateCache = util.Must(cachex.NewExprCache(exprHelperOptions()...))
+
+func exprHelperOptions() []expr.Option {
+	r := make([]expr.Option, len(genTemplateHelpers))
+	for i, h := range genTemplateHelpers {
+		r[i] = expr.Function(h.name, h.call)
+	}
+	return r
+}
 
 const (
 	exprGenTemplateEnvKey  = "e"
@@ -42,16 +65,70 @@ const (
 )
 
 func (g ExprGenTemplate) Generate(_ context.Context, n *N) ([]byte, error) {
+	r, err := g.run(n)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := r.(string); ok {
+		return []byte(s), nil
+	}
+	ns, _, err := nodesFromAny(r, ExprKeyResult)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to convert expr result", errors.Join(ErrGenTemplate, err))
+	}
+	b, err := json.Marshal(ns)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to marshal expr result", errors.Join(ErrGenTemplate, err))
+	}
+	return b, nil
+}
+
+func (g ExprGenTemplate) GenerateNodes(_ context.Context, n *N) ([]*N, error) {
+	r, err := g.run(n)
+	if err != nil {
+		return nil, err
+	}
+	ns, ok, err := nodesFromAny(r, ExprKeyResult)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to convert expr result", errors.Join(ErrGenTemplate, err))
+	}
+	if !ok {
+		return ParseGenResult([]byte(r.(string)))
+	}
+	return ns, nil
+}
+
+// Value evaluates the expression and returns the result as a single value.
+func (g ExprGenTemplate) Value(_ context.Context, n *N) (ND, error) {
+	r, err := g.run(n)
+	if err != nil {
+		return nil, err
+	}
+	d, err := dataFromAny(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to convert expr result", errors.Join(ErrGenTemplate, err))
+	}
+	return d, nil
+}
+
+func (g ExprGenTemplate) compile() (*vm.Program, error) {
 	e, err := exprGenTemplateCache.Get(g.expr)
 	if err != nil {
 		return nil, fmt.Errorf("%w: failed to compile expr", errors.Join(ErrGenTemplate, err))
 	}
+	return e, nil
+}
 
+func (g ExprGenTemplate) run(n *N) (any, error) {
+	e, err := g.compile()
+	if err != nil {
+		return nil, err
+	}
 	r, err := expr.Run(e, g.newEnv(n))
 	if err != nil {
 		return nil, fmt.Errorf("%w: failed to run expr", errors.Join(ErrGenTemplate, err))
 	}
-	return fmt.Append(nil, r), nil
+	return r, nil
 }
 
 func (g ExprGenTemplate) newEnv(n *N) map[string]any {
@@ -0,0 +1,283 @@
+package tree
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/berquerant/ndql/pkg/node"
+)
+
+//
+// helper functions available in the environments of generators
+//
+
+// genTemplateHelper is a function built from the node.Op methods,
+// named after the corresponding ndql function.
+type genTemplateHelper struct {
+	name string
+	min  int
+	max  int
+	f    func(...*OP) (*OP, error)
+}
+
+// call calls the helper with Go values and returns a Go value.
+func (h genTemplateHelper) call(args ...any) (any, error) {
+	if len(args) < h.min || len(args) > h.max {
+		return nil, fmt.Errorf("%w: %s takes %d to %d arguments, got %d", ErrInvalidFunctionArity, h.name, h.min, h.max, len(args))
+	}
+	xs := make([]*OP, len(args))
+	for i, x := range args {
+		d, err := dataFromAny(x)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s args[%d]", err, h.name, i)
+		}
+		xs[i] = d.AsOp()
+	}
+	r, err := h.f(xs...)
+	if err != nil {
+		return nil, err
+	}
+	return r.AsData().Any(), nil
+}
+
+func unaryGenTemplateHelper(name string, f func(*OP) (*OP, error)) *genTemplateHelper {
+	return &genTemplateHelper{
+		name: name,
+		min:  1,
+		max:  1,
+		f: func(x ...*OP) (*OP, error) {
+			return f(x[0])
+		},
+	}
+}
+
+func binaryGenTemplateHelper(name string, f func(*OP, *OP) (*OP, error)) *genTemplateHelper {
+	return &genTemplateHelper{
+		name: name,
+		min:  2,
+		max:  2,
+		f: func(x ...*OP) (*OP, error) {
+			return f(x[0], x[1])
+		},
+	}
+}
+
+func variadicGenTemplateHelper(name string, minLen, maxLen int, f func(*OP, ...*OP) (*OP, error)) *genTemplateHelper {
+	return &genTemplateHelper{
+		name: name,
+		min:  minLen,
+		max:  maxLen,
+		f: func(x ...*OP) (*OP, error) {
+			return f(x[0], x[1:]...)
+		},
+	}
+}
+
+// genTemplateHelpers are the path, time and regexp functions.
+var genTemplateHelpers = []*genTemplateHelper{
+	unaryGenTemplateHelper(FuncDir, (*OP).Dir),
+	unaryGenTemplateHelper(FuncBasename, (*OP).Basename),
+	unaryGenTemplateHelper(FuncExtension, (*OP).Extension),
+	unaryGenTemplateHelper(FuncAbsPath, (*OP).AbsPath),
+	binaryGenTemplateHelper(FuncRelPath, (*OP).RelPath),
+
+	binaryGenTemplateHelper(FuncStrToTime, (*OP).StrToTime),
+	binaryGenTemplateHelper(FuncTimeFormat, (*OP).TimeFormat),
+	unaryGenTemplateHelper(FuncYear, (*OP).Year),
+	unaryGenTemplateHelper(FuncMonth, (*OP).Month),
+	unaryGenTemplateHelper(FuncDay, (*OP).Day),
+	unaryGenTemplateHelper(FuncHour, (*OP).Hour),
+	unaryGenTemplateHelper(FuncMinute, (*OP).Minute),
+	unaryGenTemplateHelper(FuncSecond, (*OP).Second),
+	unaryGenTemplateHelper(FuncDayOfWeek, (*OP).DayOfWeek),
+	unaryGenTemplateHelper(FuncDayOfYear, (*OP).DayOfYear),
+	{
+		name: FuncNewTime,
+		min:  1,
+		max:  6,
+		f: func(x ...*OP) (*OP, error) {
+			return x[0].NewTime(x[1:]...)
+		},
+	},
+
+	binaryGenTemplateHelper(FuncRegexpLike, (*OP).Regexp),
+	binaryGenTemplateHelper(FuncRegexpCount, (*OP).RegexpCount),
+	binaryGenTemplateHelper(FuncRegexpInstr, (*OP).RegexpInstr),
+	binaryGenTemplateHelper(FuncRegexpSubstr, (*OP).RegexpSubstr),
+	{
+		name: FuncRegexpReplace,
+		min:  3,
+		max:  3,
+		f: func(x ...*OP) (*OP, error) {
+			return x[0].RegexpReplace(x[1], x[2])
+		},
+	},
+}
+
+// genTemplateStringHelpers are the string functions and now,
+// for the environments that do not have their own ones.
+var genTemplateStringHelpers = []*genTemplateHelper{
+	unaryGenTemplateHelper(FuncLower, (*OP).Lower),
+	unaryGenTemplateHelper(FuncUpper, (*OP).Upper),
+	unaryGenTemplateHelper(FuncSha2, (*OP).Sha2),
+	variadicGenTemplateHelper(FuncTrim, 1, 2, (*OP).Trim),
+	variadicGenTemplateHelper(FuncSubstr, 2, 3, (*OP).Substr),
+	variadicGenTemplateHelper(FuncFormat, 1, FuncArgMaxLen, (*OP).Format),
+	variadicGenTemplateHelper(FuncConcatWs, 1, FuncArgMaxLen, (*OP).ConcatWs),
+	binaryGenTemplateHelper(FuncInstr, (*OP).Instr),
+	binaryGenTemplateHelper(FuncInstrCount, (*OP).InstrCount),
+	{
+		name: FuncSubstrIndex,
+		min:  3,
+		max:  3,
+		f: func(x ...*OP) (*OP, error) {
+			return x[0].SubstrIndex(x[1], x[2])
+		},
+	},
+	{
+		name: FuncReplace,
+		min:  3,
+		max:  3,
+		f: func(x ...*OP) (*OP, error) {
+			return x[0].Replace(x[1], x[2])
+		},
+	},
+	{
+		name: FuncNow,
+		min:  0,
+		max:  0,
+		f: func(...*OP) (*OP, error) {
+			return node.Now(), nil
+		},
+	},
+}
+
+// dataFromAny converts the Go value into the node data.
+func dataFromAny(v any) (ND, error) {
+	switch v := v.(type) {
+	case nil:
+		return node.NewNull(), nil
+	case ND:
+		return v, nil
+	case bool:
+		return node.Bool(v), nil
+	case int:
+		return node.Int(int64(v)), nil
+	case int8:
+		return node.Int(int64(v)), nil
+	case int16:
+		return node.Int(int64(v)), nil
+	case int32:
+		return node.Int(int64(v)), nil
+	case int64:
+		return node.Int(v), nil
+	case uint:
+		return intFromUint(uint64(v))
+	case uint8:
+		return node.Int(int64(v)), nil
+	case uint16:
+		return node.Int(int64(v)), nil
+	case uint32:
+		return node.Int(int64(v)), nil
+	case uint64:
+		return intFromUint(v)
+	case float32:
+		return node.Float(float64(v)), nil
+	case float64:
+		return node.Float(v), nil
+	case string:
+		return node.String(v), nil
+	case time.Time:
+		return node.Time(v), nil
+	case time.Duration:
+		return node.Duration(v), nil
+	default:
+		return nil, fmt.Errorf("%w: cannot convert %T into node data", ErrInvalidValue, v)
+	}
+}
+
+// intFromUint converts v into Int, or fails if v overflows Int.
+func intFromUint(v uint64) (ND, error) {
+	if v > math.MaxInt64 {
+		return nil, fmt.Errorf("%w: %d overflows Int", ErrInvalidValue, v)
+	}
+	return node.Int(int64(v)), nil
+}
+
+// nodeFromMap converts the map into the node.
+// Nested maps are converted into table-qualified keys, nil values are ignored.
+func nodeFromMap(m map[string]any) (*N, error) {
+	n := node.New()
+	set := func(key *Key, v any) error {
+		if v == nil {
+			return nil
+		}
+		d, err := dataFromAny(v)
+		if err != nil {
+			return fmt.Errorf("%w: key %s", err, key.Name())
+		}
+		n.Set(key.String(), d)
+		return nil
+	}
+	for k, v := range m {
+		sm, ok := v.(map[string]any)
+		if !ok {
+			if err := set(NewKey("", k), v); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for sk, sv := range sm {
+			if err := set(NewKey(k, sk), sv); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// nodesFromAny converts the result of the generator into nodes.
+//
+//   - nil: no nodes
+//   - map: a node
+//   - list of maps: nodes
+//   - other scalar: a node that has the value in the key
+//
+// ok is false if the value is a string, that should be parsed by ParseGenResult.
+func nodesFromAny(v any, key string) ([]*N, bool, error) {
+	switch v := v.(type) {
+	case nil:
+		return []*N{}, true, nil
+	case string:
+		return nil, false, nil
+	case map[string]any:
+		n, err := nodeFromMap(v)
+		if err != nil {
+			return nil, true, err
+		}
+		return []*N{n}, true, nil
+	case []any:
+		r := make([]*N, len(v))
+		for i, x := range v {
+			m, ok := x.(map[string]any)
+			if !ok {
+				return nil, true, fmt.Errorf("%w: list should contain maps, index %d is %T", ErrInvalidValue, i, x)
+			}
+			n, err := nodeFromMap(m)
+			if err != nil {
+				return nil, true, err
+			}
+			r[i] = n
+		}
+		return r, true, nil
+	default:
+		d, err := dataFromAny(v)
+		if err != nil {
+			return nil, true, err
+		}
+		n := node.New()
+		n.Set(key, d)
+		return []*N{n}, true, nil
+	}
+}
@@ -0,0 +1,74 @@
+package tree
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/berquerant/ndql/pkg/iox"
+	"github.com/berquerant/ndql/pkg/node"
+)
+
+//
+// lines gen template
+//
+// lines(path)
+
+const (
+	LinesKeyLineNo = "line_no"
+	LinesKeyLine   = "line"
+)
+
+type LinesGenTemplate struct {
+	path string
+}
+
+func NewLinesGenTemplate(path string) *LinesGenTemplate {
+	return &LinesGenTemplate{
+		path: path,
+	}
+}
+
+var _ NodeGenTemplate = &LinesGenTemplate{}
+
+func (g LinesGenTemplate) Generate(ctx context.Context, n *N) ([]byte, error) {
+	ns, err := g.GenerateNodes(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ns)
+}
+
+func (g LinesGenTemplate) GenerateNodes(ctx context.Context, _ *N) ([]*N, error) {
+	f, err := iox.ContentCacheFromContext(ctx).Open(g.path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open content to read lines, file=%s", errors.Join(ErrGenTemplate, err), g.path)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var (
+		r      = bufio.NewReader(f)
+		result = []*N{}
+	)
+	for i := 1; ; i++ {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			result = append(result, node.FromMap(map[string]node.Data{
+				LinesKeyLineNo: node.Int(i),
+				LinesKeyLine:   node.String(strings.TrimRight(line, "\r\n")),
+			}))
+		}
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read lines, file=%s", errors.Join(ErrGenTemplate, err), g.path)
+		}
+	}
+}
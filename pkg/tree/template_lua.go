@@ -2,11 +2,12 @@ package tree
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"time"
 
 	"github.com/berquerant/ndql/pkg/cachex"
+	"github.com/berquerant/ndql/pkg/node"
 	"github.com/berquerant/ndql/pkg/util"
 	lua "github.com/yuin/gopher-lua"
 )
@@ -17,9 +18,10 @@ import (
 // lua(script, entrypoint)
 //
 // The function specified in entrypoint must be defined within the script,
-// take exactly one argument, and return a string.
+// take exactly one argument, and return a string, a table or a list of tables.
 // The argument passed to this function is a node.
-// Additionally, a global table E is defined, which contains the environment variables from os.Environ.
+// Additionally, a global table E is defined, which contains the environment variables from os.Environ,
+// and a global table N is defined, which provides accessors to the node.
 
 type LuaGenTemplate struct {
 	script     string
@@ -33,101 +35,98 @@ func NewLuaGenTemplate(script, entrypoint string) *LuaGenTemplate {
 	}
 }
 
-var _ GenTemplate = &LuaGenTemplate{}
+var _ NodeGenTemplate = &LuaGenTemplate{}
 
 const (
-	luaGenTemplateGlobalEnvTable = "E"
-	// luaGenTemplateGlobalNodeTable = "N"
+	luaGenTemplateGlobalEnvTable  = "E"
+	luaGenTemplateGlobalNodeTable = "N"
 )
 
 var luaGenTemplateCache = util.Must(cachex.NewLuaCache())
 
 func (g LuaGenTemplate) Generate(ctx context.Context, n *N) ([]byte, error) {
-	proto, err := luaGenTemplateCache.Get(g.script)
-	if err != nil {
-		return nil, fmt.Errorf("%w: failed to compile lua", errors.Join(ErrGenTemplate, err))
-	}
+	var r []byte
+	err := g.call(ctx, n, func(v lua.LValue) error {
+		ns, ok, err := lvalueToNodes(v)
+		if err != nil {
+			return fmt.Errorf("%w: failed to retrieve lua return value", errors.Join(ErrGenTemplate, err))
+		}
+		if !ok {
+			r = []byte(v.String())
+			return nil
+		}
+		b, err := json.Marshal(ns)
+		if err != nil {
+			return fmt.Errorf("%w: failed to marshal lua return value", errors.Join(ErrGenTemplate, err))
+		}
+		r = b
+		return nil
+	})
+	return r, err
+}
 
-	state := lua.NewState()
-	defer state.Close()
-	state.SetContext(ctx)
-	state.OpenLibs()
-	g.registerGlobalTables(state, n)
+func (g LuaGenTemplate) GenerateNodes(ctx context.Context, n *N) ([]*N, error) {
+	var r []*N
+	err := g.call(ctx, n, func(v lua.LValue) error {
+		ns, ok, err := lvalueToNodes(v)
+		if err != nil {
+			return fmt.Errorf("%w: failed to retrieve lua return value", errors.Join(ErrGenTemplate, err))
+		}
+		if !ok {
+			ns, err = ParseGenResult([]byte(v.String()))
+			if err != nil {
+				return err
+			}
+		}
+		r = ns
+		return nil
+	})
+	return r, err
+}
 
-	state.Push(state.NewFunctionFromProto(proto))
-	if err := state.PCall(0, 0, nil); err != nil {
-		return nil, fmt.Errorf("%w: failed to call lua proto", errors.Join(ErrGenTemplate, err))
-	}
+// Value calls the entrypoint and returns the result as a single value.
+func (g LuaGenTemplate) Value(ctx context.Context, n *N) (ND, error) {
+	var r ND
+	err := g.call(ctx, n, func(v lua.LValue) error {
+		if v == lua.LNil {
+			r = node.NewNull()
+			return nil
+		}
+		d, err := lvalueToData(v)
+		if err != nil {
+			return fmt.Errorf("%w: failed to retrieve lua return value", errors.Join(ErrGenTemplate, err))
+		}
+		r = d
+		return nil
+	})
+	return r, err
+}
 
-	if err := state.CallByParam(lua.P{
-		Fn:      state.GetGlobal(g.entrypoint),
+// call calls the entrypoint and passes the return value to f.
+func (g LuaGenTemplate) call(ctx context.Context, n *N, f func(lua.LValue) error) error {
+	pool := luaStatePoolFromContext(ctx)
+	s, err := pool.get(ctx, g.script)
+	if err != nil {
+		return err
+	}
+	var reusable bool
+	defer func() {
+		pool.put(g.script, s, reusable)
+	}()
+
+	s.state.SetContext(ctx)
+	defer s.state.RemoveContext()
+	s.node = n
+	if err := s.state.CallByParam(lua.P{
+		Fn:      s.state.GetGlobal(g.entrypoint),
 		NRet:    1,
 		Protect: true,
-	}, g.mapToLTable(state, NodeAsStructuredMap(n))); err != nil {
-		return nil, fmt.Errorf("%w: failed to call lua script", errors.Join(ErrGenTemplate, err))
+	}, nodeToLTable(s.state, n)); err != nil {
+		return fmt.Errorf("%w: failed to call lua script", errors.Join(ErrGenTemplate, err))
 	}
+	reusable = true
 
-	lRet := state.Get(-1)
-	state.Pop(1)
-	lStr, ok := lRet.(lua.LString)
-	if !ok {
-		return nil, fmt.Errorf("%w: failed to retrieve lua return value", ErrGenTemplate)
-	}
-
-	return []byte(lStr.String()), nil
-}
-
-func (LuaGenTemplate) registerGlobalTables(state *lua.LState, n *N) {
-	//
-	// register node table
-	//
-	// nt := state.NewTypeMetatable(luaGenTemplateGlobalNodeTable)
-	// state.SetGlobal(luaGenTemplateGlobalNodeTable, nt)
-	// state.SetField(nt, "get", state.NewFunction(func(state *lua.LState) int {
-	// 	key := state.CheckString(1)
-	// 	v := state.OptString(2, "")
-	// 	value := genTemplateGetOr(n, key, v)
-	// 	state.Push(lua.LString(value))
-	// 	return 1
-	// }))
-
-	//
-	// register env table
-	//
-	et := state.NewTypeMetatable(luaGenTemplateGlobalEnvTable)
-	state.SetGlobal(luaGenTemplateGlobalEnvTable, et)
-	state.SetField(et, "get", state.NewFunction(func(state *lua.LState) int {
-		key := state.CheckString(1)
-		v := state.OptString(2, "")
-		value := genTemplateEnvOr(key, v)
-		state.Push(lua.LString(value))
-		return 1
-	}))
-}
-
-func (g LuaGenTemplate) mapToLTable(state *lua.LState, d map[string]any) *lua.LTable {
-	t := state.NewTable()
-	for k, v := range d {
-		var lVal lua.LValue
-		switch v := v.(type) {
-		case float64:
-			lVal = lua.LNumber(v)
-		case int64:
-			lVal = lua.LNumber(v)
-		case bool:
-			lVal = lua.LBool(v)
-		case string:
-			lVal = lua.LString(v)
-		case time.Time:
-			lVal = lua.LString(v.Format(time.DateTime))
-		case time.Duration:
-			lVal = lua.LString(v.String())
-		case map[string]any:
-			lVal = g.mapToLTable(state, v)
-		default:
-			continue
-		}
-		state.SetField(t, k, lVal)
-	}
-	return t
+	lRet := s.state.Get(-1)
+	s.state.Pop(1)
+	return f(lRet)
 }
@@ -0,0 +1,262 @@
+package tree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sync"
+
+	"github.com/berquerant/ndql/pkg/logx"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// LuaStatePool keeps initialized lua states per script to skip loading libraries and executing scripts for every node.
+//
+// Globals are restored to the state just after executing the script when a state is returned to the pool,
+// so assignments to globals and to fields of tables reachable from globals do not leak into the next call.
+type LuaStatePool struct {
+	size   int
+	mux    sync.Mutex
+	closed bool
+	pools  map[string]chan *luaState
+}
+
+// NewLuaStatePool returns a new pool that keeps at most size idle states per script.
+func NewLuaStatePool(size int) *LuaStatePool {
+	return &LuaStatePool{
+		size:  max(1, size),
+		pools: map[string]chan *luaState{},
+	}
+}
+
+type luaStatePoolContextKey struct{}
+
+// WithLuaStatePool returns a context that runs lua scripts with the states from p.
+func WithLuaStatePool(ctx context.Context, p *LuaStatePool) context.Context {
+	return context.WithValue(ctx, luaStatePoolContextKey{}, p)
+}
+
+var defaultLuaStatePool = NewLuaStatePool(runtime.GOMAXPROCS(0))
+
+func luaStatePoolFromContext(ctx context.Context) *LuaStatePool {
+	if p, ok := ctx.Value(luaStatePoolContextKey{}).(*LuaStatePool); ok {
+		return p
+	}
+	return defaultLuaStatePool
+}
+
+// Close closes the idle states.
+// States in use are closed when they are returned.
+func (p *LuaStatePool) Close() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.closed = true
+	for _, c := range p.pools {
+		close(c)
+		for s := range c {
+			s.close()
+		}
+	}
+	p.pools = map[string]chan *luaState{}
+}
+
+func (p *LuaStatePool) pool(script string) (chan *luaState, bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.closed {
+		return nil, false
+	}
+	c, ok := p.pools[script]
+	if !ok {
+		c = make(chan *luaState, p.size)
+		p.pools[script] = c
+	}
+	return c, true
+}
+
+// get returns an idle state of the script, or a new state if there is none.
+func (p *LuaStatePool) get(ctx context.Context, script string) (*luaState, error) {
+	if c, ok := p.pool(script); ok {
+		select {
+		case s, ok := <-c:
+			if ok {
+				return s, nil
+			}
+		default:
+		}
+	}
+	return newLuaState(ctx, script)
+}
+
+// put returns the state to the pool.
+// The state is closed if it is not reusable or the pool is full.
+func (p *LuaStatePool) put(script string, s *luaState, reusable bool) {
+	if !reusable {
+		s.close()
+		return
+	}
+	s.reset()
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if c, ok := p.pools[script]; ok && !p.closed {
+		select {
+		case c <- s:
+			return
+		default:
+		}
+	}
+	s.close()
+}
+
+type luaState struct {
+	state     *lua.LState
+	node      *N // the current node
+	snapshots []*luaTableSnapshot
+}
+
+func newLuaState(ctx context.Context, script string) (*luaState, error) {
+	proto, err := luaGenTemplateCache.Get(script)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to compile lua", errors.Join(ErrGenTemplate, err))
+	}
+
+	s := &luaState{
+		state: lua.NewState(),
+	}
+	s.state.SetContext(ctx)
+	defer s.state.RemoveContext()
+	s.state.OpenLibs()
+	registerLuaTypes(s.state)
+	s.registerGlobalTables()
+
+	s.state.Push(s.state.NewFunctionFromProto(proto))
+	if err := s.state.PCall(0, 0, nil); err != nil {
+		s.close()
+		return nil, fmt.Errorf("%w: failed to call lua proto", errors.Join(ErrGenTemplate, err))
+	}
+	s.snapshots = snapshotLuaTables(s.state.G.Global)
+	logx.Trace("new lua state", slog.Int("tables", len(s.snapshots)))
+	return s, nil
+}
+
+func (s *luaState) close() { s.state.Close() }
+
+// reset restores the globals and clears the stack.
+func (s *luaState) reset() {
+	for _, x := range s.snapshots {
+		x.restore()
+	}
+	s.state.SetTop(0)
+	s.node = nil
+}
+
+func (s *luaState) registerGlobalTables() {
+	state := s.state
+
+	//
+	// register node table
+	//
+	nt := state.NewTypeMetatable(luaGenTemplateGlobalNodeTable)
+	state.SetGlobal(luaGenTemplateGlobalNodeTable, nt)
+	state.SetField(nt, "get", state.NewFunction(func(state *lua.LState) int {
+		key := state.CheckString(1)
+		if s.node != nil {
+			if r, ok := KeyFromName(key).Get(s.node); ok {
+				for _, v := range r.Unwrap() {
+					state.Push(dataToLValue(state, v))
+					return 1
+				}
+			}
+		}
+		state.Push(state.Get(2))
+		return 1
+	}))
+
+	//
+	// register env table
+	//
+	et := state.NewTypeMetatable(luaGenTemplateGlobalEnvTable)
+	state.SetGlobal(luaGenTemplateGlobalEnvTable, et)
+	state.SetField(et, "get", state.NewFunction(func(state *lua.LState) int {
+		key := state.CheckString(1)
+		v := state.OptString(2, "")
+		value := genTemplateEnvOr(key, v)
+		state.Push(lua.LString(value))
+		return 1
+	}))
+}
+
+// luaTableSnapshot is the contents of a table at some point.
+type luaTableSnapshot struct {
+	table   *lua.LTable
+	entries map[lua.LValue]lua.LValue
+}
+
+// snapshotLuaTables records the contents of the table and the tables reachable from it.
+func snapshotLuaTables(root *lua.LTable) []*luaTableSnapshot {
+	var (
+		r       []*luaTableSnapshot
+		visited = map[*lua.LTable]bool{}
+		queue   = []*lua.LTable{root}
+	)
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		if visited[t] {
+			continue
+		}
+		visited[t] = true
+
+		x := &luaTableSnapshot{
+			table:   t,
+			entries: map[lua.LValue]lua.LValue{},
+		}
+		t.ForEach(func(k, v lua.LValue) {
+			x.entries[k] = v
+			if st, ok := v.(*lua.LTable); ok {
+				queue = append(queue, st)
+			}
+		})
+		r = append(r, x)
+	}
+	return r
+}
+
+// restore restores the contents of the table, touching only the changed entries.
+func (x *luaTableSnapshot) restore() {
+	var (
+		seen    int
+		changed []lua.LValue
+	)
+	x.table.ForEach(func(k, v lua.LValue) {
+		w, ok := x.entries[k]
+		if ok {
+			seen++
+		}
+		if !ok || w != v {
+			changed = append(changed, k)
+		}
+	})
+	for _, k := range changed {
+		x.table.RawSet(k, x.valueOf(k))
+	}
+	if seen == len(x.entries) {
+		return
+	}
+	// some entries are removed
+	for k, v := range x.entries {
+		if x.table.RawGet(k) == lua.LNil {
+			x.table.RawSet(k, v)
+		}
+	}
+}
+
+func (x *luaTableSnapshot) valueOf(k lua.LValue) lua.LValue {
+	if v, ok := x.entries[k]; ok {
+		return v
+	}
+	return lua.LNil
+}
@@ -0,0 +1,346 @@
+package tree
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/berquerant/ndql/pkg/node"
+	lua "github.com/yuin/gopher-lua"
+)
+
+//
+// conversion between nodes and lua values
+//
+// Time and Duration are passed as userdata with methods:
+//
+//	t:unix(), t:unix_milli(), t:format(layout), t:year(), t:month(), t:day(),
+//	t:hour(), t:minute(), t:second(), t:add(d), t:sub(t2)
+//	d:seconds(), d:milliseconds(), d:nanoseconds()
+//
+// Both support tostring, concatenation and comparison.
+
+const (
+	luaTypeTime     = "ndql.time"
+	luaTypeDuration = "ndql.duration"
+)
+
+func registerLuaTypes(state *lua.LState) {
+	tm := state.NewTypeMetatable(luaTypeTime)
+	state.SetField(tm, "__index", state.SetFuncs(state.NewTable(), map[string]lua.LGFunction{
+		"unix": func(state *lua.LState) int {
+			state.Push(lua.LNumber(checkLuaTime(state, 1).Unix()))
+			return 1
+		},
+		"unix_milli": func(state *lua.LState) int {
+			state.Push(lua.LNumber(checkLuaTime(state, 1).UnixMilli()))
+			return 1
+		},
+		"format": func(state *lua.LState) int {
+			t := checkLuaTime(state, 1)
+			state.Push(lua.LString(t.Format(state.OptString(2, time.DateTime))))
+			return 1
+		},
+		"year":   luaTimeIntMethod(func(t time.Time) int { return t.Year() }),
+		"month":  luaTimeIntMethod(func(t time.Time) int { return int(t.Month()) }),
+		"day":    luaTimeIntMethod(func(t time.Time) int { return t.Day() }),
+		"hour":   luaTimeIntMethod(func(t time.Time) int { return t.Hour() }),
+		"minute": luaTimeIntMethod(func(t time.Time) int { return t.Minute() }),
+		"second": luaTimeIntMethod(func(t time.Time) int { return t.Second() }),
+		"add": func(state *lua.LState) int {
+			t := checkLuaTime(state, 1)
+			state.Push(newLuaTime(state, t.Add(checkLuaDuration(state, 2))))
+			return 1
+		},
+		"sub": func(state *lua.LState) int {
+			t := checkLuaTime(state, 1)
+			state.Push(newLuaDuration(state, t.Sub(checkLuaTime(state, 2))))
+			return 1
+		},
+	}))
+	state.SetFuncs(tm, luaCommonMetamethods(func(a, b any) (int, bool) {
+		x, ok1 := a.(time.Time)
+		y, ok2 := b.(time.Time)
+		if !ok1 || !ok2 {
+			return 0, false
+		}
+		return x.Compare(y), true
+	}))
+
+	dm := state.NewTypeMetatable(luaTypeDuration)
+	state.SetField(dm, "__index", state.SetFuncs(state.NewTable(), map[string]lua.LGFunction{
+		"seconds": func(state *lua.LState) int {
+			state.Push(lua.LNumber(checkLuaDuration(state, 1).Seconds()))
+			return 1
+		},
+		"milliseconds": func(state *lua.LState) int {
+			state.Push(lua.LNumber(checkLuaDuration(state, 1).Milliseconds()))
+			return 1
+		},
+		"nanoseconds": func(state *lua.LState) int {
+			state.Push(lua.LNumber(checkLuaDuration(state, 1).Nanoseconds()))
+			return 1
+		},
+	}))
+	state.SetFuncs(dm, luaCommonMetamethods(func(a, b any) (int, bool) {
+		x, ok1 := a.(time.Duration)
+		y, ok2 := b.(time.Duration)
+		if !ok1 || !ok2 {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		default:
+			return 0, true
+		}
+	}))
+}
+
+func luaTimeIntMethod(f func(time.Time) int) lua.LGFunction {
+	return func(state *lua.LState) int {
+		state.Push(lua.LNumber(f(checkLuaTime(state, 1))))
+		return 1
+	}
+}
+
+func luaCommonMetamethods(compare func(a, b any) (int, bool)) map[string]lua.LGFunction {
+	cmp := func(state *lua.LState) int {
+		c, ok := compare(state.CheckUserData(1).Value, state.CheckUserData(2).Value)
+		if !ok {
+			state.RaiseError("cannot compare %s with %s", state.Get(1).Type(), state.Get(2).Type())
+		}
+		return c
+	}
+	return map[string]lua.LGFunction{
+		"__tostring": func(state *lua.LState) int {
+			state.Push(lua.LString(luaValueString(state.Get(1))))
+			return 1
+		},
+		"__concat": func(state *lua.LState) int {
+			state.Push(lua.LString(luaValueString(state.Get(1)) + luaValueString(state.Get(2))))
+			return 1
+		},
+		"__eq": func(state *lua.LState) int {
+			state.Push(lua.LBool(cmp(state) == 0))
+			return 1
+		},
+		"__lt": func(state *lua.LState) int {
+			state.Push(lua.LBool(cmp(state) < 0))
+			return 1
+		},
+		"__le": func(state *lua.LState) int {
+			state.Push(lua.LBool(cmp(state) <= 0))
+			return 1
+		},
+	}
+}
+
+func luaValueString(v lua.LValue) string {
+	if ud, ok := v.(*lua.LUserData); ok {
+		switch x := ud.Value.(type) {
+		case time.Time:
+			return x.Format(time.DateTime)
+		case time.Duration:
+			return x.String()
+		}
+	}
+	return lua.LVAsString(v)
+}
+
+func newLuaTime(state *lua.LState, t time.Time) *lua.LUserData {
+	ud := state.NewUserData()
+	ud.Value = t
+	state.SetMetatable(ud, state.GetTypeMetatable(luaTypeTime))
+	return ud
+}
+
+func newLuaDuration(state *lua.LState, d time.Duration) *lua.LUserData {
+	ud := state.NewUserData()
+	ud.Value = d
+	state.SetMetatable(ud, state.GetTypeMetatable(luaTypeDuration))
+	return ud
+}
+
+func checkLuaTime(state *lua.LState, n int) time.Time {
+	if t, ok := state.CheckUserData(n).Value.(time.Time); ok {
+		return t
+	}
+	state.ArgError(n, "time expected")
+	return time.Time{}
+}
+
+func checkLuaDuration(state *lua.LState, n int) time.Duration {
+	if d, ok := state.CheckUserData(n).Value.(time.Duration); ok {
+		return d
+	}
+	state.ArgError(n, "duration expected")
+	return 0
+}
+
+// dataToLValue converts the node data into the lua value.
+func dataToLValue(state *lua.LState, d ND) lua.LValue {
+	switch d := d.(type) {
+	case node.Float:
+		return lua.LNumber(d.Raw())
+	case node.Int:
+		return lua.LNumber(d.Raw())
+	case node.Bool:
+		return lua.LBool(d.Raw())
+	case node.String:
+		return lua.LString(d.Raw())
+	case node.Time:
+		return newLuaTime(state, d.Raw())
+	case node.Duration:
+		return newLuaDuration(state, d.Raw())
+	default:
+		return lua.LNil
+	}
+}
+
+// nodeToLTable converts the node into the lua table.
+// Table-qualified keys like table.column are converted into nested tables.
+func nodeToLTable(state *lua.LState, n *N) *lua.LTable {
+	var (
+		t    = state.NewTable()
+		keys = n.Keys()
+	)
+	// set columns of the default table first so that tables take precedence
+	sort.SliceStable(keys, func(i, j int) bool {
+		return KeyFromString(keys[i]).Table == "" && KeyFromString(keys[j]).Table != ""
+	})
+	for _, k := range keys {
+		d, _ := n.Get(k)
+		v := dataToLValue(state, d)
+		key := KeyFromString(k)
+		if key.Table == "" {
+			t.RawSetString(k, v)
+			continue
+		}
+		st, ok := t.RawGetString(key.Table).(*lua.LTable)
+		if !ok {
+			st = state.NewTable()
+			t.RawSetString(key.Table, st)
+		}
+		st.RawSetString(key.Column, v)
+	}
+	return t
+}
+
+// lvalueToData converts the lua value into the node data.
+// Integral numbers become Int, other numbers Float.
+func lvalueToData(v lua.LValue) (ND, error) {
+	switch v := v.(type) {
+	case lua.LBool:
+		return node.Bool(bool(v)), nil
+	case lua.LNumber:
+		f := float64(v)
+		if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return node.Int(int64(f)), nil
+		}
+		return node.Float(f), nil
+	case lua.LString:
+		return node.String(string(v)), nil
+	case *lua.LUserData:
+		switch x := v.Value.(type) {
+		case time.Time:
+			return node.Time(x), nil
+		case time.Duration:
+			return node.Duration(x), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: cannot convert lua %s into node data", ErrInvalidValue, v.Type())
+}
+
+// ltableToNode converts the lua table into the node.
+// Nested tables are converted into table-qualified keys, nil values are ignored.
+func ltableToNode(t *lua.LTable) (*N, error) {
+	var (
+		n   = node.New()
+		err error
+	)
+	setValue := func(key *Key, v lua.LValue) {
+		d, e := lvalueToData(v)
+		if e != nil {
+			err = fmt.Errorf("%w: key %s", e, key.Name())
+			return
+		}
+		n.Set(key.String(), d)
+	}
+	t.ForEach(func(k, v lua.LValue) {
+		if err != nil {
+			return
+		}
+		column, ok := k.(lua.LString)
+		if !ok {
+			err = fmt.Errorf("%w: lua table key should be string, got %s", ErrInvalidKey, k.Type())
+			return
+		}
+		st, ok := v.(*lua.LTable)
+		if !ok {
+			setValue(NewKey("", string(column)), v)
+			return
+		}
+		st.ForEach(func(sk, sv lua.LValue) {
+			if err != nil {
+				return
+			}
+			sc, ok := sk.(lua.LString)
+			if !ok {
+				err = fmt.Errorf("%w: lua table key should be string, got %s", ErrInvalidKey, sk.Type())
+				return
+			}
+			setValue(NewKey(string(column), string(sc)), sv)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// lvalueToNodes converts the return value of the lua entrypoint into nodes.
+//
+//   - nil: no nodes
+//   - table: a node
+//   - list of tables: nodes
+//
+// ok is false if the value is a string, that should be parsed by ParseGenResult.
+func lvalueToNodes(v lua.LValue) ([]*N, bool, error) {
+	switch v := v.(type) {
+	case *lua.LNilType:
+		return []*N{}, true, nil
+	case lua.LString:
+		return nil, false, nil
+	case *lua.LTable:
+		if v.MaxN() == 0 {
+			if k, _ := v.Next(lua.LNil); k == lua.LNil {
+				// empty table
+				return []*N{}, true, nil
+			}
+			n, err := ltableToNode(v)
+			if err != nil {
+				return nil, true, err
+			}
+			return []*N{n}, true, nil
+		}
+		r := make([]*N, v.MaxN())
+		for i := range v.MaxN() {
+			t, ok := v.RawGetInt(i + 1).(*lua.LTable)
+			if !ok {
+				return nil, true, fmt.Errorf("%w: lua list should contain tables, index %d", ErrInvalidValue, i+1)
+			}
+			n, err := ltableToNode(t)
+			if err != nil {
+				return nil, true, err
+			}
+			r[i] = n
+		}
+		return r, true, nil
+	default:
+		return nil, true, fmt.Errorf("%w: lua entrypoint should return string, table or list of tables, got %s", ErrInvalidValue, v.Type())
+	}
+}
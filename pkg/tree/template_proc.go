@@ -0,0 +1,373 @@
+package tree
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/berquerant/ndql/pkg/logx"
+)
+
+//
+// coprocess gen template
+//
+// proc(command)
+// proc(command, option)
+//
+// The command is started once and kept running across nodes.
+// Each node is written to stdin as a line of JSON,
+// and each request reads exactly one line from stdout that is parsed by ParseGenResult.
+
+// ProcOption is the option of proc.
+type ProcOption struct {
+	// Interpreter to run the command, bash by default.
+	Shell string
+	// Timeout of a request, 0 means no timeout.
+	Timeout time.Duration
+}
+
+// ParseProcOption parses a comma-separated list of options like "shell=sh,timeout=10s".
+//
+//   - shell=NAME: interpreter to run the command
+//   - timeout=DURATION: kill the command if it does not respond within the duration
+func ParseProcOption(s string) (*ProcOption, error) {
+	opt := ProcOption{
+		Shell: defaultShell,
+	}
+	for _, x := range parseTemplateOptions(s) {
+		switch x.key {
+		case "shell":
+			if x.value == "" {
+				return nil, fmt.Errorf("%w: proc option shell requires name", ErrInvalidArgument)
+			}
+			opt.Shell = x.value
+		case "timeout":
+			d, err := time.ParseDuration(x.value)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("%w: proc option timeout requires non-negative Duration", errors.Join(ErrInvalidArgument, err))
+			}
+			opt.Timeout = d
+		default:
+			return nil, fmt.Errorf("%w: unknown proc option %s", ErrInvalidArgument, x.key)
+		}
+	}
+	return &opt, nil
+}
+
+type ProcGenTemplate struct {
+	command string
+	opt     *ProcOption
+}
+
+func NewProcGenTemplate(command string) *ProcGenTemplate {
+	return NewProcGenTemplateWithOption(command, &ProcOption{
+		Shell: defaultShell,
+	})
+}
+
+func NewProcGenTemplateWithOption(command string, opt *ProcOption) *ProcGenTemplate {
+	return &ProcGenTemplate{
+		command: command,
+		opt:     opt,
+	}
+}
+
+var _ GenTemplate = &ProcGenTemplate{}
+
+func (g ProcGenTemplate) Generate(ctx context.Context, n *N) ([]byte, error) {
+	input, err := json.Marshal(n)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to marshal node", errors.Join(ErrGenTemplate, err))
+	}
+	p := procPoolFromContext(ctx)
+	c, reused, err := p.get(g.opt.Shell, g.command)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to start proc %s", errors.Join(ErrGenTemplate, err), g.command)
+	}
+	r, err := c.request(ctx, input, g.opt.Timeout)
+	p.put(c, err == nil)
+	if reused && errors.Is(err, errProcExited) {
+		// the idle coprocess crashed, restart
+		if c, err = newCoprocess(c.key); err != nil {
+			return nil, fmt.Errorf("%w: failed to restart proc %s", errors.Join(ErrGenTemplate, err), g.command)
+		}
+		r, err = c.request(ctx, input, g.opt.Timeout)
+		p.put(c, err == nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: proc %s", errors.Join(ErrGenTemplate, err), g.command)
+	}
+	return r, nil
+}
+
+// ProcPool keeps running coprocesses per command to skip starting the command for every node.
+//
+// A coprocess is discarded when it fails to respond, so the next request starts a new one.
+type ProcPool struct {
+	size   int
+	mux    sync.Mutex
+	closed bool
+	pools  map[procKey]chan *coprocess
+}
+
+// NewProcPool returns a new pool that keeps at most size idle coprocesses per command.
+func NewProcPool(size int) *ProcPool {
+	return &ProcPool{
+		size:  max(1, size),
+		pools: map[procKey]chan *coprocess{},
+	}
+}
+
+type procPoolContextKey struct{}
+
+// WithProcPool returns a context that runs proc commands with the coprocesses from p.
+func WithProcPool(ctx context.Context, p *ProcPool) context.Context {
+	return context.WithValue(ctx, procPoolContextKey{}, p)
+}
+
+var defaultProcPool = NewProcPool(runtime.GOMAXPROCS(0))
+
+func procPoolFromContext(ctx context.Context) *ProcPool {
+	if p, ok := ctx.Value(procPoolContextKey{}).(*ProcPool); ok {
+		return p
+	}
+	return defaultProcPool
+}
+
+type procKey struct {
+	shell   string
+	command string
+}
+
+// Close stops the idle coprocesses.
+// Coprocesses in use are stopped when they are returned.
+func (p *ProcPool) Close() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.closed = true
+	var wg sync.WaitGroup
+	for _, c := range p.pools {
+		close(c)
+		for x := range c {
+			wg.Go(x.stop)
+		}
+	}
+	wg.Wait()
+	p.pools = map[procKey]chan *coprocess{}
+}
+
+func (p *ProcPool) pool(key procKey) (chan *coprocess, bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.closed {
+		return nil, false
+	}
+	c, ok := p.pools[key]
+	if !ok {
+		c = make(chan *coprocess, p.size)
+		p.pools[key] = c
+	}
+	return c, true
+}
+
+// get returns an idle coprocess of the command, or starts a new one if there is none.
+// reused is true if the coprocess is from the pool.
+func (p *ProcPool) get(shell, command string) (x *coprocess, reused bool, err error) {
+	key := procKey{
+		shell:   shell,
+		command: command,
+	}
+	if c, ok := p.pool(key); ok {
+	loop:
+		for {
+			select {
+			case x, ok := <-c:
+				if !ok {
+					break loop
+				}
+				if x.exited() {
+					logx.Trace("proc exited", slog.String("command", command), logx.Err(x.err))
+					x.kill()
+					continue
+				}
+				return x, true, nil
+			default:
+				break loop
+			}
+		}
+	}
+	x, err = newCoprocess(key)
+	return x, false, err
+}
+
+// put returns the coprocess to the pool.
+// The coprocess is killed if it is not reusable, or stopped if the pool is full.
+func (p *ProcPool) put(x *coprocess, reusable bool) {
+	if !reusable {
+		x.kill()
+		return
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if c, ok := p.pools[x.key]; ok && !p.closed {
+		select {
+		case c <- x:
+			return
+		default:
+		}
+	}
+	x.stop()
+}
+
+var errProcExited = errors.New("proc exited")
+
+// coprocess is a running command.
+type coprocess struct {
+	key     procKey
+	cmd     *exec.Cmd
+	cancel  context.CancelFunc
+	stdin   io.WriteCloser
+	lines   chan []byte   // lines of stdout, closed on EOF
+	done    chan struct{} // closed when the command exited
+	stopped chan struct{} // closed when the coprocess is stopped
+	once    sync.Once
+	err     error // the result of the command, available after done
+}
+
+func newCoprocess(key procKey) (*coprocess, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, key.shell, "-c", key.command)
+	cmd.Stderr = os.Stderr
+	setProcessGroup(cmd)
+	// do not wait for the children holding the pipes after the kill
+	cmd.WaitDelay = time.Second
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, err
+	}
+	slog.Debug("proc started", slog.String("command", key.command), slog.Int("pid", cmd.Process.Pid))
+
+	x := &coprocess{
+		key:     key,
+		cmd:     cmd,
+		cancel:  cancel,
+		stdin:   stdin,
+		lines:   make(chan []byte),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go x.read(stdout)
+	return x, nil
+}
+
+// read sends the lines of stdout until EOF, then waits for the command.
+func (x *coprocess) read(stdout io.Reader) {
+	defer close(x.done)
+	r := bufio.NewReader(stdout)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			select {
+			case x.lines <- line:
+			case <-x.stopped:
+				// discard the rest
+				_, _ = io.Copy(io.Discard, r)
+				err = io.EOF
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	close(x.lines)
+	x.err = x.cmd.Wait()
+}
+
+func (x *coprocess) exited() bool {
+	select {
+	case <-x.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// request writes the input as a line and reads a line as the response.
+func (x *coprocess) request(ctx context.Context, input []byte, timeout time.Duration) ([]byte, error) {
+	var timeoutC <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timeoutC = t.C
+	}
+
+	writeC := make(chan error, 1)
+	go func() {
+		_, err := x.stdin.Write(append(input, '\n'))
+		writeC <- err
+	}()
+
+	for {
+		select {
+		case err := <-writeC:
+			if err != nil {
+				return nil, fmt.Errorf("%w: failed to write request: %w", errProcExited, err)
+			}
+			writeC = nil
+		case line, ok := <-x.lines:
+			if !ok {
+				<-x.done
+				return nil, fmt.Errorf("%w: before response: %v", errProcExited, x.err)
+			}
+			return bytes.TrimSpace(line), nil
+		case <-timeoutC:
+			return nil, fmt.Errorf("timeout %s", timeout)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// stop closes stdin to let the command exit, and kills it if it does not exit soon.
+func (x *coprocess) stop() {
+	x.once.Do(func() {
+		close(x.stopped)
+		_ = x.stdin.Close()
+		select {
+		case <-x.done:
+		case <-time.After(time.Second):
+		}
+		x.cancel()
+	})
+}
+
+// kill kills the command immediately.
+func (x *coprocess) kill() {
+	x.once.Do(func() {
+		close(x.stopped)
+		_ = x.stdin.Close()
+		x.cancel()
+	})
+}
@@ -1,12 +1,21 @@
 package tree
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/berquerant/ndql/pkg/iox"
+	"github.com/berquerant/ndql/pkg/node"
 	"github.com/berquerant/ndql/pkg/regexpx"
 )
 
@@ -14,44 +23,356 @@ import (
 // regexp gen template
 //
 // grep(pattern, template)
+// grep(pattern, template, option)
+
+const (
+	GrepKeyBefore = "grep_before"
+	GrepKeyAfter  = "grep_after"
+
+	GrepVarLine   = "line"
+	GrepVarColumn = "column"
+	GrepVarOffset = "offset"
+)
+
+// GrepOption is the option of grep.
+type GrepOption struct {
+	// Regexp flags, any of i, m, s and U.
+	Flags string
+	// Maximum number of matches, 0 means unlimited.
+	MaxCount int
+	// Number of context lines before the match.
+	Before int
+	// Number of context lines after the match.
+	After int
+}
+
+// ParseGrepOption parses a comma-separated list of options like "i,max=10,C=2".
+//
+//   - i, m, s, U: regexp flags, can be combined like "im"
+//   - max=N: stop after N matches
+//   - B=N: N lines of context before the match
+//   - A=N: N lines of context after the match
+//   - C=N: N lines of context before and after the match
+func ParseGrepOption(s string) (*GrepOption, error) {
+	var opt GrepOption
+	for _, x := range parseTemplateOptions(s) {
+		if !x.hasValue {
+			if strings.Trim(x.key, "imsU") != "" {
+				return nil, fmt.Errorf("%w: unknown grep flag %s", ErrInvalidArgument, x.key)
+			}
+			opt.Flags += x.key
+			continue
+		}
+		n, err := strconv.Atoi(x.value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%w: grep option %s requires non-negative Int", ErrInvalidArgument, x.key)
+		}
+		switch x.key {
+		case "max":
+			opt.MaxCount = n
+		case "B":
+			opt.Before = n
+		case "A":
+			opt.After = n
+		case "C":
+			opt.Before = n
+			opt.After = n
+		default:
+			return nil, fmt.Errorf("%w: unknown grep option %s", ErrInvalidArgument, x.key)
+		}
+	}
+	return &opt, nil
+}
 
 type RegexpGenTemplate struct {
 	expr string
 	tmpl string
+	opt  *GrepOption
 }
 
 func NewRegexpGenTemplate(expr, tmpl string) *RegexpGenTemplate {
+	return NewRegexpGenTemplateWithOption(expr, tmpl, &GrepOption{})
+}
+
+func NewRegexpGenTemplateWithOption(expr, tmpl string, opt *GrepOption) *RegexpGenTemplate {
 	return &RegexpGenTemplate{
 		expr: expr,
 		tmpl: tmpl,
+		opt:  opt,
+	}
+}
+
+var _ NodeGenTemplate = &RegexpGenTemplate{}
+
+func (g RegexpGenTemplate) Generate(ctx context.Context, n *N) ([]byte, error) {
+	ms, err := g.match(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	result := make([][]byte, len(ms))
+	for i, m := range ms {
+		result[i] = m.expanded
+	}
+	return bytes.Join(result, []byte("\n")), nil
+}
+
+func (g RegexpGenTemplate) GenerateNodes(ctx context.Context, n *N) ([]*N, error) {
+	ms, err := g.match(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	result := []*N{}
+	for _, m := range ms {
+		if len(m.expanded) == 0 {
+			continue
+		}
+		xs, err := ParseGenResult(m.expanded)
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range xs {
+			if g.opt.Before > 0 {
+				x.Set(GrepKeyBefore, node.String(strings.Join(m.before, "\n")))
+			}
+			if g.opt.After > 0 {
+				x.Set(GrepKeyAfter, node.String(strings.Join(m.after, "\n")))
+			}
+		}
+		result = append(result, xs...)
 	}
+	return result, nil
 }
 
-var _ GenTemplate = &RegexpGenTemplate{}
+type regexpMatch struct {
+	expanded []byte
+	before   []string
+	after    []string
+}
 
-func (g RegexpGenTemplate) Generate(_ context.Context, n *N) ([]byte, error) {
-	re, err := regexpx.Compile(g.expr)
+func (g RegexpGenTemplate) compile() (*regexp.Regexp, error) {
+	expr := g.expr
+	if g.opt.Flags != "" {
+		expr = "(?" + g.opt.Flags + ")" + expr
+	}
+	return regexpx.Compile(expr)
+}
+
+func (g RegexpGenTemplate) match(ctx context.Context, n *N) ([]*regexpMatch, error) {
+	re, err := g.compile()
 	if err != nil {
 		return nil, fmt.Errorf("%w: failed to compile expr", errors.Join(ErrGenTemplate, err))
 	}
 
-	p := n.GetPath()
-	c, err := regexpGenTemplateCache.Get(p.Raw())
+	var (
+		p  = n.GetPath()
+		cc = iox.ContentCacheFromContext(ctx)
+	)
+	stream, err := cc.ShouldStream(p.Raw())
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read content to grep, file=%s", errors.Join(ErrGenTemplate, err), p.Raw())
+	}
+	if stream {
+		if dependsOnLines(re) {
+			slog.Warn("grep pattern may match differently because the file is grepped line by line",
+				slog.String("pattern", re.String()), slog.String("file", p.Raw()))
+		}
+		f, err := cc.Open(p.Raw())
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to open content to grep, file=%s", errors.Join(ErrGenTemplate, err), p.Raw())
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		r, err := g.matchStream(re, f)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read content to grep, file=%s", errors.Join(ErrGenTemplate, err), p.Raw())
+		}
+		return r, nil
+	}
+
+	c, err := cc.Get(p.Raw())
 	if err != nil {
 		return nil, fmt.Errorf("%w: failed to read content to grep, file=%s", errors.Join(ErrGenTemplate, err), p.Raw())
 	}
+	return g.matchContent(re, c), nil
+}
+
+func (g RegexpGenTemplate) limit() int {
+	if g.opt.MaxCount > 0 {
+		return g.opt.MaxCount
+	}
+	return -1
+}
+
+func (g RegexpGenTemplate) newMatch(re *regexp.Regexp, src []byte, sm []int, line, column, offset int) *regexpMatch {
+	tmpl := g.expandVars(re, map[string]string{
+		GrepVarLine:   strconv.Itoa(line),
+		GrepVarColumn: strconv.Itoa(column),
+		GrepVarOffset: strconv.Itoa(offset),
+	})
+	return &regexpMatch{
+		expanded: re.Expand([]byte{}, []byte(tmpl), src, sm),
+	}
+}
 
-	matches := re.FindAllSubmatchIndex(c, -1)
-	result := make([][]byte, len(matches))
+// matchContent greps the whole contents.
+func (g RegexpGenTemplate) matchContent(re *regexp.Regexp, c []byte) []*regexpMatch {
+	var (
+		matches = re.FindAllSubmatchIndex(c, g.limit())
+		result  = make([]*regexpMatch, len(matches))
+		lines   []string
+		// position of the last match
+		line      = 1
+		lineStart = 0
+		offset    = 0
+	)
+	if g.opt.Before > 0 || g.opt.After > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(c), "\n"), "\n")
+		for i, x := range lines {
+			lines[i] = strings.TrimSuffix(x, "\r")
+		}
+	}
 	for i, sm := range matches {
-		r := []byte{}
-		r = re.Expand(r, []byte(g.tmpl), c, sm)
-		if len(r) > 0 {
-			result[i] = r
+		start, end := sm[0], sm[1]
+		if nl := bytes.Count(c[offset:start], []byte("\n")); nl > 0 {
+			line += nl
+			lineStart = bytes.LastIndexByte(c[:start], '\n') + 1
 		}
+		offset = start
+
+		m := g.newMatch(re, c, sm, line, start-lineStart+1, start)
+		if lines != nil {
+			endLine := line
+			if end > start {
+				endLine += bytes.Count(c[start:end-1], []byte("\n"))
+			}
+			m.before = lines[max(0, line-1-g.opt.Before) : line-1]
+			m.after = lines[min(len(lines), endLine):min(len(lines), endLine+g.opt.After)]
+		}
+		result[i] = m
 	}
+	return result
+}
 
-	return bytes.Join(result, []byte("\n")), nil
+// grepStreamChunkBytes is the maximum size of a line read at once by matchStream.
+const grepStreamChunkBytes = 1 << 20
+
+// matchStream greps the contents line by line, so the pattern cannot match across lines.
+// The lines longer than grepStreamChunkBytes are split into chunks,
+// so the pattern cannot match across the chunks either.
+func (g RegexpGenTemplate) matchStream(re *regexp.Regexp, r io.Reader) ([]*regexpMatch, error) {
+	var (
+		br      = bufio.NewReaderSize(r, grepStreamChunkBytes)
+		result  = []*regexpMatch{}
+		before  = []string{}
+		pending = []*regexpMatch{} // waiting for the lines after the match
+		limit   = g.limit()
+		offset  = 0
+		line    = 1
+		column  = 0 // offset of the chunk in the line
+	)
+	for {
+		b, err := br.ReadSlice('\n')
+		chunked := errors.Is(err, bufio.ErrBufferFull)
+		if len(b) > 0 {
+			text := b
+			if !chunked {
+				text = bytes.TrimSuffix(bytes.TrimSuffix(text, []byte("\n")), []byte("\r"))
+			}
+			s := string(text)
+
+			i := 0
+			for _, m := range pending {
+				m.after = append(m.after, s)
+				if len(m.after) < g.opt.After {
+					pending[i] = m
+					i++
+				}
+			}
+			pending = pending[:i]
+
+			if limit != 0 {
+				matches := re.FindAllSubmatchIndex(text, limit)
+				for _, sm := range matches {
+					m := g.newMatch(re, text, sm, line, column+sm[0]+1, offset+sm[0])
+					if g.opt.Before > 0 {
+						m.before = slices.Clone(before)
+					}
+					if g.opt.After > 0 {
+						m.after = []string{}
+						pending = append(pending, m)
+					}
+					result = append(result, m)
+				}
+				if limit > 0 {
+					limit -= len(matches)
+				}
+			}
+
+			if g.opt.Before > 0 {
+				before = append(before, s)
+				if len(before) > g.opt.Before {
+					before = before[1:]
+				}
+			}
+			offset += len(b)
+			if chunked {
+				column += len(b)
+			} else {
+				line++
+				column = 0
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil && !chunked {
+			return nil, err
+		}
+		if limit == 0 && len(pending) == 0 {
+			return result, nil
+		}
+	}
 }
 
-var regexpGenTemplateCache = iox.NewFileContentCache()
+// dependsOnLines returns true if the pattern can match newlines or has anchors like ^ and $,
+// so it matches differently when the contents are grepped line by line.
+func dependsOnLines(re *regexp.Regexp) bool {
+	x, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return false
+	}
+	var walk func(*syntax.Regexp) bool
+	walk = func(x *syntax.Regexp) bool {
+		switch x.Op {
+		case syntax.OpAnyChar, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+			return true
+		case syntax.OpLiteral:
+			if slices.Contains(x.Rune, '\n') {
+				return true
+			}
+		case syntax.OpCharClass:
+			for i := 0; i+1 < len(x.Rune); i += 2 {
+				if x.Rune[i] <= '\n' && '\n' <= x.Rune[i+1] {
+					return true
+				}
+			}
+		}
+		return slices.ContainsFunc(x.Sub, walk)
+	}
+	return walk(x)
+}
+
+var grepTemplateVarRegexp = regexp.MustCompile(`\$\$|\$\{(\w+)\}|\$(\w+)`)
+
+// expandVars replaces $line, $column and $offset in the template
+// unless the pattern has the capturing group of the same name.
+func (g RegexpGenTemplate) expandVars(re *regexp.Regexp, vars map[string]string) string {
+	return grepTemplateVarRegexp.ReplaceAllStringFunc(g.tmpl, func(s string) string {
+		name := strings.Trim(s, "${}")
+		if v, ok := vars[name]; ok && s != "$$" && re.SubexpIndex(name) < 0 {
+			return v
+		}
+		return s
+	})
+}
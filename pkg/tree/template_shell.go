@@ -3,14 +3,20 @@ package tree
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/berquerant/ndql/pkg/cachex"
+	"github.com/berquerant/ndql/pkg/node"
 	"github.com/berquerant/ndql/pkg/util"
 )
 
@@ -18,11 +24,14 @@ import (
 // shell gen template
 //
 // sh(script)
+// sh(script, option)
 //
 // ## Environment variables
 // Available, referenced by $NAME
 //
 // ## Functions
+// Available only in bash.
+//
 // ### get
 // Get value from a node like 'get key'.
 // If key is not found, returns an empty string.
@@ -31,43 +40,285 @@ import (
 // Get value from a node like 'get key default_value'.
 // If key is not found, returns default_value.
 
+const (
+	ShellKeyExitCode = "sh_exit_code"
+	ShellKeyStderr   = "sh_stderr"
+
+	defaultShell = "bash"
+)
+
+// ShellOption is the option of sh.
+type ShellOption struct {
+	// Interpreter to run the script, bash by default.
+	Shell string
+	// Timeout of an invocation, 0 means no timeout.
+	Timeout time.Duration
+	// Maximum bytes of stdout and stderr respectively, 0 means unlimited.
+	MaxOutput int64
+	// If true, failures do not drop the row but are stored in the sh_exit_code and sh_stderr keys.
+	Capture bool
+	// If true, the results are stored in the result cache. Ignored if Capture is true.
+	Cache bool
+}
+
+// ParseShellOption parses a comma-separated list of options like "shell=sh,timeout=10s,capture".
+//
+//   - shell=NAME: interpreter to run the script
+//   - timeout=DURATION: kill the script after the duration
+//   - max_output=N: fail if the script writes more than N bytes to stdout, truncate stderr to N bytes
+//   - capture: store the exit code and stderr into the row instead of dropping it
+//   - cache: store the results in the result cache
+func ParseShellOption(s string) (*ShellOption, error) {
+	opt := ShellOption{
+		Shell: defaultShell,
+	}
+	for _, x := range parseTemplateOptions(s) {
+		switch x.key {
+		case "shell":
+			if x.value == "" {
+				return nil, fmt.Errorf("%w: sh option shell requires name", ErrInvalidArgument)
+			}
+			opt.Shell = x.value
+		case "timeout":
+			d, err := time.ParseDuration(x.value)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("%w: sh option timeout requires non-negative Duration", errors.Join(ErrInvalidArgument, err))
+			}
+			opt.Timeout = d
+		case "max_output":
+			n, err := strconv.ParseInt(x.value, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%w: sh option max_output requires non-negative Int", ErrInvalidArgument)
+			}
+			opt.MaxOutput = n
+		case "capture":
+			if x.hasValue {
+				return nil, fmt.Errorf("%w: sh option capture takes no value", ErrInvalidArgument)
+			}
+			opt.Capture = true
+		case "cache":
+			if x.hasValue {
+				return nil, fmt.Errorf("%w: sh option cache takes no value", ErrInvalidArgument)
+			}
+			opt.Cache = true
+		default:
+			return nil, fmt.Errorf("%w: unknown sh option %s", ErrInvalidArgument, x.key)
+		}
+	}
+	return &opt, nil
+}
+
 type ShellGenTemplate struct {
-	text  string
-	shell string
+	text string
+	opt  *ShellOption
 }
 
 func NewShellGenTemplate(text string) *ShellGenTemplate {
+	return NewShellGenTemplateWithOption(text, &ShellOption{
+		Shell: defaultShell,
+	})
+}
+
+func NewShellGenTemplateWithOption(text string, opt *ShellOption) *ShellGenTemplate {
 	return &ShellGenTemplate{
-		text:  text,
-		shell: "bash",
+		text: text,
+		opt:  opt,
 	}
 }
 
-var _ GenTemplate = &ShellGenTemplate{}
+var (
+	_ NodeGenTemplate      = &ShellGenTemplate{}
+	_ CacheableGenTemplate = &ShellGenTemplate{}
+)
 
 var shellGenTemplateCache = util.Must(cachex.NewTmpFileCache(util.TempDir("shell_template")))
 
 func (g ShellGenTemplate) Generate(ctx context.Context, n *N) ([]byte, error) {
+	if g.opt.Capture {
+		ns, err := g.GenerateNodes(ctx, n)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(ns)
+	}
+	r, err := g.run(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	return r.stdout, nil
+}
+
+func (g ShellGenTemplate) CacheKey(ctx context.Context, n *N) (string, bool) {
+	if !g.opt.Cache || g.opt.Capture {
+		return "", false
+	}
+	script := strings.Join([]string{
+		g.opt.Shell,
+		strconv.FormatInt(g.opt.MaxOutput, 10),
+		g.generateScript(),
+	}, "\n")
+	return genTemplateCacheKey(ctx, "sh", script, n), true
+}
+
+func (g ShellGenTemplate) GenerateNodes(ctx context.Context, n *N) ([]*N, error) {
+	r, err := g.run(ctx, n)
+	if !g.opt.Capture {
+		if err != nil {
+			return nil, err
+		}
+		return ParseGenResult(r.stdout)
+	}
+
+	var ns []*N
+	if err == nil {
+		if ns, err = ParseGenResult(r.stdout); err != nil {
+			return nil, err
+		}
+	}
+	if len(ns) == 0 {
+		ns = []*N{node.New()}
+	}
+	for _, x := range ns {
+		x.Set(ShellKeyExitCode, node.Int(r.exitCode))
+		x.Set(ShellKeyStderr, node.String(r.stderr))
+	}
+	return ns, nil
+}
+
+type shellResult struct {
+	stdout   []byte
+	stderr   string
+	exitCode int
+}
+
+// run executes the script.
+// The result is available even if the error is not nil.
+func (g ShellGenTemplate) run(ctx context.Context, n *N) (*shellResult, error) {
 	t, err := shellGenTemplateCache.Get(g.generateScript())
 	if err != nil {
-		return nil, fmt.Errorf("%w: cannot get shell template", errors.Join(ErrGenTemplate, err))
+		return &shellResult{
+			stderr:   err.Error(),
+			exitCode: -1,
+		}, fmt.Errorf("%w: cannot get shell template", errors.Join(ErrGenTemplate, err))
+	}
+
+	if g.opt.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.opt.Timeout)
+		defer cancel()
 	}
 
-	slog.Debug("ShellGenTemplate", slog.String("file", t))
-	var out bytes.Buffer
-	cmd := exec.CommandContext(ctx, g.shell, t)
-	cmd.Stdout = &out
-	cmd.Stderr = os.Stderr
+	slog.Debug("ShellGenTemplate", slog.String("file", t), slog.String("shell", g.opt.Shell))
+	var (
+		stdout = newLimitedBuffer(g.opt.MaxOutput)
+		stderr = newLimitedBuffer(g.opt.MaxOutput)
+		cmd    = exec.CommandContext(ctx, g.opt.Shell, t)
+	)
+	cmd.Stdout = stdout
+	if g.opt.Capture {
+		cmd.Stderr = stderr
+	} else {
+		// keep the head of stderr for the error
+		if stderr.limit <= 0 || stderr.limit > maxErrorStderr {
+			stderr.limit = maxErrorStderr
+		}
+		cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	}
 	cmd.Env = NodeAsEnviron(n)
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("%w: failed to run shell template %s", errors.Join(ErrGenTemplate, err), t)
+	setProcessGroup(cmd)
+	// do not wait for the children holding the pipes after the timeout
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	switch {
+	case err == nil && stdout.exceeded:
+		err = fmt.Errorf("stdout exceeds max_output %d bytes", g.opt.MaxOutput)
+	case err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = fmt.Errorf("%w: timeout %s", err, g.opt.Timeout)
+	}
+
+	r := &shellResult{
+		stdout:   bytes.TrimSpace(stdout.Bytes()),
+		stderr:   stderr.String(),
+		exitCode: 0,
+	}
+	if err == nil {
+		return r, nil
+	}
+
+	r.exitCode = -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 && !stdout.exceeded {
+		r.exitCode = exitErr.ExitCode()
+	}
+	if r.exitCode < 0 || !g.opt.Capture {
+		if r.stderr != "" && !strings.HasSuffix(r.stderr, "\n") {
+			r.stderr += "\n"
+		}
+		r.stderr += err.Error()
+	}
+	return r, &stderrError{
+		err:    fmt.Errorf("%w: failed to run shell template %s", errors.Join(ErrGenTemplate, err), t),
+		stderr: stderr.String(),
+	}
+}
+
+// maxErrorStderr is the maximum bytes of stderr kept for the error.
+const maxErrorStderr = 64 << 10
+
+// stderrError is the error of a generator with its stderr.
+type stderrError struct {
+	err    error
+	stderr string
+}
+
+func (e *stderrError) Error() string { return e.err.Error() }
+func (e *stderrError) Unwrap() error { return e.err }
+
+// ErrorStderr returns the stderr of the generator in the error, empty if not found.
+func ErrorStderr(err error) string {
+	var x *stderrError
+	if errors.As(err, &x) {
+		return x.stderr
 	}
-	return bytes.TrimSpace(out.Bytes()), nil
+	return ""
 }
 
+// limitedBuffer is a buffer that discards the bytes over the limit.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int64
+	exceeded bool
+}
+
+func newLimitedBuffer(limit int64) *limitedBuffer {
+	return &limitedBuffer{
+		limit: limit,
+	}
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.limit <= 0 {
+		return b.buf.Write(p)
+	}
+	if rest := b.limit - int64(b.buf.Len()); int64(len(p)) > rest {
+		b.exceeded = true
+		_, _ = b.buf.Write(p[:max(rest, 0)])
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) Bytes() []byte  { return b.buf.Bytes() }
+func (b *limitedBuffer) String() string { return b.buf.String() }
+
 func (g ShellGenTemplate) generateScript() string {
+	if filepath.Base(g.opt.Shell) != defaultShell {
+		// get and get_or depend on bash
+		return g.text
+	}
 	return strings.Join([]string{
-		fmt.Sprintf("#!/bin/%s", g.shell),
+		"#!/bin/bash",
 		fmt.Sprintf(shellGenTemplateFunc, TableKeySeparator),
 		g.text,
 	}, "\n")
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package tree
+
+import "os/exec"
+
+func setProcessGroup(_ *exec.Cmd) {}
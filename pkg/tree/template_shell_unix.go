@@ -0,0 +1,17 @@
+//go:build unix
+
+package tree
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes cmd kill the whole process group on cancel,
+// so the children of the script do not survive the timeout.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,280 @@
+package tree
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/berquerant/ndql/pkg/cachex"
+	"github.com/berquerant/ndql/pkg/logx"
+	"github.com/berquerant/ndql/pkg/node"
+	"github.com/berquerant/ndql/pkg/util"
+	starjson "go.starlark.net/lib/json"
+	starmath "go.starlark.net/lib/math"
+	startime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+)
+
+//
+// starlark gen template
+//
+// star(script, entrypoint)
+//
+// The function specified in entrypoint must be defined within the script,
+// take exactly one argument, and return a string, a dict or a list of dicts.
+// The argument passed to this function is a node as a dict.
+//
+// Scripts cannot do any I/O: load is disabled, and only the math and json modules are predeclared.
+// Each call runs on the fresh globals, so calls do not affect each other.
+
+type StarGenTemplate struct {
+	script     string
+	entrypoint string
+}
+
+func NewStarGenTemplate(script, entrypoint string) *StarGenTemplate {
+	return &StarGenTemplate{
+		script:     script,
+		entrypoint: entrypoint,
+	}
+}
+
+var _ NodeGenTemplate = &StarGenTemplate{}
+
+var (
+	starGenTemplatePredeclared = starlark.StringDict{
+		"math": starmath.Module,
+		"json": starjson.Module,
+	}
+	starGenTemplateCache = util.Must(cachex.NewStarlarkCache(starGenTemplatePredeclared.Has))
+)
+
+func (g StarGenTemplate) Generate(ctx context.Context, n *N) ([]byte, error) {
+	v, err := g.call(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	ns, ok, err := starValueToNodes(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to retrieve star return value", errors.Join(ErrGenTemplate, err))
+	}
+	if !ok {
+		return []byte(string(v.(starlark.String))), nil
+	}
+	b, err := json.Marshal(ns)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to marshal star return value", errors.Join(ErrGenTemplate, err))
+	}
+	return b, nil
+}
+
+func (g StarGenTemplate) GenerateNodes(ctx context.Context, n *N) ([]*N, error) {
+	v, err := g.call(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	ns, ok, err := starValueToNodes(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to retrieve star return value", errors.Join(ErrGenTemplate, err))
+	}
+	if !ok {
+		return ParseGenResult([]byte(string(v.(starlark.String))))
+	}
+	return ns, nil
+}
+
+// call calls the entrypoint with the node.
+func (g StarGenTemplate) call(ctx context.Context, n *N) (starlark.Value, error) {
+	prog, err := starGenTemplateCache.Get(g.script)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to compile star", errors.Join(ErrGenTemplate, err))
+	}
+
+	thread := &starlark.Thread{
+		Name: "star",
+		Print: func(_ *starlark.Thread, msg string) {
+			slog.Debug("star", slog.String("msg", msg))
+		},
+		// Load is nil so load statements fail
+	}
+	stop := context.AfterFunc(ctx, func() {
+		thread.Cancel(ctx.Err().Error())
+	})
+	defer stop()
+
+	globals, err := prog.Init(thread, starGenTemplatePredeclared)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to init star", errors.Join(ErrGenTemplate, err))
+	}
+	fn, ok := globals[g.entrypoint]
+	if !ok {
+		return nil, fmt.Errorf("%w: star entrypoint %s not found", ErrGenTemplate, g.entrypoint)
+	}
+	d, err := nodeToStarDict(n)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to convert node into star dict", errors.Join(ErrGenTemplate, err))
+	}
+	r, err := starlark.Call(thread, fn, starlark.Tuple{d}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to call star script", errors.Join(ErrGenTemplate, err))
+	}
+	logx.Trace("star", slog.String("entrypoint", g.entrypoint), slog.String("result", r.String()))
+	return r, nil
+}
+
+// dataToStarValue converts the node data into the starlark value.
+func dataToStarValue(d ND) starlark.Value {
+	switch d := d.(type) {
+	case node.Float:
+		return starlark.Float(d.Raw())
+	case node.Int:
+		return starlark.MakeInt64(d.Raw())
+	case node.Bool:
+		return starlark.Bool(d.Raw())
+	case node.String:
+		return starlark.String(d.Raw())
+	case node.Time:
+		return startime.Time(d.Raw())
+	case node.Duration:
+		return startime.Duration(d.Raw())
+	default:
+		return starlark.None
+	}
+}
+
+// nodeToStarDict converts the node into the starlark dict.
+// Table-qualified keys like table.column are converted into nested dicts.
+func nodeToStarDict(n *N) (*starlark.Dict, error) {
+	r := starlark.NewDict(n.Len())
+	for k, v := range n.Unwrap() {
+		key := KeyFromString(k)
+		if key.Table == "" {
+			if x, found, _ := r.Get(starlark.String(k)); found {
+				if _, ok := x.(*starlark.Dict); ok {
+					// tables take precedence
+					continue
+				}
+			}
+			if err := r.SetKey(starlark.String(k), dataToStarValue(v)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		x, found, _ := r.Get(starlark.String(key.Table))
+		st, ok := x.(*starlark.Dict)
+		if !found || !ok {
+			st = starlark.NewDict(1)
+			if err := r.SetKey(starlark.String(key.Table), st); err != nil {
+				return nil, err
+			}
+		}
+		if err := st.SetKey(starlark.String(key.Column), dataToStarValue(v)); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// starValueToData converts the starlark value into the node data.
+func starValueToData(v starlark.Value) (ND, error) {
+	switch v := v.(type) {
+	case starlark.Bool:
+		return node.Bool(bool(v)), nil
+	case starlark.Int:
+		if x, ok := v.Int64(); ok {
+			return node.Int(x), nil
+		}
+		return nil, fmt.Errorf("%w: star int %s overflows", ErrInvalidValue, v)
+	case starlark.Float:
+		return node.Float(float64(v)), nil
+	case starlark.String:
+		return node.String(string(v)), nil
+	case startime.Time:
+		return node.Time(time.Time(v)), nil
+	case startime.Duration:
+		return node.Duration(time.Duration(v)), nil
+	default:
+		return nil, fmt.Errorf("%w: cannot convert star %s into node data", ErrInvalidValue, v.Type())
+	}
+}
+
+// starDictToNode converts the starlark dict into the node.
+// Nested dicts are converted into table-qualified keys, None values are ignored.
+func starDictToNode(d *starlark.Dict) (*N, error) {
+	n := node.New()
+	set := func(key *Key, v starlark.Value) error {
+		if v == starlark.None {
+			return nil
+		}
+		x, err := starValueToData(v)
+		if err != nil {
+			return fmt.Errorf("%w: key %s", err, key.Name())
+		}
+		n.Set(key.String(), x)
+		return nil
+	}
+	for _, item := range d.Items() {
+		column, ok := starlark.AsString(item[0])
+		if !ok {
+			return nil, fmt.Errorf("%w: star dict key should be string, got %s", ErrInvalidKey, item[0].Type())
+		}
+		sd, ok := item[1].(*starlark.Dict)
+		if !ok {
+			if err := set(NewKey("", column), item[1]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, sitem := range sd.Items() {
+			sc, ok := starlark.AsString(sitem[0])
+			if !ok {
+				return nil, fmt.Errorf("%w: star dict key should be string, got %s", ErrInvalidKey, sitem[0].Type())
+			}
+			if err := set(NewKey(column, sc), sitem[1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// starValueToNodes converts the return value of the entrypoint into nodes.
+//
+//   - None: no nodes
+//   - dict: a node
+//   - list or tuple of dicts: nodes
+//
+// ok is false if the value is a string, that should be parsed by ParseGenResult.
+func starValueToNodes(v starlark.Value) ([]*N, bool, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return []*N{}, true, nil
+	case starlark.String:
+		return nil, false, nil
+	case *starlark.Dict:
+		n, err := starDictToNode(v)
+		if err != nil {
+			return nil, true, err
+		}
+		return []*N{n}, true, nil
+	case starlark.Indexable: // list, tuple
+		r := make([]*N, v.Len())
+		for i := range v.Len() {
+			d, ok := v.Index(i).(*starlark.Dict)
+			if !ok {
+				return nil, true, fmt.Errorf("%w: star list should contain dicts, index %d is %s", ErrInvalidValue, i, v.Index(i).Type())
+			}
+			n, err := starDictToNode(d)
+			if err != nil {
+				return nil, true, err
+			}
+			r[i] = n
+		}
+		return r, true, nil
+	default:
+		return nil, true, fmt.Errorf("%w: star entrypoint should return string, dict or list of dicts, got %s", ErrInvalidValue, v.Type())
+	}
+}
@@ -23,9 +23,21 @@ import (
 // ## Functions
 // ### env
 // os.Getenv.
-
+//
 // ### envor
 // os.Getenv with default value, like 'envor "KEY" "default_value"'.
+//
+// ### get
+// Get value from a node like 'get "key"' or 'get "table.key"'.
+// If key is not found, returns an empty string.
+//
+// ### getor
+// Get value from a node like 'getor "key" "default_value"'.
+// If key is not found, returns default_value.
+//
+// ### ndql functions
+// Path, time, regexp and string functions like 'basename .path' and 'timeformat .mod_time "2006-01-02"'.
+
 type StringGenTemplate struct {
 	text string
 }
@@ -39,8 +51,8 @@ func NewStringGenTemplate(text string) *StringGenTemplate {
 const (
 	genTemplateFuncEnv   = "env"
 	genTemplateFuncEnvOr = "envor"
-	// genTemplateFuncGet   = "get"
-	// genTemplateFuncGetOr = "getor"
+	genTemplateFuncGet   = "get"
+	genTemplateFuncGetOr = "getor"
 )
 
 var _ GenTemplate = &StringGenTemplate{}
@@ -50,6 +62,13 @@ func (g StringGenTemplate) Generate(_ context.Context, n *N) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("%w: cannot get text template", errors.Join(ErrGenTemplate, err))
 	}
+	// bind get and getor to the node
+	t, err = t.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot clone text template", errors.Join(ErrGenTemplate, err))
+	}
+	t.Funcs(stringGenTemplateNodeFuncMap(n))
+
 	var out bytes.Buffer
 	if err := t.Execute(&out, NodeAsStructuredMap(n)); err != nil {
 		return nil, fmt.Errorf("%w: failed to execute", errors.Join(ErrGenTemplate, err))
@@ -57,9 +76,33 @@ func (g StringGenTemplate) Generate(_ context.Context, n *N) ([]byte, error) {
 	return out.Bytes(), nil
 }
 
-var stringGenTemplateCache = util.Must(cachex.NewTextTemplateCache(template.FuncMap{
-	genTemplateFuncEnv:   os.Getenv,
-	genTemplateFuncEnvOr: genTemplateEnvOr,
-	// genTemplateFuncGetOr: genTemplateGetOr,
-	// genTemplateFuncGet:   genTemplateGet,
-}))
+func stringGenTemplateNodeFuncMap(n *N) template.FuncMap {
+	return template.FuncMap{
+		genTemplateFuncGet: func(name string) string {
+			return genTemplateGetOr(n, name, "")
+		},
+		genTemplateFuncGetOr: func(name, v string) string {
+			return genTemplateGetOr(n, name, v)
+		},
+	}
+}
+
+func newStringGenTemplateFuncMap() template.FuncMap {
+	fm := template.FuncMap{
+		genTemplateFuncEnv:   os.Getenv,
+		genTemplateFuncEnvOr: genTemplateEnvOr,
+	}
+	for _, h := range genTemplateHelpers {
+		fm[h.name] = h.call
+	}
+	for _, h := range genTemplateStringHelpers {
+		fm[h.name] = h.call
+	}
+	// placeholders to parse templates, replaced on execution
+	for k, v := range stringGenTemplateNodeFuncMap(nil) {
+		fm[k] = v
+	}
+	return fm
+}
+
+var stringGenTemplateCache = util.Must(cachex.NewTextTemplateCache(newStringGenTemplateFuncMap()))
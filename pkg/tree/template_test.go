@@ -2,11 +2,18 @@ package tree_test
 
 import (
 	"context"
+	"math"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/berquerant/ndql/pkg/cachex"
 	"github.com/berquerant/ndql/pkg/errorx"
+	"github.com/berquerant/ndql/pkg/iox"
 	"github.com/berquerant/ndql/pkg/node"
 	"github.com/berquerant/ndql/pkg/tree"
 	"github.com/stretchr/testify/assert"
@@ -117,6 +124,25 @@ end`, "f"),
 			}),
 			want: []byte(`k1=1,k2=,k3=missing`),
 		},
+		{
+			title: "lua node get",
+			g: tree.NewLuaGenTemplate(`function f(n)
+  return string.format("k1=%s,k2=%s,k3=%s", N.get("k1"), N.get("a.k2"), N.get("k3", "missing"))
+end`, "f"),
+			n: node.FromMap(map[string]node.Data{
+				"k1":                              node.Int(1),
+				tree.KeyFromName("a.k2").String(): node.Int(2),
+			}),
+			want: []byte(`k1=1,k2=2,k3=missing`),
+		},
+		{
+			title: "lua table",
+			g: tree.NewLuaGenTemplate(`function f(n)
+  return {k1 = 1}
+end`, "f"),
+			n:    node.New(),
+			want: []byte(`[{"k1":1}]`),
+		},
 		{
 			title: "regexp const",
 			g:     tree.NewRegexpGenTemplate(`a_key1`, `const`),
@@ -142,6 +168,35 @@ end`, "f"),
 			want: []byte(`b_key1=bv1
 b_key2=bv2`),
 		},
+		{
+			title: "regexp position",
+			g:     tree.NewRegexpGenTemplate(`(?P<k>b_[^=]+)=`, `$k=$line:$column:${offset},$$line`),
+			n: node.FromMap(map[string]node.Data{
+				node.KeyPath: node.String(regexpTestfile),
+			}),
+			want: []byte(`b_key1=3:1:22,$line
+b_key2=4:1:33,$line`),
+		},
+		{
+			title: "regexp position shadowed by group",
+			g:     tree.NewRegexpGenTemplate(`b_(?P<line>[^=]+)=`, `k=$line`),
+			n: node.FromMap(map[string]node.Data{
+				node.KeyPath: node.String(regexpTestfile),
+			}),
+			want: []byte(`k=key1
+k=key2`),
+		},
+		{
+			title: "regexp flags and max",
+			g: tree.NewRegexpGenTemplateWithOption(`^B_(?P<k>.+)$`, `k=$k,c=$column`, &tree.GrepOption{
+				Flags:    "im",
+				MaxCount: 1,
+			}),
+			n: node.FromMap(map[string]node.Data{
+				node.KeyPath: node.String(regexpTestfile),
+			}),
+			want: []byte(`k=key1=bv1,c=1`),
+		},
 		{
 			title: "string const",
 			g:     tree.NewStringGenTemplate(`const`),
@@ -184,6 +239,39 @@ b_key2=bv2`),
 			}),
 			want: []byte(`k1=1,k2=default,k3=2`),
 		},
+		{
+			title: "string get func",
+			g:     tree.NewStringGenTemplate(`k1={{ get "key1" }},k2={{ get "missing" }},k3={{ get "a.key2" }},k4={{ get "key2" }}`),
+			n: node.FromMap(map[string]node.Data{
+				"key1":                              node.Int(1),
+				tree.KeyFromName("a.key2").String(): node.Int(2),
+			}),
+			want: []byte(`k1=1,k2=,k3=2,k4=2`),
+		},
+		{
+			title: "string getor func",
+			g:     tree.NewStringGenTemplate(`k1={{ getor "key1" "default" }},k2={{ getor "missing" "default" }},k3={{ getor "a.key2" "default" }}`),
+			n: node.FromMap(map[string]node.Data{
+				"key1":                              node.Int(1),
+				tree.KeyFromName("a.key2").String(): node.Int(2),
+			}),
+			want: []byte(`k1=1,k2=default,k3=2`),
+		},
+		{
+			title: "string ndql funcs",
+			g:     tree.NewStringGenTemplate(`base={{ basename .path }},ext={{ .path | extension }},up={{ upper "a" }},date={{ timeformat .mod_time "2006-01-02" }},like={{ regexp_like .path "txt$" }},sub={{ substr .path 2 3 }},t={{ trim "  x  " }},r={{ replace .path "/" "_" }}`),
+			n: node.FromMap(map[string]node.Data{
+				"path":     node.String("/tmp/b.txt"),
+				"mod_time": node.Time(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)),
+			}),
+			want: []byte(`base=b.txt,ext=.txt,up=A,date=2024-01-02,like=true,sub=tmp,t=x,r=_tmp_b.txt`),
+		},
+		{
+			title: "string ndql func error",
+			g:     tree.NewStringGenTemplate(`{{ basename 1 2 }}`),
+			n:     node.New(),
+			err:   tree.ErrGenTemplate,
+		},
 		{
 			title: "shell const",
 			g:     tree.NewShellGenTemplate(`echo const`),
@@ -220,3 +308,1030 @@ b_key2=bv2`),
 		},
 	})
 }
+
+func TestLuaGenTemplateNodes(t *testing.T) {
+	var (
+		modTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		n       = node.FromMap(map[string]node.Data{
+			"k1":                              node.Int(1),
+			"mod_time":                        node.Time(modTime),
+			"elapsed":                         node.Duration(90 * time.Second),
+			tree.KeyFromName("a.k2").String(): node.String("v2"),
+		})
+	)
+
+	for _, tc := range []struct {
+		title  string
+		script string
+		want   []*tree.N
+		err    error
+	}{
+		{
+			title:  "string",
+			script: `return "k2=" .. n.k1`,
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"k2": node.String("1"),
+				}),
+			},
+		},
+		{
+			title:  "table",
+			script: `return {i = n.k1 + 1, f = 1.5, b = true, s = n.a.k2, t = n.mod_time, d = n.elapsed, x = {y = 1}}`,
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"i":                              node.Int(2),
+					"f":                              node.Float(1.5),
+					"b":                              node.Bool(true),
+					"s":                              node.String("v2"),
+					"t":                              node.Time(modTime),
+					"d":                              node.Duration(90 * time.Second),
+					tree.KeyFromName("x.y").String(): node.Int(1),
+				}),
+			},
+		},
+		{
+			title:  "list",
+			script: `return {{i = 1}, {i = 2}}`,
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"i": node.Int(1),
+				}),
+				node.FromMap(map[string]node.Data{
+					"i": node.Int(2),
+				}),
+			},
+		},
+		{
+			title:  "nil",
+			script: `return nil`,
+			want:   []*tree.N{},
+		},
+		{
+			title:  "time methods",
+			script: `return {y = n.mod_time:year(), u = n.mod_time:unix(), s = n.elapsed:seconds(), str = "t=" .. n.mod_time, lt = n.mod_time < n.mod_time:add(n.elapsed), f = n.mod_time:format("2006")}`,
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"y":   node.Int(2024),
+					"u":   node.Int(modTime.Unix()),
+					"s":   node.Int(90),
+					"str": node.String("t=2024-01-02 03:04:05"),
+					"lt":  node.Bool(true),
+					"f":   node.String("2024"),
+				}),
+			},
+		},
+		{
+			title:  "node get",
+			script: `return {k2 = N.get("k2"), t = N.get("mod_time"), d = N.get("k3", 3)}`,
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"k2": node.String("v2"),
+					"t":  node.Time(modTime),
+					"d":  node.Int(3),
+				}),
+			},
+		},
+		{
+			title:  "invalid value",
+			script: `return {f = function() end}`,
+			err:    tree.ErrInvalidValue,
+		},
+		{
+			title:  "invalid return",
+			script: `return 1`,
+			err:    tree.ErrGenTemplate,
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			g := tree.NewLuaGenTemplate("function f(n)\n"+tc.script+"\nend", "f")
+			got, err := tree.GenerateAndParse(context.TODO(), n, g)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			if !assert.Nil(t, err, errorx.AsString(err)) {
+				return
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestStarGenTemplateNodes(t *testing.T) {
+	var (
+		modTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		n       = node.FromMap(map[string]node.Data{
+			"k1":                              node.Int(1),
+			"mod_time":                        node.Time(modTime),
+			"elapsed":                         node.Duration(90 * time.Second),
+			tree.KeyFromName("a.k2").String(): node.String("v2"),
+		})
+	)
+
+	for _, tc := range []struct {
+		title  string
+		script string
+		want   []*tree.N
+		err    error
+	}{
+		{
+			title:  "string",
+			script: `return "k2=%d" % n["k1"]`,
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"k2": node.String("1"),
+				}),
+			},
+		},
+		{
+			title:  "dict",
+			script: `return {"i": n["k1"] + 1, "f": 1.5, "b": True, "s": n["a"]["k2"], "t": n["mod_time"], "d": n["elapsed"], "x": {"y": 1}, "z": None}`,
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"i":                              node.Int(2),
+					"f":                              node.Float(1.5),
+					"b":                              node.Bool(true),
+					"s":                              node.String("v2"),
+					"t":                              node.Time(modTime),
+					"d":                              node.Duration(90 * time.Second),
+					tree.KeyFromName("x.y").String(): node.Int(1),
+				}),
+			},
+		},
+		{
+			title:  "list",
+			script: `return [{"i": i} for i in range(1, 3)]`,
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"i": node.Int(1),
+				}),
+				node.FromMap(map[string]node.Data{
+					"i": node.Int(2),
+				}),
+			},
+		},
+		{
+			title:  "none",
+			script: `return None`,
+			want:   []*tree.N{},
+		},
+		{
+			title:  "time attributes",
+			script: `return {"y": n["mod_time"].year, "u": n["mod_time"].unix, "lt": n["mod_time"] < n["mod_time"] + n["elapsed"]}`,
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"y":  node.Int(2024),
+					"u":  node.Int(modTime.Unix()),
+					"lt": node.Bool(true),
+				}),
+			},
+		},
+		{
+			title:  "modules",
+			script: `return {"p": math.pow(2, 3), "j": json.encode({"a": 1})}`,
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"p": node.Float(8),
+					"j": node.String(`{"a":1}`),
+				}),
+			},
+		},
+		{
+			title:  "no io",
+			script: `return open("/etc/passwd")`,
+			err:    tree.ErrGenTemplate,
+		},
+		{
+			title:  "invalid value",
+			script: `return {"f": len}`,
+			err:    tree.ErrInvalidValue,
+		},
+		{
+			title:  "invalid return",
+			script: `return 1`,
+			err:    tree.ErrGenTemplate,
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			g := tree.NewStarGenTemplate("def f(n):\n    "+tc.script+"\n", "f")
+			got, err := tree.GenerateAndParse(context.TODO(), n, g)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			if !assert.Nil(t, err, errorx.AsString(err)) {
+				return
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	t.Run("load", func(t *testing.T) {
+		g := tree.NewStarGenTemplate("load(\"x.star\", \"y\")\ndef f(n):\n    return None\n", "f")
+		_, err := tree.GenerateAndParse(context.TODO(), n, g)
+		assert.ErrorIs(t, err, tree.ErrGenTemplate)
+	})
+}
+
+func TestDataFromAny(t *testing.T) {
+	for _, tc := range []struct {
+		title string
+		v     any
+		want  node.Data
+		err   error
+	}{
+		{
+			title: "nil",
+			v:     nil,
+			want:  node.NewNull(),
+		},
+		{
+			title: "int8",
+			v:     int8(-1),
+			want:  node.Int(-1),
+		},
+		{
+			title: "uint32",
+			v:     uint32(math.MaxUint32),
+			want:  node.Int(math.MaxUint32),
+		},
+		{
+			title: "uint64 max int",
+			v:     uint64(math.MaxInt64),
+			want:  node.Int(math.MaxInt64),
+		},
+		{
+			title: "uint64 overflow",
+			v:     uint64(math.MaxInt64) + 1,
+			err:   tree.ErrInvalidValue,
+		},
+		{
+			title: "uint overflow",
+			v:     uint(math.MaxUint),
+			err:   tree.ErrInvalidValue,
+		},
+		{
+			title: "float32",
+			v:     float32(0.5),
+			want:  node.Float(0.5),
+		},
+		{
+			title: "unknown",
+			v:     []int{1},
+			err:   tree.ErrInvalidValue,
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			got, err := tree.DataFromAny(tc.v)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestExprGenTemplateNodes(t *testing.T) {
+	var (
+		modTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		n       = node.FromMap(map[string]node.Data{
+			"size":                            node.Int(10),
+			"path":                            node.String("/tmp/a/b.txt"),
+			"mod_time":                        node.Time(modTime),
+			tree.KeyFromName("a.k2").String(): node.Int(2),
+		})
+	)
+
+	for _, tc := range []struct {
+		title string
+		expr  string
+		want  []*tree.N
+		err   error
+	}{
+		{
+			title: "string",
+			expr:  `"small=" + string(n.size < 1000)`,
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"small": node.String("true"),
+				}),
+			},
+		},
+		{
+			title: "map",
+			expr:  `{"i": n.size + 1, "f": n.size / 4, "b": n.size > 1, "s": n.path, "t": n.mod_time, "d": duration("1m"), "k": n.a.k2, "x": {"y": 1}, "z": nil}`,
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"i":                              node.Int(11),
+					"f":                              node.Float(2.5),
+					"b":                              node.Bool(true),
+					"s":                              node.String("/tmp/a/b.txt"),
+					"t":                              node.Time(modTime),
+					"d":                              node.Duration(time.Minute),
+					"k":                              node.Int(2),
+					tree.KeyFromName("x.y").String(): node.Int(1),
+				}),
+			},
+		},
+		{
+			title: "list",
+			expr:  `map(1..2, {{"i": #}})`,
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"i": node.Int(1),
+				}),
+				node.FromMap(map[string]node.Data{
+					"i": node.Int(2),
+				}),
+			},
+		},
+		{
+			title: "scalar",
+			expr:  `n.size * 2`,
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					tree.ExprKeyResult: node.Int(20),
+				}),
+			},
+		},
+		{
+			title: "nil",
+			expr:  `nil`,
+			want:  []*tree.N{},
+		},
+		{
+			title: "helpers",
+			expr:  `{"base": basename(n.path), "ext": extension(n.path), "dir": dir(n.path), "year": year(n.mod_time), "date": timeformat(n.mod_time, "2006-01-02"), "like": regexp_like(n.path, "[.]txt$"), "repl": regexp_replace(n.path, "b", "c")}`,
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"base": node.String("b.txt"),
+					"ext":  node.String(".txt"),
+					"dir":  node.String("/tmp/a"),
+					"year": node.Int(2024),
+					"date": node.String("2024-01-02"),
+					"like": node.Bool(true),
+					"repl": node.String("/tmp/a/c.txt"),
+				}),
+			},
+		},
+		{
+			title: "helper arity",
+			expr:  `basename()`,
+			err:   tree.ErrGenTemplate,
+		},
+		{
+			title: "invalid list",
+			expr:  `[1, 2]`,
+			err:   tree.ErrInvalidValue,
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			got, err := tree.GenerateAndParse(context.TODO(), n, tree.NewExprGenTemplate(tc.expr))
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			if !assert.Nil(t, err, errorx.AsString(err)) {
+				return
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLuaStatePool(t *testing.T) {
+	pool := tree.NewLuaStatePool(1)
+	defer pool.Close()
+	ctx := tree.WithLuaStatePool(context.TODO(), pool)
+
+	g := tree.NewLuaGenTemplate(`
+count = 0
+conf = {name = "init"}
+function f(n)
+  count = count + 1
+  leaked = (leaked or 0) + 1
+  local prev = conf.name
+  conf.name = n.name
+  string.custom = true
+  return {count = count, leaked = leaked, prev = prev, custom = string.custom == true}
+end
+`, "f")
+
+	for _, name := range []string{"a", "b", "c"} {
+		got, err := tree.GenerateAndParse(ctx, node.FromMap(map[string]node.Data{
+			"name": node.String(name),
+		}), g)
+		if !assert.Nil(t, err, errorx.AsString(err)) {
+			return
+		}
+		assert.Equal(t, []*tree.N{
+			node.FromMap(map[string]node.Data{
+				"count":  node.Int(1),
+				"leaked": node.Int(1),
+				"prev":   node.String("init"),
+				"custom": node.Bool(true),
+			}),
+		}, got, name)
+	}
+
+	t.Run("error", func(t *testing.T) {
+		g := tree.NewLuaGenTemplate(`function f(n) error("fail") end`, "f")
+		for range 2 {
+			_, err := tree.GenerateAndParse(ctx, node.New(), g)
+			assert.ErrorIs(t, err, tree.ErrGenTemplate)
+		}
+	})
+}
+
+func TestWasmGenTemplate(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		module  = filepath.Join(dir, "test.wasm")
+		target  = filepath.Join(dir, "target.txt")
+		other   = filepath.Join(dir, "other.txt")
+		command = exec.Command("go", "build", "-o", module, "./testdata/wasm")
+	)
+	command.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	if out, err := command.CombinedOutput(); !assert.Nil(t, err, string(out)) {
+		return
+	}
+	assert.Nil(t, os.WriteFile(target, []byte("hello"), 0600))
+	assert.Nil(t, os.WriteFile(other, []byte("world"), 0600))
+
+	for _, tc := range []struct {
+		title    string
+		function string
+		data     map[string]node.Data
+		want     []*tree.N
+		err      error
+	}{
+		{
+			title:    "only the path is readable",
+			function: "_start",
+			data: map[string]node.Data{
+				"path":  node.String(target),
+				"other": node.String(other),
+			},
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"content":        node.String("hello"),
+					"other_readable": node.Bool(false),
+				}),
+			},
+		},
+		{
+			title:    "exit with non-zero code",
+			function: "_start",
+			data: map[string]node.Data{
+				"path":  node.String(target),
+				"other": node.String(other),
+				"fail":  node.Bool(true),
+			},
+			err: tree.ErrGenTemplate,
+		},
+		{
+			title:    "function not found",
+			function: "unknown",
+			data: map[string]node.Data{
+				"path": node.String(target),
+			},
+			err: tree.ErrGenTemplate,
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			g := tree.NewWasmGenTemplate(module, tc.function)
+			got, err := tree.GenerateAndParse(context.TODO(), node.FromMap(tc.data), g)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			if !assert.Nil(t, err, errorx.AsString(err)) {
+				return
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestRegexpGenTemplateFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"dir/regexp.txt": {Data: []byte(`a_key1=av1
+b_key1=bv1`)},
+	}
+	ctx := iox.WithFS(context.TODO(), fsys)
+	n := node.FromMap(map[string]node.Data{
+		node.KeyPath: node.String("dir/regexp.txt"),
+	})
+
+	got, err := tree.NewRegexpGenTemplate(`(?P<k>b_[^=]+)=(?P<v>.+)`, `$k=$v`).Generate(ctx, n)
+	if !assert.Nil(t, err, errorx.AsString(err)) {
+		return
+	}
+	assert.Equal(t, `b_key1=bv1`, string(got))
+
+	_, err = tree.NewRegexpGenTemplate(`a`, `b`).Generate(context.TODO(), n)
+	assert.ErrorIs(t, err, tree.ErrGenTemplate)
+}
+
+func TestRegexpGenTemplateContext(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("l1\r\nl2\nmatch\nl4\nl5\n")},
+	}
+	ctx := iox.WithFS(context.TODO(), fsys)
+	n := node.FromMap(map[string]node.Data{
+		node.KeyPath: node.String("a.txt"),
+	})
+
+	for _, tc := range []struct {
+		title string
+		opt   string
+		want  []*tree.N
+	}{
+		{
+			title: "before",
+			opt:   "B=1",
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"line":             node.String("3"),
+					tree.GrepKeyBefore: node.String("l2"),
+				}),
+			},
+		},
+		{
+			title: "context",
+			opt:   "C=5",
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"line":             node.String("3"),
+					tree.GrepKeyBefore: node.String("l1\nl2"),
+					tree.GrepKeyAfter:  node.String("l4\nl5"),
+				}),
+			},
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			opt, err := tree.ParseGrepOption(tc.opt)
+			if !assert.Nil(t, err) {
+				return
+			}
+			got, err := tree.GenerateAndParse(ctx, n, tree.NewRegexpGenTemplateWithOption(`match`, `line=$line`, opt))
+			if !assert.Nil(t, err, errorx.AsString(err)) {
+				return
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseGrepOption(t *testing.T) {
+	for _, tc := range []struct {
+		title string
+		s     string
+		want  *tree.GrepOption
+		err   error
+	}{
+		{
+			title: "empty",
+			s:     "",
+			want:  &tree.GrepOption{},
+		},
+		{
+			title: "all",
+			s:     "im, s,max=3,B=1,A=2",
+			want: &tree.GrepOption{
+				Flags:    "ims",
+				MaxCount: 3,
+				Before:   1,
+				After:    2,
+			},
+		},
+		{
+			title: "context",
+			s:     "C=2",
+			want: &tree.GrepOption{
+				Before: 2,
+				After:  2,
+			},
+		},
+		{
+			title: "unknown flag",
+			s:     "x",
+			err:   tree.ErrInvalidArgument,
+		},
+		{
+			title: "unknown option",
+			s:     "n=1",
+			err:   tree.ErrInvalidArgument,
+		},
+		{
+			title: "negative",
+			s:     "max=-1",
+			err:   tree.ErrInvalidArgument,
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			got, err := tree.ParseGrepOption(tc.s)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestRegexpGenTemplateStream(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("l1 x\r\nl2\nx l3 x\nl4\nl5 x")},
+	}
+	n := node.FromMap(map[string]node.Data{
+		node.KeyPath: node.String("a.txt"),
+	})
+
+	for _, opt := range []string{"", "max=2", "B=1", "A=1", "C=2,max=3"} {
+		t.Run(opt, func(t *testing.T) {
+			o, err := tree.ParseGrepOption(opt)
+			if !assert.Nil(t, err) {
+				return
+			}
+			g := tree.NewRegexpGenTemplateWithOption(`x`, `l=$line,c=$column,o=$offset`, o)
+			want, err := tree.GenerateAndParse(iox.WithFS(context.TODO(), fsys), n, g)
+			if !assert.Nil(t, err, errorx.AsString(err)) {
+				return
+			}
+			assert.NotEmpty(t, want)
+			ctx := iox.WithContentCache(context.TODO(), iox.NewSizedContentCache(fsys, 100, 0))
+			got, err := tree.GenerateAndParse(ctx, n, g)
+			if !assert.Nil(t, err, errorx.AsString(err)) {
+				return
+			}
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestRegexpGenTemplateStreamLongLine(t *testing.T) {
+	// a line longer than the chunk of the stream
+	long := strings.Repeat("a", 3<<20)
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("x\n" + long[:1<<20+10] + "x" + long[1<<20+10:] + "x\nx")},
+	}
+	n := node.FromMap(map[string]node.Data{
+		node.KeyPath: node.String("a.txt"),
+	})
+	g := tree.NewRegexpGenTemplateWithOption(`x`, `l=$line,c=$column,o=$offset`, &tree.GrepOption{})
+	want, err := tree.GenerateAndParse(iox.WithFS(context.TODO(), fsys), n, g)
+	if !assert.Nil(t, err, errorx.AsString(err)) {
+		return
+	}
+	assert.Len(t, want, 4)
+	ctx := iox.WithContentCache(context.TODO(), iox.NewSizedContentCache(fsys, 100, 0))
+	got, err := tree.GenerateAndParse(ctx, n, g)
+	if !assert.Nil(t, err, errorx.AsString(err)) {
+		return
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestShellGenTemplateOption(t *testing.T) {
+	n := node.FromMap(map[string]node.Data{
+		"k1": node.Int(1),
+	})
+
+	for _, tc := range []struct {
+		title  string
+		script string
+		opt    string
+		want   []*tree.N
+		err    error
+	}{
+		{
+			title:  "sh",
+			script: `echo "k2=${k1}"`,
+			opt:    "shell=sh",
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"k2": node.String("1"),
+				}),
+			},
+		},
+		{
+			title:  "fail",
+			script: `echo err >&2; exit 3`,
+			err:    tree.ErrGenTemplate,
+		},
+		{
+			title:  "capture success",
+			script: `echo k2=2; echo warn >&2`,
+			opt:    "capture",
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"k2":                  node.String("2"),
+					tree.ShellKeyExitCode: node.Int(0),
+					tree.ShellKeyStderr:   node.String("warn\n"),
+				}),
+			},
+		},
+		{
+			title:  "capture failure",
+			script: `echo k2=2; echo err >&2; exit 3`,
+			opt:    "capture",
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					tree.ShellKeyExitCode: node.Int(3),
+					tree.ShellKeyStderr:   node.String("err\n"),
+				}),
+			},
+		},
+		{
+			title:  "bash by path",
+			script: `echo "k2=$(get k1)"`,
+			opt:    "shell=/bin/bash",
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					"k2": node.String("1"),
+				}),
+			},
+		},
+		{
+			title:  "capture error after stderr",
+			script: `printf err >&2; echo k2=too_long_output`,
+			opt:    "capture,max_output=4",
+			want: []*tree.N{
+				node.FromMap(map[string]node.Data{
+					tree.ShellKeyExitCode: node.Int(-1),
+					tree.ShellKeyStderr:   node.String("err\nstdout exceeds max_output 4 bytes"),
+				}),
+			},
+		},
+		{
+			title:  "timeout",
+			script: `sleep 5`,
+			opt:    "timeout=100ms",
+			err:    tree.ErrGenTemplate,
+		},
+		{
+			title:  "max_output",
+			script: `echo k2=too_long_output`,
+			opt:    "max_output=4",
+			err:    tree.ErrGenTemplate,
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			opt, err := tree.ParseShellOption(tc.opt)
+			if !assert.Nil(t, err) {
+				return
+			}
+			got, err := tree.GenerateAndParse(context.TODO(), n, tree.NewShellGenTemplateWithOption(tc.script, opt))
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			if !assert.Nil(t, err, errorx.AsString(err)) {
+				return
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	t.Run("capture timeout", func(t *testing.T) {
+		opt, err := tree.ParseShellOption("timeout=100ms,capture")
+		if !assert.Nil(t, err) {
+			return
+		}
+		got, err := tree.GenerateAndParse(context.TODO(), n, tree.NewShellGenTemplateWithOption(`sleep 5`, opt))
+		if !assert.Nil(t, err, errorx.AsString(err)) || !assert.Len(t, got, 1) {
+			return
+		}
+		code, _ := got[0].Get(tree.ShellKeyExitCode)
+		assert.Equal(t, node.Int(-1), code)
+		stderr, _ := got[0].Get(tree.ShellKeyStderr)
+		assert.Contains(t, stderr.(node.String).Raw(), "timeout")
+	})
+}
+
+func TestShellGenTemplateCache(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		counter = filepath.Join(dir, "counter")
+		target  = filepath.Join(dir, "target")
+		ctx     = tree.WithResultCache(context.TODO(), cachex.NewDiskCache(filepath.Join(dir, "cache")))
+		script  = `echo x >> ` + counter + `; echo "runs=$(wc -l < ` + counter + ` | tr -d ' ')"`
+	)
+	if !assert.Nil(t, os.WriteFile(target, []byte("v1"), 0600)) {
+		return
+	}
+	n := node.FromMap(map[string]node.Data{
+		node.KeyPath: node.String(target),
+	})
+	generate := func(t *testing.T, opt string) string {
+		t.Helper()
+		o, err := tree.ParseShellOption(opt)
+		if !assert.Nil(t, err) {
+			return ""
+		}
+		got, err := tree.GenerateAndParse(ctx, n, tree.NewShellGenTemplateWithOption(script, o))
+		if !assert.Nil(t, err, errorx.AsString(err)) || !assert.Len(t, got, 1) {
+			return ""
+		}
+		v, _ := got[0].Get("runs")
+		return v.(node.String).Raw()
+	}
+
+	assert.Equal(t, "1", generate(t, "cache"), "miss")
+	assert.Equal(t, "1", generate(t, "cache"), "hit")
+	assert.Equal(t, "2", generate(t, ""), "no cache option")
+	assert.Equal(t, "1", generate(t, "cache"), "still hit")
+
+	if !assert.Nil(t, os.WriteFile(target, []byte("v2 changed"), 0600)) {
+		return
+	}
+	assert.Equal(t, "3", generate(t, "cache"), "file changed")
+	assert.Equal(t, "3", generate(t, "cache"), "hit after change")
+}
+
+func TestProcGenTemplate(t *testing.T) {
+	var (
+		n    = node.FromMap(map[string]node.Data{"k": node.String("v")})
+		call = func(ctx context.Context, g tree.GenTemplate) ([]*tree.N, error) {
+			return tree.GenerateAndParse(ctx, n, g)
+		}
+		pid = func(t *testing.T, ns []*tree.N) node.Data {
+			if !assert.Len(t, ns, 1) {
+				return nil
+			}
+			d, _ := ns[0].Get("pid")
+			return d
+		}
+	)
+
+	t.Run("echo", func(t *testing.T) {
+		p := tree.NewProcPool(1)
+		defer p.Close()
+		got, err := call(tree.WithProcPool(context.TODO(), p), tree.NewProcGenTemplate("cat"))
+		if !assert.Nil(t, err, errorx.AsString(err)) {
+			return
+		}
+		assert.Equal(t, []*tree.N{n}, got)
+	})
+
+	t.Run("keep running", func(t *testing.T) {
+		var (
+			p   = tree.NewProcPool(1)
+			ctx = tree.WithProcPool(context.TODO(), p)
+			g   = tree.NewProcGenTemplate(`while read -r line ; do echo "pid=$$" ; done`)
+		)
+		defer p.Close()
+		first, err := call(ctx, g)
+		if !assert.Nil(t, err, errorx.AsString(err)) {
+			return
+		}
+		second, err := call(ctx, g)
+		if !assert.Nil(t, err, errorx.AsString(err)) {
+			return
+		}
+		assert.Equal(t, pid(t, first), pid(t, second))
+	})
+
+	t.Run("restart after exit", func(t *testing.T) {
+		var (
+			p   = tree.NewProcPool(1)
+			ctx = tree.WithProcPool(context.TODO(), p)
+			g   = tree.NewProcGenTemplate(`read -r line ; echo "pid=$$"`)
+		)
+		defer p.Close()
+		first, err := call(ctx, g)
+		if !assert.Nil(t, err, errorx.AsString(err)) {
+			return
+		}
+		second, err := call(ctx, g)
+		if !assert.Nil(t, err, errorx.AsString(err)) {
+			return
+		}
+		assert.NotEqual(t, pid(t, first), pid(t, second))
+	})
+
+	t.Run("exit without response", func(t *testing.T) {
+		p := tree.NewProcPool(1)
+		defer p.Close()
+		_, err := call(tree.WithProcPool(context.TODO(), p), tree.NewProcGenTemplate(`read -r line ; exit 1`))
+		assert.ErrorIs(t, err, tree.ErrGenTemplate)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		p := tree.NewProcPool(1)
+		defer p.Close()
+		g := tree.NewProcGenTemplateWithOption(`read -r line ; sleep 10`, &tree.ProcOption{
+			Shell:   "bash",
+			Timeout: 100 * time.Millisecond,
+		})
+		start := time.Now()
+		_, err := call(tree.WithProcPool(context.TODO(), p), g)
+		assert.ErrorIs(t, err, tree.ErrGenTemplate)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+}
+
+func TestParseProcOption(t *testing.T) {
+	for _, tc := range []struct {
+		title string
+		s     string
+		want  *tree.ProcOption
+		err   error
+	}{
+		{
+			title: "empty",
+			s:     "",
+			want: &tree.ProcOption{
+				Shell: "bash",
+			},
+		},
+		{
+			title: "all",
+			s:     "shell=zsh,timeout=1m",
+			want: &tree.ProcOption{
+				Shell:   "zsh",
+				Timeout: time.Minute,
+			},
+		},
+		{
+			title: "invalid timeout",
+			s:     "timeout=1",
+			err:   tree.ErrInvalidArgument,
+		},
+		{
+			title: "unknown",
+			s:     "capture",
+			err:   tree.ErrInvalidArgument,
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			got, err := tree.ParseProcOption(tc.s)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseShellOption(t *testing.T) {
+	for _, tc := range []struct {
+		title string
+		s     string
+		want  *tree.ShellOption
+		err   error
+	}{
+		{
+			title: "empty",
+			s:     "",
+			want: &tree.ShellOption{
+				Shell: "bash",
+			},
+		},
+		{
+			title: "all",
+			s:     "shell=zsh,timeout=1m,max_output=100,capture,cache",
+			want: &tree.ShellOption{
+				Shell:     "zsh",
+				Timeout:   time.Minute,
+				MaxOutput: 100,
+				Capture:   true,
+				Cache:     true,
+			},
+		},
+		{
+			title: "invalid timeout",
+			s:     "timeout=1",
+			err:   tree.ErrInvalidArgument,
+		},
+		{
+			title: "invalid max_output",
+			s:     "max_output=-1",
+			err:   tree.ErrInvalidArgument,
+		},
+		{
+			title: "unknown",
+			s:     "retry=1",
+			err:   tree.ErrInvalidArgument,
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			got, err := tree.ParseShellOption(tc.s)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
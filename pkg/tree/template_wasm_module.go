@@ -0,0 +1,243 @@
+package tree
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/berquerant/ndql/pkg/cachex"
+	"github.com/berquerant/ndql/pkg/iox"
+	"github.com/berquerant/ndql/pkg/node"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+//
+// wasm gen template
+//
+// wasm(module_path, function)
+//
+// The module is a WASI module, compiled once per path and instantiated per call.
+// The function specified in function must be exported by the module and take no arguments.
+// The node is passed as JSON via stdin, and stdout is parsed by ParseGenResult.
+// The filesystem visible to the module contains only the path of the node.
+
+type WasmGenTemplate struct {
+	modulePath string
+	function   string
+}
+
+func NewWasmGenTemplate(modulePath, function string) *WasmGenTemplate {
+	return &WasmGenTemplate{
+		modulePath: modulePath,
+		function:   function,
+	}
+}
+
+var _ GenTemplate = &WasmGenTemplate{}
+
+const wasmGenTemplateInitializeFunction = "_initialize"
+
+type wasmRuntime struct {
+	runtime wazero.Runtime
+	cache   *cachex.Cache[wazero.CompiledModule]
+}
+
+// wasmGenTemplateRuntime is shared by all modules, created on first use.
+var wasmGenTemplateRuntime = sync.OnceValues(func() (*wasmRuntime, error) {
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		_ = r.Close(ctx)
+		return nil, err
+	}
+	c, err := cachex.NewWasmCache(r)
+	if err != nil {
+		_ = r.Close(ctx)
+		return nil, err
+	}
+	return &wasmRuntime{
+		runtime: r,
+		cache:   c,
+	}, nil
+})
+
+func (g WasmGenTemplate) Generate(ctx context.Context, n *N) ([]byte, error) {
+	r, err := wasmGenTemplateRuntime()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create wasm runtime", errors.Join(ErrGenTemplate, err))
+	}
+	compiled, err := r.cache.Get(g.modulePath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to compile wasm module %s", errors.Join(ErrGenTemplate, err), g.modulePath)
+	}
+	input, err := json.Marshal(n)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to marshal node", errors.Join(ErrGenTemplate, err))
+	}
+
+	var stdout bytes.Buffer
+	config := wazero.NewModuleConfig().
+		WithName(""). // allow concurrent instances
+		WithArgs(filepath.Base(g.modulePath)).
+		WithStartFunctions().
+		WithStdin(bytes.NewReader(input)).
+		WithStdout(&stdout).
+		WithStderr(os.Stderr).
+		WithFSConfig(wazero.NewFSConfig().WithFSMount(newWasmNodeFS(iox.FSFromContext(ctx), n), "/"))
+	mod, err := r.runtime.InstantiateModule(ctx, compiled, config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to instantiate wasm module %s", errors.Join(ErrGenTemplate, err), g.modulePath)
+	}
+	defer func() {
+		_ = mod.Close(context.Background())
+	}()
+
+	if f := mod.ExportedFunction(wasmGenTemplateInitializeFunction); f != nil {
+		if _, err := f.Call(ctx); !isWasmSuccess(err) {
+			return nil, fmt.Errorf("%w: failed to initialize wasm module %s", errors.Join(ErrGenTemplate, err), g.modulePath)
+		}
+	}
+	f := mod.ExportedFunction(g.function)
+	if f == nil {
+		return nil, fmt.Errorf("%w: wasm function %s not found in %s", ErrGenTemplate, g.function, g.modulePath)
+	}
+	if len(f.Definition().ParamTypes()) != 0 {
+		return nil, fmt.Errorf("%w: wasm function %s should take no arguments", ErrGenTemplate, g.function)
+	}
+	slog.Debug("WasmGenTemplate", slog.String("module", g.modulePath), slog.String("function", g.function))
+	if _, err := f.Call(ctx); !isWasmSuccess(err) {
+		return nil, fmt.Errorf("%w: failed to call wasm function %s in %s", errors.Join(ErrGenTemplate, err), g.function, g.modulePath)
+	}
+	return bytes.TrimSpace(stdout.Bytes()), nil
+}
+
+// isWasmSuccess returns true if err is nil or the module exited with code 0.
+func isWasmSuccess(err error) bool {
+	if err == nil {
+		return true
+	}
+	var exitErr *sys.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == 0
+}
+
+// wasmNodeFS is a read-only filesystem that exposes only the path of the node and its ancestor directories.
+//
+// The path appears at the same path in the guest, relative paths are relative to the root.
+type wasmNodeFS struct {
+	fsys fs.FS
+	host string // path of the node in fsys
+	name string // path of the node in the guest, empty if nothing is exposed
+}
+
+func newWasmNodeFS(fsys fs.FS, n *N) *wasmNodeFS {
+	x := &wasmNodeFS{
+		fsys: fsys,
+	}
+	d, ok := n.Get(node.KeyPath)
+	if !ok {
+		return x
+	}
+	p, ok := d.AsOp().String()
+	if !ok || p.Raw() == "" {
+		return x
+	}
+	name := strings.TrimPrefix(path.Clean(filepath.ToSlash(p.Raw())), "/")
+	if name == "." || !fs.ValidPath(name) {
+		return x
+	}
+	x.host = p.Raw()
+	x.name = name
+	return x
+}
+
+var _ fs.FS = &wasmNodeFS{}
+
+func (w *wasmNodeFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	switch {
+	case w.name != "" && name == w.name:
+		return w.fsys.Open(w.host)
+	case w.name != "" && strings.HasPrefix(name, w.name+"/"):
+		// the node is a directory
+		return w.fsys.Open(filepath.Join(w.host, filepath.FromSlash(strings.TrimPrefix(name, w.name+"/"))))
+	case name == ".":
+		return w.ancestor(name, w.name)
+	case w.name != "" && strings.HasPrefix(w.name, name+"/"):
+		return w.ancestor(name, strings.TrimPrefix(w.name, name+"/"))
+	default:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+}
+
+// ancestor returns the directory name that contains only the first element of rest.
+func (w *wasmNodeFS) ancestor(name, rest string) (fs.File, error) {
+	d := &wasmAncestorDir{
+		name: path.Base(name),
+	}
+	if rest == "" {
+		return d, nil
+	}
+	child, _, found := strings.Cut(rest, "/")
+	if !found {
+		info, err := fs.Stat(w.fsys, w.host)
+		if err != nil {
+			return d, nil
+		}
+		d.entries = []fs.DirEntry{fs.FileInfoToDirEntry(info)}
+		return d, nil
+	}
+	d.entries = []fs.DirEntry{fs.FileInfoToDirEntry(&wasmAncestorDir{name: child})}
+	return d, nil
+}
+
+// wasmAncestorDir is an empty directory except the entries toward the node.
+type wasmAncestorDir struct {
+	name    string
+	entries []fs.DirEntry
+	offset  int
+}
+
+var (
+	_ fs.ReadDirFile = &wasmAncestorDir{}
+	_ fs.FileInfo    = &wasmAncestorDir{}
+)
+
+func (d *wasmAncestorDir) Stat() (fs.FileInfo, error) { return d, nil }
+func (d *wasmAncestorDir) Close() error               { return nil }
+func (d *wasmAncestorDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *wasmAncestorDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if count > 0 && len(rest) > count {
+		rest = rest[:count]
+	}
+	d.offset += len(rest)
+	if count > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	return rest, nil
+}
+
+func (d *wasmAncestorDir) Name() string     { return d.name }
+func (*wasmAncestorDir) Size() int64        { return 0 }
+func (*wasmAncestorDir) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (*wasmAncestorDir) ModTime() time.Time { return time.Time{} }
+func (*wasmAncestorDir) IsDir() bool        { return true }
+func (*wasmAncestorDir) Sys() any           { return nil }
@@ -0,0 +1,32 @@
+// Command wasm is a WASI module for the tests of the wasm function.
+//
+// It reads a node from stdin and writes the content of the path and whether other files are readable as JSON.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+func main() {
+	var n map[string]any
+	if err := json.NewDecoder(os.Stdin).Decode(&n); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if _, ok := n["fail"]; ok {
+		os.Exit(2)
+	}
+	p, _ := n["path"].(string)
+	b, err := os.ReadFile(p)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	_, err = os.ReadFile(n["other"].(string))
+	_ = json.NewEncoder(os.Stdout).Encode(map[string]any{
+		"content":        string(b),
+		"other_readable": err == nil,
+	})
+}
@@ -3,29 +3,29 @@ package tree
 import (
 	"context"
 	"errors"
-	"log/slog"
+	"sync"
 
+	"github.com/berquerant/ndql/pkg/iterx"
 	"github.com/berquerant/ndql/pkg/logx"
 	. "github.com/pingcap/tidb/pkg/parser/ast"
 	"golang.org/x/sync/errgroup"
 )
 
 // AsIter converts AST into a function and applies to the iterator.
+//
+// The rows that fail to be evaluated are dropped, the iteration stops when RowErrors of ctx
+// does not tolerate the error, and RowErrors.Err reports it.
 func AsIter(ctx context.Context, it NIter, n Node) (NIter, error) {
 	function, err := NewTreeVisitor(ctx).Visit(n)
 	if err != nil {
 		return nil, err
 	}
+	rowErrors := rowErrorsFromContext(ctx)
 	return func(yield func(*N) bool) {
 		for x := range it {
-			rs, err := function.CallAny(x)
-			if errors.Is(err, ErrIgnore) {
-				logx.Trace("ignore node", logx.Err(err))
-				continue
-			}
+			rs, err := callNode(function, rowErrors, x)
 			if err != nil {
-				slog.Debug("failed to yield node", logx.JSON("node", x), logx.Err(err))
-				continue
+				return
 			}
 			for _, r := range rs {
 				if !yield(r) {
@@ -48,8 +48,9 @@ func AsChan(ctx context.Context, it NIter, n Node, concurrency int, recvC chan<-
 	}
 
 	var (
-		sendC    = make(chan *N, 100)
-		eg, eCtx = errgroup.WithContext(ctx)
+		sendC     = make(chan *N, 100)
+		eg, eCtx  = errgroup.WithContext(ctx)
+		rowErrors = rowErrorsFromContext(ctx)
 	)
 	for range concurrency {
 		eg.Go(func() error {
@@ -58,21 +59,15 @@ func AsChan(ctx context.Context, it NIter, n Node, concurrency int, recvC chan<-
 				case <-eCtx.Done():
 					return eCtx.Err()
 				default:
-					rs, err := function.CallAny(x)
-					if errors.Is(err, ErrIgnore) {
-						logx.Trace("ignore node", logx.Err(err))
-						continue
-					}
+					rs, err := callNode(function, rowErrors, x)
 					if err != nil {
-						slog.Debug("failed to yield node", logx.JSON("node", x), logx.Err(err))
-						continue
+						return err
 					}
 					for _, r := range rs {
 						select {
 						case <-eCtx.Done():
 							return eCtx.Err()
-						default:
-							recvC <- r
+						case recvC <- r:
 						}
 					}
 				}
@@ -84,12 +79,154 @@ func AsChan(ctx context.Context, it NIter, n Node, concurrency int, recvC chan<-
 	for x := range it {
 		select {
 		case <-eCtx.Done():
+			close(sendC)
+			if err := eg.Wait(); err != nil {
+				return err
+			}
 			return eCtx.Err()
-		default:
-			sendC <- x
+		case sendC <- x:
 		}
 	}
 	close(sendC)
 
 	return eg.Wait()
 }
+
+// DefaultOrderWindow is the default number of the inputs of AsOrderedChan processed or buffered at once.
+const DefaultOrderWindow = 1024
+
+// AsOrderedChan is AsChan that sends the results in the order of the inputs, the same order as AsIter.
+//
+// The inputs are tagged with sequence numbers and the results are reordered in a buffer.
+// At most window inputs are processed or buffered at once, so a slow input stalls the others
+// when the window is full; window less than 1 means DefaultOrderWindow.
+func AsOrderedChan(ctx context.Context, it NIter, n Node, concurrency, window int, recvC chan<- *N) error {
+	defer close(recvC)
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if window < 1 {
+		window = DefaultOrderWindow
+	}
+	window = max(window, concurrency)
+	function, err := NewTreeVisitor(ctx).Visit(n)
+	if err != nil {
+		return err
+	}
+
+	type (
+		task struct {
+			seq  int
+			node *N
+		}
+		result struct {
+			seq   int
+			nodes []*N
+		}
+	)
+	var (
+		sendC     = make(chan task)
+		resultC   = make(chan result, window)
+		tokenC    = make(chan struct{}, window) // bounds the inputs in flight
+		eg, eCtx  = errgroup.WithContext(ctx)
+		workers   sync.WaitGroup
+		rowErrors = rowErrorsFromContext(ctx)
+	)
+
+	// feed the inputs with the sequence numbers
+	eg.Go(func() error {
+		defer close(sendC)
+		var seq int
+		for x := range it {
+			select {
+			case <-eCtx.Done():
+				return eCtx.Err()
+			case tokenC <- struct{}{}:
+			}
+			select {
+			case <-eCtx.Done():
+				return eCtx.Err()
+			case sendC <- task{seq: seq, node: x}:
+			}
+			seq++
+		}
+		return nil
+	})
+
+	for range concurrency {
+		workers.Add(1)
+		eg.Go(func() error {
+			defer workers.Done()
+			for x := range sendC {
+				if err := eCtx.Err(); err != nil {
+					return err
+				}
+				rs, err := callNode(function, rowErrors, x.node)
+				if err != nil {
+					return err
+				}
+				// an empty result also advances the sequence
+				select {
+				case <-eCtx.Done():
+					return eCtx.Err()
+				case resultC <- result{seq: x.seq, nodes: rs}:
+				}
+			}
+			return nil
+		})
+	}
+	eg.Go(func() error {
+		workers.Wait()
+		close(resultC)
+		return nil
+	})
+
+	// reorder the results
+	eg.Go(func() error {
+		var (
+			next    int
+			pending = map[int][]*N{}
+		)
+		for r := range resultC {
+			pending[r.seq] = r.nodes
+			for {
+				rs, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				for _, x := range rs {
+					select {
+					case <-eCtx.Done():
+						return eCtx.Err()
+					case recvC <- x:
+					}
+				}
+				<-tokenC
+			}
+		}
+		return nil
+	})
+
+	return eg.Wait()
+}
+
+// callNode applies the function to the node.
+// It returns no nodes if the node is ignored or fails to be evaluated,
+// and an error if RowErrors does not tolerate the failure.
+func callNode(function iterx.Function[*N], rowErrors *RowErrors, x *N) ([]*N, error) {
+	rs, err := function.CallAny(x)
+	if errors.Is(err, ErrIgnore) {
+		logx.Trace("ignore node", logx.Err(err))
+		return nil, nil
+	}
+	if err != nil {
+		if err := rowErrors.Add(x, err); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return rs, nil
+}
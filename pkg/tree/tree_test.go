@@ -104,6 +104,108 @@ func TestAsChan(t *testing.T) {
 	}
 }
 
+func TestAsOrderedChan(t *testing.T) {
+	newNodeList := func(n int) []*tree.N {
+		r := make([]*tree.N, n)
+		for i := range n {
+			r[i] = node.FromMap(map[string]node.Data{
+				"i": node.Int(i),
+			})
+		}
+		return r
+	}
+	for _, tc := range []struct {
+		title       string
+		query       string
+		concurrency int
+		window      int
+		n           int
+		want        func([]*tree.N) []*tree.N
+	}{
+		{
+			title:       "empty",
+			query:       `select *`,
+			concurrency: 4,
+			n:           0,
+		},
+		{
+			title:       "sequential",
+			query:       `select *`,
+			concurrency: 1,
+			n:           100,
+		},
+		{
+			title:       "concurrent",
+			query:       `select *`,
+			concurrency: 4,
+			n:           1000,
+		},
+		{
+			title:       "small window",
+			query:       `select *`,
+			concurrency: 4,
+			window:      2,
+			n:           1000,
+		},
+		{
+			title:       "dropped rows",
+			query:       `select * where i % 3 = 0`,
+			concurrency: 4,
+			window:      5,
+			n:           1000,
+			want: func(xs []*tree.N) []*tree.N {
+				var r []*tree.N
+				for i, x := range xs {
+					if i%3 == 0 {
+						r = append(r, x)
+					}
+				}
+				return r
+			},
+		},
+		{
+			title:       "generator",
+			query:       `select sh("sleep 0.0$(( $(get i) % 3 )); echo i=$(get i)")`,
+			concurrency: 4,
+			n:           20,
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			r, err := parse.NewSQLParser().Parse(tc.query)
+			if !assert.Nil(t, err, "query syntax: %s", errorx.AsString(err)) {
+				return
+			}
+			var (
+				data  = newNodeList(tc.n)
+				gotC  = make(chan *tree.N, 100)
+				got   = []*tree.N{}
+				doneC = make(chan struct{})
+			)
+			go func() {
+				for x := range gotC {
+					got = append(got, x)
+				}
+				close(doneC)
+			}()
+			err = tree.AsOrderedChan(context.TODO(), slices.Values(data), r.Nodes[0], tc.concurrency, tc.window, gotC)
+			<-doneC
+			if !assert.Nil(t, err, errorx.AsString(err)) {
+				return
+			}
+			want := data
+			if tc.want != nil {
+				want = tc.want(data)
+			}
+			if !assert.Equal(t, len(want), len(got)) {
+				return
+			}
+			for i := range want {
+				assert.Equal(t, fmt.Sprint(util.MustOK(want[i].Get("i")).Any()), fmt.Sprint(util.MustOK(got[i].Get("i")).Any()))
+			}
+		})
+	}
+}
+
 func TestAsIter(t *testing.T) {
 	const (
 		testEnvKey   = "TestEnvKey1"
@@ -118,6 +220,8 @@ func TestAsIter(t *testing.T) {
 		exprFile       = filepath.Join(t.TempDir(), "expr.txt")
 		exprJSONFile   = filepath.Join(t.TempDir(), "expr_json.txt")
 		grepTargetFile = filepath.Join(t.TempDir(), "grep_target.txt")
+		contentFile    = filepath.Join(t.TempDir(), "content.txt")
+		binaryFile     = filepath.Join(t.TempDir(), "binary.bin")
 	)
 	const (
 		tmplFileContent = `k2={{ .k1 }}`
@@ -131,6 +235,8 @@ end`
 a_key2=av2
 b_key1=bv1
 b_key2=bv2`
+		contentFileContent = "line1\r\nline2\nline3"
+		binaryFileContent  = "\x89PNG\r\n\x1a\n\x00\x00"
 	)
 	for _, x := range []struct {
 		name    string
@@ -142,6 +248,8 @@ b_key2=bv2`
 		{name: exprFile, content: exprFileContent},
 		{name: exprJSONFile, content: exprJSONFileContent},
 		{name: grepTargetFile, content: grepTargetFileContent},
+		{name: contentFile, content: contentFileContent},
+		{name: binaryFile, content: binaryFileContent},
 	} {
 		if !assert.Nil(t, os.WriteFile(x.name, []byte(x.content), 0644)) {
 			return
@@ -399,6 +507,22 @@ end as k1`,
 				},
 			}),
 		},
+		{
+			title: "grep with option",
+			data: newNodes([]map[string]node.Data{
+				{
+					node.KeyPath: node.String(grepTargetFile),
+				},
+			}),
+			query: `select k, line, grep_before from (select grep("B_(?P<k>[^=]+)=", "k=$k,line=$line", "i,max=1,B=1"))`,
+			want: newNodes([]map[string]node.Data{
+				{
+					"k":                node.String("key1"),
+					"line":             node.String("3"),
+					tree.GrepKeyBefore: node.String("a_key2=av2"),
+				},
+			}),
+		},
 		{
 			title: "grep",
 			data: newNodes([]map[string]node.Data{
@@ -544,6 +668,98 @@ end", "f")`,
 				},
 			}),
 		},
+		{
+			title: "proc",
+			data: newNodes([]map[string]node.Data{
+				{
+					"k1": node.String("v1"),
+				},
+				{
+					"k1": node.String("v2"),
+				},
+			}),
+			query: `select proc("while read -r line ; do echo k2=x ; done")`,
+			want: newNodes([]map[string]node.Data{
+				{
+					"k1": node.String("v1"),
+					"k2": node.String("x"),
+				},
+				{
+					"k1": node.String("v2"),
+					"k2": node.String("x"),
+				},
+			}),
+		},
+		{
+			title: "star",
+			data: newNodes([]map[string]node.Data{
+				{
+					"k1": node.String("v1"),
+				},
+			}),
+			query: `select star("def f(n):
+  return [{'k2': n['k1']}, {'k2': n['k1'] + '2'}]", "f")`,
+			want: newNodes([]map[string]node.Data{
+				{
+					"k1": node.String("v1"),
+					"k2": node.String("v1"),
+				},
+				{
+					"k1": node.String("v1"),
+					"k2": node.String("v12"),
+				},
+			}),
+		},
+		{
+			title: "expr_value in where and select",
+			data: newNodes([]map[string]node.Data{
+				{
+					"k1": node.Int(1),
+					"p":  node.String("a.log"),
+				},
+				{
+					"k1": node.Int(2),
+					"p":  node.String("b.txt"),
+				},
+			}),
+			query: `select expr_value('n.k1 * 10') as k2 where expr_value('n.k1 > 0 && n.p endsWith ".log"')`,
+			want: newNodes([]map[string]node.Data{
+				{
+					"k2": node.Int(10),
+				},
+			}),
+		},
+		{
+			title: "lua_value in where and select",
+			data: newNodes([]map[string]node.Data{
+				{
+					"k1": node.Int(1),
+				},
+				{
+					"k1": node.Int(2),
+				},
+			}),
+			query: `select lua_value('function f(n) return n.k1 / 2 end', 'f') as k2 where lua_value('function f(n) return n.k1 > 1 end', 'f')`,
+			want: newNodes([]map[string]node.Data{
+				{
+					"k2": node.Int(1),
+				},
+			}),
+		},
+		{
+			title: "lua_value nil",
+			data: newNodes([]map[string]node.Data{
+				{
+					"k1": node.Int(1),
+				},
+			}),
+			query: `select lua_value('function f(n) return nil end', 'f') as k2`,
+			want: newNodes([]map[string]node.Data{
+				{
+					"k2": node.NewNull(),
+				},
+			}),
+		},
 		{
 			title: "sh",
 			data: newNodes([]map[string]node.Data{
@@ -1598,6 +1814,137 @@ relpath(k1, "/root") as k5`,
 				},
 			}),
 		},
+		{
+			title: "content",
+			data: newNodes([]map[string]node.Data{
+				{
+					"path": node.String(contentFile),
+				},
+			}),
+			query: `select line_count(path) as k1,
+file_hash(path) as k2,
+file_hash(path, "md5") as k3,
+file_hash(path, "xxh64") as k4,
+mime_type(path) as k5,
+is_binary(path) as k6,
+encoding(path) as k7,
+first_line(path) as k8`,
+			want: newNodes([]map[string]node.Data{
+				{
+					"k1": node.Int(3),
+					"k2": node.String("664f65bf8a4b56fa7a0e56a7944c0b0e3cbddcd783d3d2d80de7176e2eccb4ac"),
+					"k3": node.String("b5053af53c0ec5848ebcf6653854b9e7"),
+					"k4": node.String("4a6ce18ac9a740ea"),
+					"k5": node.String("text/plain"),
+					"k6": node.Bool(false),
+					"k7": node.String("ascii"),
+					"k8": node.String("line1"),
+				},
+			}),
+		},
+		{
+			title: "content binary",
+			data: newNodes([]map[string]node.Data{
+				{
+					"path": node.String(binaryFile),
+				},
+			}),
+			query: `select mime_type(path) as k1, is_binary(path) as k2, encoding(path) as k3`,
+			want: newNodes([]map[string]node.Data{
+				{
+					"k1": node.String("image/png"),
+					"k2": node.Bool(true),
+					"k3": node.String("binary"),
+				},
+			}),
+		},
+		{
+			title: "read_file",
+			data: newNodes([]map[string]node.Data{
+				{
+					"path": node.String(contentFile),
+				},
+			}),
+			query: `select read_file(path) as k1, read_file(path, 5) as k2 where read_file(path) like "%line2%"`,
+			want: newNodes([]map[string]node.Data{
+				{
+					"k1": node.String("line1\r\nline2\nline3"),
+					"k2": node.String("line1"),
+				},
+			}),
+		},
+		{
+			title: "lines",
+			data: newNodes([]map[string]node.Data{
+				{
+					"path": node.String(contentFile),
+				},
+			}),
+			query: `select line_no, line from (select read_lines(path))`,
+			want: newNodes([]map[string]node.Data{
+				{
+					"line_no": node.Int(1),
+					"line":    node.String("line1"),
+				},
+				{
+					"line_no": node.Int(2),
+					"line":    node.String("line2"),
+				},
+				{
+					"line_no": node.Int(3),
+					"line":    node.String("line3"),
+				},
+			}),
+		},
+		{
+			title: "lines filtered",
+			data: newNodes([]map[string]node.Data{
+				{
+					"path": node.String(contentFile),
+				},
+			}),
+			query: `select line_no, line from (select read_lines(path)) where line_no > 1`,
+			want: newNodes([]map[string]node.Data{
+				{
+					"line_no": node.Int(2),
+					"line":    node.String("line2"),
+				},
+				{
+					"line_no": node.Int(3),
+					"line":    node.String("line3"),
+				},
+			}),
+		},
+		{
+			title: "lines quoted",
+			data: newNodes([]map[string]node.Data{
+				{
+					"path": node.String(contentFile),
+				},
+			}),
+			query: "select line from (select `lines`(path))",
+			want: newNodes([]map[string]node.Data{
+				{
+					"line": node.String("line1"),
+				},
+				{
+					"line": node.String("line2"),
+				},
+				{
+					"line": node.String("line3"),
+				},
+			}),
+		},
+		{
+			title: "content unknown hash",
+			data: newNodes([]map[string]node.Data{
+				{
+					"path": node.String(contentFile),
+				},
+			}),
+			query: `select file_hash(path, "crc") as k1`,
+			want:  nil,
+		},
 	} {
 		t.Run(tc.title, func(t *testing.T) {
 			r, err := parse.NewSQLParser().Parse(tc.query)
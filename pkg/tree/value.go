@@ -23,6 +23,14 @@ func (v TreeVisitor) VisitValueExpr(n ValueExpr) (NFunction, error) {
 		return ReturnContainerValue("ValueExpr", func(_ ND) (ND, error) {
 			return x, nil
 		}), nil
+	case *driver.ParamMarkerExpr:
+		x, err := v.visitParamMarkerExpr(n)
+		if err != nil {
+			return nil, err
+		}
+		return ReturnContainerValue("ParamMarkerExpr", func(_ ND) (ND, error) {
+			return x, nil
+		}), nil
 	default:
 		return nil, v.notImplemented(n, "unknown ValueExpr")
 	}
@@ -127,6 +135,8 @@ func (v TreeVisitor) visitValueExpr(n ExprNode) (ND, error) {
 			return nil, v.invalidValue(n, "cannot get container value from value expr")
 		}
 		return e, nil
+	case *VariableExpr:
+		return v.visitVariableExpr(n)
 	default:
 		return nil, v.notImplemented(n, "want ValueExpr")
 	}
@@ -0,0 +1,215 @@
+package tree
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/berquerant/ndql/pkg/node"
+	. "github.com/pingcap/tidb/pkg/parser/ast"
+	driver "github.com/pingcap/tidb/pkg/types/parser_driver"
+)
+
+// Variables are the values of the user variables like @name, the name is without @.
+//
+// The placeholder ? is the variable named by its 1-based position in the query like "1".
+// The parameters are the read-only variables, SET cannot change them.
+type Variables struct {
+	mux    sync.RWMutex
+	m      map[string]ND
+	params map[string]ND
+}
+
+func NewVariables() *Variables {
+	return &Variables{
+		m:      map[string]ND{},
+		params: map[string]ND{},
+	}
+}
+
+// Set sets the value of the variable, the name is case-insensitive.
+func (v *Variables) Set(name string, value ND) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	v.m[strings.ToLower(name)] = value
+}
+
+// SetParam sets the value of the parameter, the name is case-insensitive.
+// The parameter takes precedence over the variable of the same name.
+func (v *Variables) SetParam(name string, value ND) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	v.params[strings.ToLower(name)] = value
+}
+
+// IsParam returns true if the name is of a parameter.
+func (v *Variables) IsParam(name string) bool {
+	v.mux.RLock()
+	defer v.mux.RUnlock()
+	_, ok := v.params[strings.ToLower(name)]
+	return ok
+}
+
+func (v *Variables) Get(name string) (ND, bool) {
+	v.mux.RLock()
+	defer v.mux.RUnlock()
+	name = strings.ToLower(name)
+	if x, ok := v.params[name]; ok {
+		return x, true
+	}
+	x, ok := v.m[name]
+	return x, ok
+}
+
+// Names returns the names of the variables and the parameters, sorted.
+func (v *Variables) Names() []string {
+	v.mux.RLock()
+	defer v.mux.RUnlock()
+	names := slices.Collect(maps.Keys(v.m))
+	for k := range v.params {
+		if _, ok := v.m[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Merge sets the variables and the parameters of x.
+func (v *Variables) Merge(x *Variables) {
+	x.mux.RLock()
+	defer x.mux.RUnlock()
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	maps.Copy(v.m, x.m)
+	maps.Copy(v.params, x.params)
+}
+
+func (v *Variables) Clone() *Variables {
+	v.mux.RLock()
+	defer v.mux.RUnlock()
+	return &Variables{
+		m:      maps.Clone(v.m),
+		params: maps.Clone(v.params),
+	}
+}
+
+// ParseParam parses "name=value" into the name and the value.
+// The type of the value is inferred like JSON, and the value is a String if it is not a JSON.
+func ParseParam(s string) (string, ND, error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return "", nil, fmt.Errorf("%w: param should be name=value but got %q", ErrInvalidArgument, s)
+	}
+	return name, InferParamValue(value), nil
+}
+
+// InferParamValue infers the type of the value like JSON, or returns a String.
+func InferParamValue(s string) ND {
+	var x node.Op
+	if err := json.Unmarshal([]byte(s), &x); err == nil {
+		return x.AsData()
+	}
+	b, _ := json.Marshal(s)
+	if err := json.Unmarshal(b, &x); err == nil {
+		return x.AsData()
+	}
+	return node.String(s)
+}
+
+type variablesContextKey struct{}
+
+// WithVariables returns a context that resolves the variables and the placeholders with v.
+func WithVariables(ctx context.Context, v *Variables) context.Context {
+	return context.WithValue(ctx, variablesContextKey{}, v)
+}
+
+func variablesFromContext(ctx context.Context) (*Variables, bool) {
+	v, ok := ctx.Value(variablesContextKey{}).(*Variables)
+	return v, ok
+}
+
+func (v TreeVisitor) getVariable(n Node, name, display string) (ND, error) {
+	if vs, ok := variablesFromContext(v.ctx); ok {
+		if x, ok := vs.Get(name); ok {
+			return x, nil
+		}
+	}
+	return nil, v.newErr(ErrInvalidKey, n, "undefined %s", display)
+}
+
+// @NAME
+func (v TreeVisitor) VisitVariableExpr(n *VariableExpr) (NFunction, error) {
+	x, err := v.visitVariableExpr(n)
+	if err != nil {
+		return nil, err
+	}
+	return ReturnContainerValue("VariableExpr", func(_ ND) (ND, error) {
+		return x, nil
+	}), nil
+}
+
+func (v TreeVisitor) visitVariableExpr(n *VariableExpr) (ND, error) {
+	if n.IsSystem {
+		return nil, v.notImplemented(n, "system variable")
+	}
+	if n.Value != nil {
+		return nil, v.notImplemented(n, "variable assignment in expression")
+	}
+	return v.getVariable(n, n.Name, "variable @"+n.Name)
+}
+
+// ?
+func (v TreeVisitor) visitParamMarkerExpr(n *driver.ParamMarkerExpr) (ND, error) {
+	name := strconv.Itoa(n.Order + 1)
+	return v.getVariable(n, name, "placeholder ? at "+name)
+}
+
+// SetVariables evaluates the SET statement and stores the values into the variables of the context.
+func SetVariables(ctx context.Context, n *SetStmt) error {
+	return NewTreeVisitor(ctx).VisitSetStmt(n)
+}
+
+// SET @NAME = EXPR, ...
+func (v TreeVisitor) VisitSetStmt(n *SetStmt) error {
+	vs, ok := variablesFromContext(v.ctx)
+	if !ok {
+		return v.notImplemented(n, "no variables")
+	}
+	for i, x := range n.Variables {
+		if x.IsSystem {
+			return v.notImplemented(n, "SetStmt[%d] system variable %s", i, x.Name)
+		}
+		if vs.IsParam(x.Name) {
+			return v.invalidValue(n, "SetStmt[%d] %s is a parameter and cannot be changed", i, x.Name)
+		}
+		f, err := v.VisitExpr(x.Value)
+		if err != nil {
+			return v.newErr(err, n, "SetStmt[%d] %s", i, x.Name)
+		}
+		dummy := AsValueContainer(node.New())
+		dummy.SetContainerValue(node.String("dummy"))
+		rs, err := f.CallAny(dummy.N)
+		if errors.Is(err, ErrInvalidKey) {
+			return v.invalidValue(n, "SetStmt[%d] %s cannot refer to the columns: %v", i, x.Name, err)
+		}
+		if err != nil {
+			return v.newErr(err, n, "SetStmt[%d] %s evaluation", i, x.Name)
+		}
+		if len(rs) != 1 {
+			return v.invalidValue(n, "SetStmt[%d] %s should be a value but got %d values", i, x.Name, len(rs))
+		}
+		_, d, ok := AsValueContainer(rs[0]).GetFirstValue()
+		if !ok {
+			return v.invalidValue(n, "SetStmt[%d] %s contain no value", i, x.Name)
+		}
+		vs.Set(x.Name, d)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package tree_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/berquerant/ndql/pkg/errorx"
+	"github.com/berquerant/ndql/pkg/node"
+	"github.com/berquerant/ndql/pkg/parse"
+	"github.com/berquerant/ndql/pkg/tree"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInferParamValue(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  node.Data
+	}{
+		{input: "1", want: node.Int(1)},
+		{input: "1.5", want: node.Float(1.5)},
+		{input: "true", want: node.Bool(true)},
+		{input: "null", want: node.NewNull()},
+		{input: `"1"`, want: node.String("1")},
+		{input: "1h", want: node.Duration(time.Hour)},
+		{input: "2024-01-02 03:04:05", want: node.Time(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))},
+		{input: "it's", want: node.String("it's")},
+		{input: "", want: node.String("")},
+	} {
+		t.Run(tc.input, func(t *testing.T) {
+			assert.Equal(t, tc.want, tree.InferParamValue(tc.input))
+		})
+	}
+}
+
+func TestParseParam(t *testing.T) {
+	name, value, err := tree.ParseParam("k=a=b")
+	assert.Nil(t, err)
+	assert.Equal(t, "k", name)
+	assert.Equal(t, node.String("a=b"), value)
+
+	_, _, err = tree.ParseParam("k")
+	assert.ErrorIs(t, err, tree.ErrInvalidArgument)
+}
+
+func TestVariables(t *testing.T) {
+	data := newNodes([]map[string]node.Data{
+		{"k1": node.String("a.txt"), "k2": node.Int(1)},
+		{"k1": node.String("b.go"), "k2": node.Int(2)},
+		{"k1": node.String("c.go"), "k2": node.Int(3)},
+	})
+	for _, tc := range []struct {
+		title  string
+		params map[string]node.Data
+		query  string
+		want   []*tree.N
+		err    error
+	}{
+		{
+			title:  "placeholders",
+			params: map[string]node.Data{"1": node.Int(1), "2": node.String("%.go")},
+			query:  `select k1 where k2 > ? and k1 like ?`,
+			want: newNodes([]map[string]node.Data{
+				{"k1": node.String("b.go")},
+				{"k1": node.String("c.go")},
+			}),
+		},
+		{
+			title:  "named placeholders",
+			params: map[string]node.Data{"lo": node.Int(2), "hi": node.Int(3), "ext": node.String("c.go")},
+			query:  `select k1 where k2 between :lo and :hi and k1 in (:ext, "x")`,
+			want: newNodes([]map[string]node.Data{
+				{"k1": node.String("c.go")},
+			}),
+		},
+		{
+			title: "set",
+			query: `set @lo = 1 + 1, @Pattern = lower("%.GO"); select k1, @lo as lo where k2 >= @lo and k1 like @pattern`,
+			want: newNodes([]map[string]node.Data{
+				{"k1": node.String("b.go"), "lo": node.Int(2)},
+				{"k1": node.String("c.go"), "lo": node.Int(2)},
+			}),
+		},
+		{
+			title:  "set param",
+			params: map[string]node.Data{"ext": node.String("b.go")},
+			query:  `set @ext = "c.go"; select k1 where k1 = :ext`,
+			err:    tree.ErrInvalidValue,
+		},
+		{
+			title:  "set other than params",
+			params: map[string]node.Data{"ext": node.String("b.go")},
+			query:  `set @lo = 3; select k1 where k1 = :ext or k2 = @lo`,
+			want: newNodes([]map[string]node.Data{
+				{"k1": node.String("b.go")},
+				{"k1": node.String("c.go")},
+			}),
+		},
+		{
+			title: "undefined variable",
+			query: `select @x`,
+			err:   tree.ErrInvalidKey,
+		},
+		{
+			title: "undefined placeholder",
+			query: `select ?`,
+			err:   tree.ErrInvalidKey,
+		},
+		{
+			title: "set with column",
+			query: `set @x = k1`,
+			err:   tree.ErrInvalidValue,
+		},
+	} {
+		t.Run(tc.title, func(t *testing.T) {
+			p, err := parse.NewSQLParser().Parse(tc.query)
+			if !assert.Nil(t, err, "query syntax: %s", errorx.AsString(err)) {
+				return
+			}
+			vs := tree.NewVariables()
+			for k, v := range tc.params {
+				vs.SetParam(k, v)
+			}
+			ctx := tree.WithVariables(context.TODO(), vs)
+			var got []*tree.N
+			err = func() error {
+				for _, n := range p.Nodes {
+					if x, ok := n.(*ast.SetStmt); ok {
+						if err := tree.SetVariables(ctx, x); err != nil {
+							return err
+						}
+						continue
+					}
+					it, err := tree.AsIter(ctx, slices.Values(data), n)
+					if err != nil {
+						return err
+					}
+					got = append(got, slices.Collect(it)...)
+				}
+				return nil
+			}()
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err, errorx.AsString(err))
+				return
+			}
+			if !assert.Nil(t, err, errorx.AsString(err)) {
+				return
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -15,7 +15,7 @@ import (
 //
 // ## Implementation Status
 //
-// - Statements: Currently, only the SELECT statement is implemented.
+// - Statements: Currently, only the SELECT and SET statements are implemented.
 // - Clauses: FROM and WHERE clauses are available. Other clauses (e.g., GROUP BY, ORDER BY, JOIN) are not yet supported.
 // - Operators, Functions: Some operators and functions are not yet implemented. Even if implemented, the behavior may differ from standard SQL specifications.
 //
@@ -48,6 +48,8 @@ import (
 // - `~`
 type TreeVisitor struct {
 	ctx context.Context
+	// call is the function call being built, for the errors of the function.
+	call *FuncCallExpr
 }
 
 func NewTreeVisitor(ctx context.Context) *TreeVisitor {
@@ -16,6 +16,8 @@ func (v TreeVisitor) VisitWhere(n ExprNode) (NFunction, error) {
 	if f.RetArity() != iterx.Unary {
 		return nil, v.newErr(ErrInvalidFunctionArity, n, "Where ret should be unary")
 	}
+	// the results except Bool and Null are row errors instead of being ignored when the errors are limited
+	limited := rowErrorsFromContext(v.ctx).Limited()
 	return iterx.NewMapFunction(func(x *N) (*N, error) {
 		r, err := f.CallAny(x)
 		if err != nil {
@@ -23,12 +25,20 @@ func (v TreeVisitor) VisitWhere(n ExprNode) (NFunction, error) {
 		}
 		_, rv, ok := AsValueContainer(r[0]).GetFirstValue()
 		if !ok {
+			if limited {
+				return nil, v.invalidValue(n, "Where got no value")
+			}
 			slog.Warn("Where got no value", logx.Verbose("node", n), logx.Verbose("input", x))
 			return nil, ErrIgnore
 		}
 		b, err := rv.AsOp().AsBool()
 		if err != nil {
-			slog.Warn("Where got not Bool value", logx.Verbose("node", n), logx.Verbose("input", x), logx.Verbose("value", rv), logx.Err(err))
+			if limited && !rv.AsOp().IsNull() {
+				return nil, v.invalidValue(n, "Where got not Bool value %s: %v", rv.Display(), err)
+			}
+			if !limited {
+				slog.Warn("Where got not Bool value", logx.Verbose("node", n), logx.Verbose("input", x), logx.Verbose("value", rv), logx.Err(err))
+			}
 			return nil, ErrIgnore
 		}
 		if !b.Raw() { // ignore the node because expr was evaluated as false
@@ -40,3 +40,21 @@ func Hash(v string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(v)))
 func TempDir(p ...string) string {
 	return filepath.Join(append([]string{os.TempDir(), "ndql"}, p...)...)
 }
+
+// CacheDir returns the path under the user cache directory, or under TempDir if unavailable.
+func CacheDir(p ...string) string {
+	d, err := os.UserCacheDir()
+	if err != nil {
+		return TempDir(append([]string{"cache"}, p...)...)
+	}
+	return filepath.Join(append([]string{d, "ndql"}, p...)...)
+}
+
+// ConfigDir returns the path under the user config directory, or empty if unavailable.
+func ConfigDir(p ...string) string {
+	d, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(append([]string{d, "ndql"}, p...)...)
+}